@@ -1,11 +1,15 @@
 // Package bbr contains code required to read BBR variables of a net.Conn
-// on which we're serving a WebSocket client. This code currently only
-// works on Linux systems, as BBR is only available there.
+// on which we're serving a WebSocket client, as well as the variables of the
+// other congestion control algorithms exporting them, i.e., Vegas and DCTCP.
+// This code currently only works on Linux systems, as BBR is only available
+// there.
 package bbr
 
 import (
 	"errors"
+	"math"
 	"os"
+	"syscall"
 
 	"github.com/m-lab/tcp-info/inetdiag"
 )
@@ -18,7 +22,57 @@ func Enable(fp *os.File) error {
 	return enableBBR(fp)
 }
 
+// SetCongestionControl sets the congestion control algorithm named |name|,
+// e.g., "cubic", on |fp|.
+func SetCongestionControl(fp *os.File, name string) error {
+	return setCongestionControl(fp, name)
+}
+
+// GetCongestionControl returns the name of the congestion control algorithm
+// currently in use on |fp|.
+func GetCongestionControl(fp *os.File) (string, error) {
+	return getCongestionControl(fp)
+}
+
+// SetMaxPacingRate caps the pacing rate of |fp| at |bytesPerSecond| using
+// SO_MAX_PACING_RATE. Rates above math.MaxInt32 are not supported.
+func SetMaxPacingRate(fp *os.File, bytesPerSecond int64) error {
+	if bytesPerSecond <= 0 || bytesPerSecond > math.MaxInt32 {
+		return syscall.EINVAL
+	}
+	return setMaxPacingRate(fp, bytesPerSecond)
+}
+
 // GetBBRInfo obtains BBR info from |fp|.
 func GetBBRInfo(fp *os.File) (inetdiag.BBRInfo, error) {
-	return getMaxBandwidthAndMinRTT(fp)
+	cc, err := GetCCInfo(fp)
+	if err != nil {
+		return inetdiag.BBRInfo{}, err
+	}
+	if cc.BBR == nil {
+		return inetdiag.BBRInfo{}, syscall.EINVAL
+	}
+	return cc.BBR.BBRInfo, nil
+}
+
+// GetCCInfo obtains the congestion control info of |fp|. It returns
+// ErrNoSupport if the congestion control algorithm does not export any
+// information, e.g., with cubic.
+func GetCCInfo(fp *os.File) (CCInfo, error) {
+	algorithm, err := getCongestionControl(fp)
+	if err != nil {
+		return CCInfo{}, err
+	}
+	return GetCCInfoOf(fp, algorithm)
+}
+
+// GetCCInfoOf is like GetCCInfo, except that |algorithm| is the name of the
+// congestion control algorithm of |fp|, as returned by GetCongestionControl.
+// Callers reading the info repeatedly can thus read the name only once.
+func GetCCInfoOf(fp *os.File, algorithm string) (CCInfo, error) {
+	b, err := getCCInfo(fp)
+	if err != nil {
+		return CCInfo{}, err
+	}
+	return parseCCInfo(algorithm, b)
 }
@@ -3,18 +3,21 @@ package bbr
 // #include <linux/inet_diag.h>
 // #include <netinet/ip.h>
 // #include <netinet/tcp.h>
+// #include <sys/socket.h>
 import "C"
 
 import (
-	"math"
 	"os"
+	"strings"
 	"syscall"
 	"unsafe"
-
-	"github.com/m-lab/tcp-info/inetdiag"
 )
 
 func enableBBR(fp *os.File) error {
+	return setCongestionControl(fp, "bbr")
+}
+
+func setCongestionControl(fp *os.File, name string) error {
 	rawconn, err := fp.SyscallConn()
 	if err != nil {
 		return err
@@ -22,7 +25,7 @@ func enableBBR(fp *os.File) error {
 	var syscallErr error
 	err = rawconn.Control(func(fd uintptr) {
 		// Note: Fd() returns uintptr but on Unix we can safely use int for sockets.
-		syscallErr = syscall.SetsockoptString(int(fd), syscall.IPPROTO_TCP, syscall.TCP_CONGESTION, "bbr")
+		syscallErr = syscall.SetsockoptString(int(fd), syscall.IPPROTO_TCP, syscall.TCP_CONGESTION, name)
 	})
 	if err != nil {
 		return err
@@ -30,13 +33,67 @@ func enableBBR(fp *os.File) error {
 	return syscallErr
 }
 
-func getMaxBandwidthAndMinRTT(fp *os.File) (inetdiag.BBRInfo, error) {
-	cci := C.union_tcp_cc_info{}
-	size := uint32(C.sizeof_union_tcp_cc_info)
-	metrics := inetdiag.BBRInfo{}
+func setMaxPacingRate(fp *os.File, bytesPerSecond int64) error {
+	rawconn, err := fp.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var syscallErr error
+	err = rawconn.Control(func(fd uintptr) {
+		syscallErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, C.SO_MAX_PACING_RATE, int(bytesPerSecond))
+	})
+	if err != nil {
+		return err
+	}
+	return syscallErr
+}
+
+// tcpCANameMax is the maximum length of a congestion control name, including
+// the terminating NUL. See TCP_CA_NAME_MAX in include/net/tcp.h.
+const tcpCANameMax = 16
+
+func getCongestionControl(fp *os.File) (string, error) {
+	var name [tcpCANameMax]byte
+	size := uint32(len(name))
+	rawconn, err := fp.SyscallConn()
+	if err != nil {
+		return "", err
+	}
+	var syscallErr syscall.Errno
+	err = rawconn.Control(func(fd uintptr) {
+		_, _, syscallErr = syscall.Syscall6(
+			uintptr(syscall.SYS_GETSOCKOPT),
+			fd,
+			uintptr(syscall.IPPROTO_TCP),
+			uintptr(syscall.TCP_CONGESTION),
+			uintptr(unsafe.Pointer(&name[0])),
+			uintptr(unsafe.Pointer(&size)),
+			uintptr(0))
+	})
+	if err != nil {
+		return "", err
+	}
+	if syscallErr != 0 {
+		return "", syscallErr
+	}
+	if size > uint32(len(name)) {
+		size = uint32(len(name))
+	}
+	// The kernel may include the terminating NUL in size.
+	return strings.TrimRight(string(name[:size]), "\x00"), nil
+}
+
+// maxCCInfoSize is large enough for every structure returned by TCP_CC_INFO
+// that we know of, including those of BBR versions newer than what is in
+// union tcp_cc_info of the system headers.
+const maxCCInfoSize = 64
+
+func getCCInfo(fp *os.File) ([]byte, error) {
+	var cci [maxCCInfoSize]byte
+	size := uint32(len(cci))
 	rawconn, rawConnErr := fp.SyscallConn()
 	if rawConnErr != nil {
-		return metrics, rawConnErr
+		return nil, rawConnErr
 	}
 	var syscallErr syscall.Errno
 	err := rawconn.Control(func(fd uintptr) {
@@ -45,42 +102,28 @@ func getMaxBandwidthAndMinRTT(fp *os.File) (inetdiag.BBRInfo, error) {
 			fd,
 			uintptr(C.IPPROTO_TCP),
 			uintptr(C.TCP_CC_INFO),
-			uintptr(unsafe.Pointer(&cci)),
+			uintptr(unsafe.Pointer(&cci[0])),
 			uintptr(unsafe.Pointer(&size)),
 			uintptr(0))
 	})
 	if err != nil {
-		return metrics, err
+		return nil, err
 	}
 	if syscallErr != 0 {
-		// C.get_bbr_info returns ENOSYS when the system does not support BBR. In
-		// such case let us map the error to ErrNoSupport, such that this Linux
-		// system looks like any other system where BBR is not available. This way
-		// the code for dealing with this error is not platform dependent.
+		// The kernel returns ENOSYS when the system does not support
+		// TCP_CC_INFO. In such case let us map the error to ErrNoSupport, such
+		// that this Linux system looks like any other system where BBR is not
+		// available. This way the code for dealing with this error is not
+		// platform dependent.
 		if syscallErr == syscall.ENOSYS {
-			return metrics, ErrNoSupport
+			return nil, ErrNoSupport
 		}
-		return metrics, syscallErr
-	}
-	// Apparently, tcp_bbr_info is the only congestion control data structure
-	// to occupy five 32 bit words. Currently, in September 2018, the other two
-	// data structures (i.e. Vegas and DCTCP) both occupy four 32 bit words.
-	//
-	// See include/uapi/linux/inet_diag.h in torvalds/linux@bbb6189d.
-	if size != C.sizeof_struct_tcp_bbr_info {
-		return metrics, syscall.EINVAL
+		return nil, syscallErr
 	}
-	bbrip := (*C.struct_tcp_bbr_info)(unsafe.Pointer(&cci[0]))
-	// Convert the values from the kernel provided units to the units that
-	// we're going to use in ndt7. The units we use are the most common ones
-	// in which people typically expects these variables.
-	maxbw := uint64(bbrip.bbr_bw_hi)<<32 | uint64(bbrip.bbr_bw_lo)
-	if maxbw > math.MaxInt64 {
-		return metrics, syscall.EOVERFLOW
+	if size > uint32(len(cci)) {
+		size = uint32(len(cci))
 	}
-	metrics.BW = int64(maxbw) // Java has no uint64
-	metrics.MinRTT = uint32(bbrip.bbr_min_rtt)
-	metrics.PacingGain = uint32(bbrip.bbr_pacing_gain)
-	metrics.CwndGain = uint32(bbrip.bbr_cwnd_gain)
-	return metrics, nil
+	// The layout of the data depends on the congestion control algorithm, see
+	// include/uapi/linux/inet_diag.h. The caller parses it.
+	return cci[:size], nil
 }
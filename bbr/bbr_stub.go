@@ -4,14 +4,24 @@ package bbr
 
 import (
 	"os"
-
-	"github.com/m-lab/tcp-info/inetdiag"
 )
 
 func enableBBR(*os.File) error {
 	return ErrNoSupport
 }
 
-func getMaxBandwidthAndMinRTT(*os.File) (inetdiag.BBRInfo, error) {
-	return inetdiag.BBRInfo{}, ErrNoSupport
+func setCongestionControl(*os.File, string) error {
+	return ErrNoSupport
+}
+
+func getCongestionControl(*os.File) (string, error) {
+	return "", ErrNoSupport
+}
+
+func setMaxPacingRate(*os.File, int64) error {
+	return ErrNoSupport
+}
+
+func getCCInfo(*os.File) ([]byte, error) {
+	return nil, ErrNoSupport
 }
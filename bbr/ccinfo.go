@@ -0,0 +1,165 @@
+package bbr
+
+import (
+	"encoding/binary"
+	"math"
+	"strings"
+	"syscall"
+
+	"github.com/m-lab/tcp-info/inetdiag"
+)
+
+// Kinds of congestion control information returned by TCP_CC_INFO. The kernel
+// does not say which structure it returns, so the kind is derived from the
+// name of the congestion control algorithm.
+const (
+	KindBBR   = "bbr"
+	KindVegas = "vegas"
+	KindDCTCP = "dctcp"
+)
+
+// CCInfo is the congestion control information of a socket. It is tagged by
+// Kind, and only the field corresponding to Kind is set.
+type CCInfo struct {
+	// Algorithm is the name of the congestion control algorithm, e.g., "bbr"
+	// or "illinois".
+	Algorithm string
+	// Kind is the kind of information returned by Algorithm, i.e., KindBBR,
+	// KindVegas or KindDCTCP.
+	Kind  string
+	BBR   *BBRInfo   `json:",omitempty"`
+	Vegas *VegasInfo `json:",omitempty"`
+	DCTCP *DCTCPInfo `json:",omitempty"`
+}
+
+// BBRInfo contains the variables of BBR. The embedded inetdiag.BBRInfo
+// contains the BBRv1 variables. Newer BBR versions also export the variables
+// that follow, which are zero when the kernel runs BBRv1.
+//
+// See struct tcp_bbr_info in include/uapi/linux/inet_diag.h of google/bbr.
+type BBRInfo struct {
+	inetdiag.BBRInfo
+	// BWHi and BWLo are the long-term and short-term bandwidth bounds.
+	BWHi int64 `json:",omitempty"`
+	BWLo int64 `json:",omitempty"`
+	// Mode and Phase are the state of the BBR state machine.
+	Mode  uint8 `json:",omitempty"`
+	Phase uint8 `json:",omitempty"`
+	// Version is the BBR version. It is zero for BBRv1.
+	Version uint8 `json:",omitempty"`
+	// InflightLo and InflightHi are the short-term and long-term bounds on the
+	// data in flight.
+	InflightLo uint32 `json:",omitempty"`
+	InflightHi uint32 `json:",omitempty"`
+	// ExtraAcked is the max excess packets ACKed in an epoch.
+	ExtraAcked uint32 `json:",omitempty"`
+}
+
+// VegasInfo contains the variables of Vegas and of the algorithms reusing its
+// structure, e.g., Illinois and NV. See struct tcpvegas_info.
+type VegasInfo struct {
+	Enabled  uint32
+	RTTCount uint32
+	RTT      uint32
+	MinRTT   uint32
+}
+
+// DCTCPInfo contains the variables of DCTCP. See struct tcp_dctcp_info.
+type DCTCPInfo struct {
+	Enabled uint16
+	CEState uint16
+	Alpha   uint32
+	ABECN   uint32
+	ABTotal uint32
+}
+
+// Sizes of the kernel structures. Newer BBR versions append fields to the
+// BBRv1 structure, so we accept any size at least as large as BBRv1.
+const (
+	sizeofBBRv1Info = 20
+	sizeofBBRv2Info = 40
+	sizeofBBRv3Info = 52
+	sizeofVegasInfo = 16
+	sizeofDCTCPInfo = 16
+)
+
+// kindOf returns the kind of information returned by algorithm, or an empty
+// string if algorithm does not return information that we understand.
+func kindOf(algorithm string) string {
+	switch {
+	case strings.HasPrefix(algorithm, "bbr"):
+		// "bbr", but also out-of-tree versions, e.g., "bbr2".
+		return KindBBR
+	case algorithm == "vegas", algorithm == "illinois", algorithm == "nv":
+		return KindVegas
+	case algorithm == "dctcp":
+		return KindDCTCP
+	}
+	return ""
+}
+
+// parseCCInfo parses b, the TCP_CC_INFO data returned for algorithm. The
+// values are kept in the units used by the kernel.
+func parseCCInfo(algorithm string, b []byte) (CCInfo, error) {
+	cc := CCInfo{Algorithm: algorithm, Kind: kindOf(algorithm)}
+	u32 := func(off int) uint32 {
+		return binary.NativeEndian.Uint32(b[off:])
+	}
+	u64 := func(off int) (int64, error) {
+		v := uint64(u32(off+4))<<32 | uint64(u32(off))
+		if v > math.MaxInt64 {
+			return 0, syscall.EOVERFLOW
+		}
+		return int64(v), nil // Java has no uint64
+	}
+	var err error
+	switch {
+	case cc.Kind == KindBBR && len(b) >= sizeofBBRv1Info:
+		info := &BBRInfo{}
+		if info.BW, err = u64(0); err != nil {
+			return CCInfo{}, err
+		}
+		info.MinRTT = u32(8)
+		info.PacingGain = u32(12)
+		info.CwndGain = u32(16)
+		if len(b) >= sizeofBBRv2Info {
+			if info.BWHi, err = u64(20); err != nil {
+				return CCInfo{}, err
+			}
+			if info.BWLo, err = u64(28); err != nil {
+				return CCInfo{}, err
+			}
+			info.Mode = b[36]
+			info.Phase = b[37]
+			info.Version = b[39]
+		}
+		if len(b) >= sizeofBBRv3Info {
+			info.InflightLo = u32(40)
+			info.InflightHi = u32(44)
+			info.ExtraAcked = u32(48)
+		}
+		cc.BBR = info
+	case cc.Kind == KindVegas && len(b) >= sizeofVegasInfo:
+		cc.Vegas = &VegasInfo{
+			Enabled:  u32(0),
+			RTTCount: u32(4),
+			RTT:      u32(8),
+			MinRTT:   u32(12),
+		}
+	case cc.Kind == KindDCTCP && len(b) >= sizeofDCTCPInfo:
+		cc.DCTCP = &DCTCPInfo{
+			Enabled: binary.NativeEndian.Uint16(b[0:]),
+			CEState: binary.NativeEndian.Uint16(b[2:]),
+			Alpha:   u32(4),
+			ABECN:   u32(8),
+			ABTotal: u32(12),
+		}
+	case cc.Kind == "" && len(b) == 0:
+		// E.g., cubic, which does not export any information.
+		return cc, ErrNoSupport
+	default:
+		// Unknown algorithm, or data too short for the expected structure.
+		return cc, syscall.EINVAL
+	}
+	return cc, nil
+}
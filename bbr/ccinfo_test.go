@@ -0,0 +1,104 @@
+package bbr
+
+import (
+	"encoding/binary"
+	"reflect"
+	"syscall"
+	"testing"
+
+	"github.com/m-lab/tcp-info/inetdiag"
+)
+
+// words returns the native byte representation of the given 32 bit words.
+func words(w ...uint32) []byte {
+	var b []byte
+	for _, v := range w {
+		b = binary.NativeEndian.AppendUint32(b, v)
+	}
+	return b
+}
+
+func Test_parseCCInfo(t *testing.T) {
+	bbrv1 := words(1000, 1, 20000, 739, 512)
+	bbrv2 := append(words(1000, 1, 20000, 739, 512, 5, 0, 7, 0), 2, 3, 0, 3)
+	bbrv3 := append(bbrv2[:len(bbrv2):len(bbrv2)], words(100, 200, 10)...)
+	dctcp := append(binary.NativeEndian.AppendUint16(binary.NativeEndian.AppendUint16(nil, 1), 2), words(3, 4, 5)...)
+	v1 := inetdiag.BBRInfo{BW: 1<<32 + 1000, MinRTT: 20000, PacingGain: 739, CwndGain: 512}
+	tests := []struct {
+		name      string
+		algorithm string
+		b         []byte
+		want      CCInfo
+		wantErr   error
+	}{
+		{
+			name:      "bbrv1",
+			algorithm: "bbr",
+			b:         bbrv1,
+			want:      CCInfo{Algorithm: "bbr", Kind: KindBBR, BBR: &BBRInfo{BBRInfo: v1}},
+		},
+		{
+			name:      "bbrv2",
+			algorithm: "bbr2",
+			b:         bbrv2,
+			want: CCInfo{Algorithm: "bbr2", Kind: KindBBR, BBR: &BBRInfo{
+				BBRInfo: v1, BWHi: 5, BWLo: 7, Mode: 2, Phase: 3, Version: 3,
+			}},
+		},
+		{
+			name:      "bbrv3",
+			algorithm: "bbr",
+			b:         bbrv3,
+			want: CCInfo{Algorithm: "bbr", Kind: KindBBR, BBR: &BBRInfo{
+				BBRInfo: v1, BWHi: 5, BWLo: 7, Mode: 2, Phase: 3, Version: 3,
+				InflightLo: 100, InflightHi: 200, ExtraAcked: 10,
+			}},
+		},
+		{
+			name:      "vegas",
+			algorithm: "illinois",
+			b:         words(1, 2, 3, 4),
+			want: CCInfo{Algorithm: "illinois", Kind: KindVegas, Vegas: &VegasInfo{
+				Enabled: 1, RTTCount: 2, RTT: 3, MinRTT: 4,
+			}},
+		},
+		{
+			name:      "dctcp",
+			algorithm: "dctcp",
+			b:         dctcp,
+			want: CCInfo{Algorithm: "dctcp", Kind: KindDCTCP, DCTCP: &DCTCPInfo{
+				Enabled: 1, CEState: 2, Alpha: 3, ABECN: 4, ABTotal: 5,
+			}},
+		},
+		{
+			name:      "no-info",
+			algorithm: "cubic",
+			want:      CCInfo{Algorithm: "cubic"},
+			wantErr:   ErrNoSupport,
+		},
+		{
+			name:      "too-short",
+			algorithm: "bbr",
+			b:         words(1, 2, 3, 4),
+			want:      CCInfo{Algorithm: "bbr", Kind: KindBBR},
+			wantErr:   syscall.EINVAL,
+		},
+		{
+			name:      "overflow",
+			algorithm: "bbr",
+			b:         words(0, 1<<31, 0, 0, 0),
+			wantErr:   syscall.EOVERFLOW,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCCInfo(tt.algorithm, tt.b)
+			if err != tt.wantErr {
+				t.Errorf("parseCCInfo() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseCCInfo() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
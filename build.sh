@@ -18,5 +18,11 @@ go install -v                                                          \
     -ldflags "$versionflags -extldflags \"-static\""                   \
     .
 
+# Install ndt-results
+go install -v -tags netgo ./cmd/ndt-results
+
+# Install ndt7-client
+go install -v -tags netgo -ldflags "$versionflags" ./cmd/ndt7-client
+
 # Install generate-schemas
 cd ./cmd/generate-schemas && go install -v .
@@ -1,43 +1,75 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"io/ioutil"
+	"log"
+	"strings"
 
 	"github.com/m-lab/go/cloud/bqx"
 	"github.com/m-lab/go/rtx"
 	"github.com/m-lab/ndt-server/data"
+	"github.com/m-lab/ndt-server/data/schema"
 
 	"cloud.google.com/go/bigquery"
 )
 
 var (
-	ndt7schema string
-	ndt5schema string
+	ndt7schema     string
+	ndt5schema     string
+	ndt7jsonschema string
+	ndt5jsonschema string
+	check          bool
 )
 
 func init() {
 	flag.StringVar(&ndt7schema, "ndt7", "/var/spool/datatypes/ndt7.json", "filename to write ndt7 schema")
 	flag.StringVar(&ndt5schema, "ndt5", "/var/spool/datatypes/ndt5.json", "filename to write ndt5 schema")
+	flag.StringVar(&ndt7jsonschema, "ndt7-jsonschema", "", "filename to write ndt7 JSON Schema (empty disables it)")
+	flag.StringVar(&ndt5jsonschema, "ndt5-jsonschema", "", "filename to write ndt5 JSON Schema (empty disables it)")
+	flag.BoolVar(&check, "check", true, "fail if a field of the published schemas in data/schema was removed")
 }
 
-func main() {
-	flag.Parse()
-	// Generate and save ndt7 schema for autoloading.
-	row7 := data.NDT7Result{}
-	sch, err := bigquery.InferSchema(row7)
-	rtx.Must(err, "failed to generate ndt7 schema")
+// checkRemoved exits with an error if a field of the published JSON Schema in
+// data/schema was removed from row.
+func checkRemoved(protocol string, row interface{}) {
+	prev, err := schema.Published(protocol)
+	rtx.Must(err, "failed to read the published %s schema", protocol)
+	// WARNING: never remove fields, see data/result.go.
+	cur := schema.Generate(strings.ToUpper(protocol)+"Result", row)
+	if removed := schema.Removed(prev, cur); len(removed) > 0 {
+		log.Fatalf("fields removed from the %s schema: %s", protocol, strings.Join(removed, ", "))
+	}
+}
+
+// generate saves the BigQuery schema and, optionally, the JSON Schema of row.
+func generate(protocol string, row interface{}, bqFile, jsonFile string) {
+	sch, err := bigquery.InferSchema(row)
+	rtx.Must(err, "failed to generate %s schema", protocol)
 	sch = bqx.RemoveRequired(sch)
 	b, err := sch.ToJSONFields()
 	rtx.Must(err, "failed to marshal schema")
-	ioutil.WriteFile(ndt7schema, b, 0o644)
+	rtx.Must(ioutil.WriteFile(bqFile, b, 0o644), "failed to write %s", bqFile)
+
+	if jsonFile == "" {
+		return
+	}
+	js := schema.Generate(strings.ToUpper(protocol)+"Result", row)
+	b, err = json.MarshalIndent(js, "", "  ")
+	rtx.Must(err, "failed to marshal JSON Schema")
+	rtx.Must(ioutil.WriteFile(jsonFile, append(b, '\n'), 0o644), "failed to write %s", jsonFile)
+}
 
+func main() {
+	flag.Parse()
+	// Refuse to save any schema if a field was removed.
+	if check {
+		checkRemoved("ndt7", data.NDT7Result{})
+		checkRemoved("ndt5", data.NDT5Result{})
+	}
+	// Generate and save ndt7 schema for autoloading.
+	generate("ndt7", data.NDT7Result{}, ndt7schema, ndt7jsonschema)
 	// Generate and save ndt5 schema for autoloading.
-	row5 := data.NDT7Result{}
-	sch, err = bigquery.InferSchema(row5)
-	rtx.Must(err, "failed to generate ndt5 schema")
-	sch = bqx.RemoveRequired(sch)
-	b, err = sch.ToJSONFields()
-	rtx.Must(err, "failed to marshal schema")
-	ioutil.WriteFile(ndt5schema, b, 0o644)
+	generate("ndt5", data.NDT5Result{}, ndt5schema, ndt5jsonschema)
 }
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Record is the result of a test run by a client.
+type Record struct {
+	// Step is the index of the step during which the test started.
+	Step     int
+	Protocol string
+	// Kind is "download" or "upload".
+	Kind string
+	// Mbps is the throughput of the test, as measured by the server when
+	// available.
+	Mbps float64
+	Err  error
+}
+
+// testFunc runs the tests of a client once and returns their records.
+type testFunc func(ctx context.Context) []Record
+
+// ServerStats are the maximum values of the metrics scraped from the server
+// during a step.
+type ServerStats struct {
+	ActiveTests float64
+	OpenConns   float64
+	// Scrapes and Errors are the number of successful and failed scrapes.
+	Scrapes int
+	Errors  int
+}
+
+// Load runs clients on a schedule.
+type Load struct {
+	// Schedule is the number of clients of every step, which must be
+	// increasing. The clients of a step keep running during the next steps.
+	Schedule []int
+	// Step is the duration of every step.
+	Step time.Duration
+	// NewTests returns the tests of the i-th client.
+	NewTests func(i int) testFunc
+	// MetricsURL is the URL of the Prometheus metrics of the server, which are
+	// scraped every ScrapeInterval. They are not scraped when it is empty.
+	MetricsURL     string
+	ScrapeInterval time.Duration
+
+	step    atomic.Int64
+	mu      sync.Mutex
+	records []Record
+	stats   []ServerStats
+}
+
+// Run runs the steps of the schedule and returns the records of all the tests
+// and the server metrics of every step. At the end of the last step, or when
+// ctx is done, the clients stop starting new tests, and Run waits for the
+// running tests, which are canceled with ctx.
+func (l *Load) Run(ctx context.Context) ([]Record, []ServerStats) {
+	l.stats = make([]ServerStats, len(l.Schedule))
+	stop := make(chan struct{})
+	wg := sync.WaitGroup{}
+	if l.MetricsURL != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.scrape(ctx, stop)
+		}()
+	}
+	clients := 0
+	for i, n := range l.Schedule {
+		if ctx.Err() != nil {
+			break
+		}
+		l.step.Store(int64(i))
+		log.Printf("step %d: %d clients", i, n)
+		for ; clients < n; clients++ {
+			wg.Add(1)
+			go func(run testFunc) {
+				defer wg.Done()
+				l.client(ctx, stop, run)
+			}(l.NewTests(clients))
+		}
+		select {
+		case <-time.After(l.Step):
+		case <-ctx.Done():
+		}
+	}
+	close(stop)
+	wg.Wait()
+	return l.records, l.stats
+}
+
+// client runs tests until stop is closed or ctx is done.
+func (l *Load) client(ctx context.Context, stop <-chan struct{}, run testFunc) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ctx.Done():
+			return
+		default:
+		}
+		step := int(l.step.Load())
+		records := run(ctx)
+		failed := true
+		for i := range records {
+			records[i].Step = step
+			failed = failed && records[i].Err != nil
+		}
+		l.mu.Lock()
+		l.records = append(l.records, records...)
+		l.mu.Unlock()
+		if failed {
+			// Do not retry in a tight loop, e.g., when connections are
+			// refused.
+			select {
+			case <-time.After(time.Second):
+			case <-stop:
+			}
+		}
+	}
+}
+
+// scrape scrapes the metrics of the server until stop is closed or ctx is
+// done, and records their maximum values per step.
+func (l *Load) scrape(ctx context.Context, stop <-chan struct{}) {
+	c := &http.Client{Timeout: l.ScrapeInterval}
+	ticker := time.NewTicker(l.ScrapeInterval)
+	defer ticker.Stop()
+	for {
+		step := int(l.step.Load())
+		values, err := scrape(ctx, c, l.MetricsURL)
+		l.mu.Lock()
+		s := &l.stats[step]
+		if err != nil {
+			log.Printf("cannot scrape %s: %v", l.MetricsURL, err)
+			s.Errors++
+		} else {
+			s.Scrapes++
+			s.ActiveTests = max(s.ActiveTests, values[activeTests])
+			s.OpenConns = max(s.OpenConns, values[openConns])
+		}
+		l.mu.Unlock()
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/m-lab/go/testingx"
+	"github.com/m-lab/ndt-server/ndt7/client"
+	"github.com/m-lab/ndt-server/ndt7/ndt7test"
+	"github.com/m-lab/ndt-server/ndt7/spec"
+)
+
+func TestParseSchedule(t *testing.T) {
+	for s, want := range map[string]string{
+		"1,2, 4": "[1 2 4]",
+		"3":      "[3]",
+		"2,1":    "",
+		"0,1":    "",
+		"1,x":    "",
+	} {
+		got, err := parseSchedule(s)
+		if (err == nil) != (want != "") || (err == nil && fmt.Sprint(got) != want) {
+			t.Errorf("parseSchedule(%q) = %v, %v; want %s", s, got, err, want)
+		}
+	}
+}
+
+func TestLoad_Run(t *testing.T) {
+	var running, maxRunning atomic.Int64
+	l := &Load{
+		Schedule: []int{1, 3},
+		Step:     200 * time.Millisecond,
+		NewTests: func(i int) testFunc {
+			return func(ctx context.Context) []Record {
+				n := running.Add(1)
+				defer running.Add(-1)
+				for m := maxRunning.Load(); n > m && !maxRunning.CompareAndSwap(m, n); m = maxRunning.Load() {
+				}
+				time.Sleep(50 * time.Millisecond)
+				return []Record{{Protocol: "fake", Kind: "download", Mbps: float64(i)}}
+			}
+		},
+	}
+	records, stats := l.Run(context.Background())
+	if len(stats) != 2 {
+		t.Errorf("wrong number of stats; got %d, want 2", len(stats))
+	}
+	if maxRunning.Load() != 3 {
+		t.Errorf("wrong maximum concurrency; got %d, want 3", maxRunning.Load())
+	}
+	steps := map[int]int{}
+	for _, r := range records {
+		steps[r.Step]++
+	}
+	// About 4 tests in the first step, then 3 clients run about 4 tests each.
+	if steps[0] < 3 || steps[1] < 9 {
+		t.Errorf("wrong number of tests per step; got %v", steps)
+	}
+}
+
+func TestLoad_RunNDT7(t *testing.T) {
+	h, srv := ndt7test.NewNDT7Server(t)
+	defer srv.Close()
+	h.MinRuntime, h.MaxRuntime = time.Second, spec.DefaultRuntime
+
+	l := &Load{
+		Schedule: []int{2},
+		Step:     100 * time.Millisecond,
+		NewTests: func(i int) testFunc {
+			c, err := client.New(srv.URL)
+			testingx.Must(t, err, "failed to create client")
+			c.Duration = time.Second
+			return runNDT7(c, []spec.SubtestKind{spec.SubtestDownload, spec.SubtestUpload})
+		},
+	}
+	records, _ := l.Run(context.Background())
+	groups := summarize(l.Schedule, records)
+	if len(groups) != 2 {
+		t.Fatalf("wrong groups; got %+v", groups)
+	}
+	for _, g := range groups {
+		if g.Tests != 2 || g.Errors != 0 || g.P10 <= 0 {
+			t.Errorf("wrong group; got %+v", g)
+		}
+	}
+}
+
+func TestReason(t *testing.T) {
+	for err, want := range map[error]string{
+		&client.HandshakeError{StatusCode: http.StatusServiceUnavailable}: "http status 503",
+		&websocket.CloseError{Code: websocket.CloseAbnormalClosure}:       "websocket close 1006",
+		fmt.Errorf("dial: %w", syscall.ECONNREFUSED):                      "connection refused",
+		context.DeadlineExceeded:                                          "timeout",
+		errNotRun:                                                         "not run",
+		errors.New("server error: busy"):                                  "other",
+	} {
+		if got := reason(err); got != want {
+			t.Errorf("reason(%v) = %q, want %q", err, got, want)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+// ndt-load runs concurrent ndt7 clients, and optionally ndt5 clients, against
+// a server, to find how many concurrent tests the server handles before the
+// results degrade.
+//
+// The number of clients ramps up on a schedule: every -step, clients are added
+// up to the next value of -concurrency. Every client runs tests back to back.
+// At the end, ndt-load prints, for every step, the throughput distribution and
+// the failure reasons of the tests started during the step. With -metrics, it
+// also prints the maximum values of the ndt_active_tests and
+// netx_current_open_conns gauges scraped from the server during the step.
+//
+// Usage:
+//
+//	ndt-load -server https://ndt.example.com -concurrency 1,2,4,8,16 -step 1m \
+//	    -metrics http://ndt.example.com:9990/metrics
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/m-lab/go/flagx"
+	"github.com/m-lab/go/rtx"
+	ndt5client "github.com/m-lab/ndt-server/ndt5/client"
+	"github.com/m-lab/ndt-server/ndt5/ndt"
+	"github.com/m-lab/ndt-server/ndt7/client"
+	"github.com/m-lab/ndt-server/ndt7/spec"
+	"github.com/m-lab/ndt-server/version"
+)
+
+var (
+	server         = flag.String("server", "https://localhost", "The URL of the ndt7 server, e.g., https://ndt.example.com")
+	ndt5Addr       = flag.String("ndt5", "", "The host:port of the plain ndt5 server. When set, every other client runs ndt5 tests instead of ndt7 tests")
+	concurrency    = flag.String("concurrency", "1,2,4,8,16,32", "The comma-separated, increasing numbers of clients of every step")
+	step           = flag.Duration("step", time.Minute, "The duration of every step")
+	duration       = flag.Duration("duration", 0, "The runtime of the ndt7 subtests, within the bounds of the server (0 uses the server default)")
+	token          = flag.String("token", "", "The access token required by the ndt7 server, if any")
+	insecure       = flag.Bool("insecure", false, "Do not verify the certificate of the ndt7 server, e.g., when it is self-signed")
+	metricsURL     = flag.String("metrics", "", "The URL of the Prometheus metrics of the server, e.g., http://localhost:9990/metrics")
+	scrapeInterval = flag.Duration("metrics.interval", 5*time.Second, "The interval between scrapes of -metrics")
+	test           = flagx.Enum{
+		Options: []string{"download", "upload", "both"},
+		Value:   "both",
+	}
+)
+
+func init() {
+	flag.Var(&test, "test", "The subtests to run: download, upload or both")
+}
+
+// parseSchedule parses the comma-separated numbers of clients of every step,
+// which must be positive and increasing.
+func parseSchedule(s string) ([]int, error) {
+	var schedule []int
+	for _, f := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil {
+			return nil, err
+		}
+		if n <= 0 || (len(schedule) > 0 && n < schedule[len(schedule)-1]) {
+			return nil, fmt.Errorf("invalid schedule %q: values must be positive and increasing", s)
+		}
+		schedule = append(schedule, n)
+	}
+	return schedule, nil
+}
+
+// newTests returns the function creating the tests of every client.
+func newTests(kinds []spec.SubtestKind) (func(i int) testFunc, error) {
+	if _, err := client.New(*server); err != nil {
+		return nil, err
+	}
+	ndt5Tests := 0
+	for _, kind := range kinds {
+		if kind == spec.SubtestDownload {
+			ndt5Tests |= ndt5client.TestS2C
+		} else {
+			ndt5Tests |= ndt5client.TestC2S
+		}
+	}
+	return func(i int) testFunc {
+		if *ndt5Addr != "" && i%2 == 1 {
+			c := ndt5client.New(ndt.Plain, *ndt5Addr)
+			c.Tests = ndt5Tests
+			return runNDT5(c)
+		}
+		// The URL is valid, since it was checked above.
+		c, _ := client.New(*server)
+		c.UserAgent = "ndt-load/" + version.Version
+		c.AccessToken = *token
+		c.Duration = *duration
+		c.Metadata = map[string]string{"client_name": "ndt-load"}
+		if *insecure {
+			c.Dialer.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		}
+		return runNDT7(c, kinds)
+	}, nil
+}
+
+func main() {
+	flag.Parse()
+	schedule, err := parseSchedule(*concurrency)
+	rtx.Must(err, "invalid -concurrency")
+	kinds := []spec.SubtestKind{spec.SubtestDownload, spec.SubtestUpload}
+	switch test.Value {
+	case "download":
+		kinds = kinds[:1]
+	case "upload":
+		kinds = kinds[1:]
+	}
+	tests, err := newTests(kinds)
+	rtx.Must(err, "invalid server URL %q", *server)
+
+	l := &Load{
+		Schedule:       schedule,
+		Step:           *step,
+		NewTests:       tests,
+		MetricsURL:     *metricsURL,
+		ScrapeInterval: *scrapeInterval,
+	}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	log.Printf("running %d steps of %v", len(schedule), *step)
+	records, stats := l.Run(ctx)
+	rtx.Must(writeReport(os.Stdout, schedule, records, stats), "cannot write report")
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"text/tabwriter"
+)
+
+// Group summarizes the tests of the same step, protocol and kind.
+type Group struct {
+	Step     int
+	Clients  int
+	Protocol string
+	Kind     string
+	Tests    int
+	Errors   int
+	// P10, P50 and P90 are percentiles of the throughput in Mbit/s, among the
+	// successful tests.
+	P10, P50, P90 float64
+}
+
+// Failure counts the failed tests of a step with the same reason.
+type Failure struct {
+	Step   int
+	Reason string
+	Count  int
+	// Example is the error of one of the tests.
+	Example string
+}
+
+// percentile returns the p-th percentile of sorted, using the nearest-rank
+// method, or zero if sorted is empty.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// summarize groups records by step, protocol and kind, in this order.
+func summarize(schedule []int, records []Record) []Group {
+	type key struct {
+		step           int
+		protocol, kind string
+	}
+	type values struct {
+		g    Group
+		mbps []float64
+	}
+	groups := map[key]*values{}
+	for _, r := range records {
+		k := key{r.Step, r.Protocol, r.Kind}
+		v := groups[k]
+		if v == nil {
+			v = &values{g: Group{Step: r.Step, Clients: schedule[r.Step], Protocol: r.Protocol, Kind: r.Kind}}
+			groups[k] = v
+		}
+		v.g.Tests++
+		if r.Err != nil {
+			v.g.Errors++
+		} else {
+			v.mbps = append(v.mbps, r.Mbps)
+		}
+	}
+	var out []Group
+	for _, v := range groups {
+		sort.Float64s(v.mbps)
+		v.g.P10 = percentile(v.mbps, 10)
+		v.g.P50 = percentile(v.mbps, 50)
+		v.g.P90 = percentile(v.mbps, 90)
+		out = append(out, v.g)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if a.Step != b.Step {
+			return a.Step < b.Step
+		}
+		if a.Protocol != b.Protocol {
+			return a.Protocol < b.Protocol
+		}
+		return a.Kind < b.Kind
+	})
+	return out
+}
+
+// failures groups the failed records by step and reason, in this order.
+func failures(records []Record) []Failure {
+	type key struct {
+		step   int
+		reason string
+	}
+	counts := map[key]*Failure{}
+	for _, r := range records {
+		if r.Err == nil {
+			continue
+		}
+		k := key{r.Step, reason(r.Err)}
+		f := counts[k]
+		if f == nil {
+			f = &Failure{Step: r.Step, Reason: k.reason, Example: r.Err.Error()}
+			counts[k] = f
+		}
+		f.Count++
+	}
+	var out []Failure
+	for _, f := range counts {
+		out = append(out, *f)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Step != out[j].Step {
+			return out[i].Step < out[j].Step
+		}
+		return out[i].Reason < out[j].Reason
+	})
+	return out
+}
+
+// writeReport writes the summary of the records, the server metrics of every
+// step, if any were scraped, and the failures, if any.
+func writeReport(w io.Writer, schedule []int, records []Record, stats []ServerStats) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "STEP\tCLIENTS\tPROTOCOL\tKIND\tTESTS\tERRORS\tP10_MBPS\tP50_MBPS\tP90_MBPS\t")
+	for _, g := range summarize(schedule, records) {
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%d\t%d\t%.2f\t%.2f\t%.2f\t\n",
+			g.Step, g.Clients, g.Protocol, g.Kind, g.Tests, g.Errors, g.P10, g.P50, g.P90)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	scraped := false
+	for _, s := range stats {
+		scraped = scraped || s.Scrapes+s.Errors > 0
+	}
+	if scraped {
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(tw, "\nSTEP\tCLIENTS\tMAX_ACTIVE_TESTS\tMAX_OPEN_CONNS\tSCRAPES\tSCRAPE_ERRORS\t")
+		for i, s := range stats {
+			fmt.Fprintf(tw, "%d\t%d\t%.0f\t%.0f\t%d\t%d\t\n",
+				i, schedule[i], s.ActiveTests, s.OpenConns, s.Scrapes, s.Errors)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	fs := failures(records)
+	if len(fs) == 0 {
+		return nil
+	}
+	// Left-align the columns, since the examples may be long.
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "\nSTEP\tREASON\tCOUNT\tEXAMPLE")
+	for _, f := range fs {
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%s\n", f.Step, f.Reason, f.Count, f.Example)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestScrape(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`# TYPE ndt_active_tests gauge
+ndt_active_tests{protocol="ndt7+wss"} 3
+ndt_active_tests{protocol="ndt5+plain"} 2
+# TYPE netx_current_open_conns gauge
+netx_current_open_conns 7
+`))
+	}))
+	defer srv.Close()
+	values, err := scrape(context.Background(), srv.Client(), srv.URL)
+	if err != nil {
+		t.Fatalf("scrape() failed: %v", err)
+	}
+	if values[activeTests] != 5 || values[openConns] != 7 {
+		t.Errorf("scrape() = %v", values)
+	}
+	if _, err := scrape(context.Background(), srv.Client(), srv.URL+"/\x00"); err == nil {
+		t.Error("scrape() succeeded with an invalid URL")
+	}
+}
+
+func TestWriteReport(t *testing.T) {
+	records := []Record{
+		{Step: 0, Protocol: "ndt7", Kind: "download", Mbps: 10},
+		{Step: 0, Protocol: "ndt7", Kind: "download", Mbps: 30},
+		{Step: 0, Protocol: "ndt7", Kind: "download", Mbps: 20},
+		{Step: 1, Protocol: "ndt7", Kind: "download", Mbps: 5},
+		{Step: 1, Protocol: "ndt7", Kind: "download", Err: errors.New("boom")},
+		{Step: 1, Protocol: "ndt7", Kind: "download", Err: errors.New("bang")},
+	}
+	groups := summarize([]int{1, 2}, records)
+	if len(groups) != 2 || groups[0].P50 != 20 || groups[0].P90 != 30 ||
+		groups[1].Clients != 2 || groups[1].Tests != 3 || groups[1].Errors != 2 || groups[1].P50 != 5 {
+		t.Errorf("wrong groups; got %+v", groups)
+	}
+	fs := failures(records)
+	if len(fs) != 1 || fs[0].Step != 1 || fs[0].Reason != "other" || fs[0].Count != 2 {
+		t.Errorf("wrong failures; got %+v", fs)
+	}
+
+	out := &bytes.Buffer{}
+	stats := []ServerStats{{ActiveTests: 1, Scrapes: 1}, {ActiveTests: 2, Scrapes: 1}}
+	if err := writeReport(out, []int{1, 2}, records, stats); err != nil {
+		t.Fatalf("writeReport() failed: %v", err)
+	}
+	for _, want := range []string{"P50_MBPS", "MAX_ACTIVE_TESTS", "REASON"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report does not contain %s:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+)
+
+// Names of the gauges scraped from the server.
+const (
+	activeTests = "ndt_active_tests"
+	openConns   = "netx_current_open_conns"
+)
+
+// scrape returns the values of the scraped gauges exported at url, summed
+// over their labels, e.g., the protocol of ndt_active_tests.
+func scrape(ctx context.Context, c *http.Client, url string) (map[string]float64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	parser := expfmt.NewTextParser(model.UTF8Validation)
+	families, err := parser.TextToMetricFamilies(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]float64{}
+	for _, name := range []string{activeTests, openConns} {
+		for _, m := range families[name].GetMetric() {
+			values[name] += m.GetGauge().GetValue()
+		}
+	}
+	return values, nil
+}
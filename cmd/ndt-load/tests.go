@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+
+	"github.com/gorilla/websocket"
+	ndt5client "github.com/m-lab/ndt-server/ndt5/client"
+	"github.com/m-lab/ndt-server/ndt7/client"
+	"github.com/m-lab/ndt-server/ndt7/model"
+	"github.com/m-lab/ndt-server/ndt7/spec"
+)
+
+// errNotRun is the error of the tests that the server did not run.
+var errNotRun = errors.New("test not run by the server")
+
+// runNDT7 returns a testFunc running the given ndt7 subtests with c.
+func runNDT7(c *client.Client, kinds []spec.SubtestKind) testFunc {
+	return func(ctx context.Context) []Record {
+		var records []Record
+		for _, kind := range kinds {
+			r := Record{Protocol: "ndt7", Kind: string(kind)}
+			start := c.Download
+			if kind == spec.SubtestUpload {
+				start = c.Upload
+			}
+			st, err := start(ctx)
+			if err == nil {
+				var result *client.Result
+				result, err = st.Wait()
+				r.Mbps = ndt7Mbps(kind, result)
+			}
+			r.Err = err
+			records = append(records, r)
+		}
+		return records
+	}
+}
+
+// ndt7Mbps returns the throughput of an ndt7 subtest: the one computed by the
+// server, or the last one measured by the receiver if the server did not send
+// its summary.
+func ndt7Mbps(kind spec.SubtestKind, result *client.Result) float64 {
+	if result.Summary != nil {
+		return result.Summary.MeanThroughputMbps
+	}
+	measurements := result.ClientMeasurements
+	if kind == spec.SubtestUpload {
+		measurements = result.ServerMeasurements
+	}
+	var last *model.AppInfo
+	for _, m := range measurements {
+		if m.AppInfo != nil {
+			last = m.AppInfo
+		}
+	}
+	if last == nil || last.ElapsedTime <= 0 {
+		return 0
+	}
+	return 8 * float64(last.NumBytes) / float64(last.ElapsedTime)
+}
+
+// runNDT5 returns a testFunc running the ndt5 tests of c. The throughput is
+// the one measured by the server.
+func runNDT5(c *ndt5client.Client) testFunc {
+	return func(ctx context.Context) []Record {
+		result, err := c.Run(ctx)
+		if result == nil {
+			result = &ndt5client.Result{}
+		}
+		// The result contains the tests completed before any error.
+		testErr := err
+		if testErr == nil {
+			testErr = errNotRun
+		}
+		var records []Record
+		if c.Tests&ndt5client.TestS2C != 0 {
+			r := Record{Protocol: "ndt5", Kind: string(spec.SubtestDownload), Err: testErr}
+			if result.S2C != nil {
+				r.Mbps, r.Err = result.S2C.ServerKbps/1000, nil
+			}
+			records = append(records, r)
+		}
+		if c.Tests&ndt5client.TestC2S != 0 {
+			r := Record{Protocol: "ndt5", Kind: string(spec.SubtestUpload), Err: testErr}
+			if result.C2S != nil {
+				r.Mbps, r.Err = result.C2S.ServerKbps/1000, nil
+			}
+			records = append(records, r)
+		}
+		return records
+	}
+}
+
+// reason returns the reason of a failure, grouping the errors that differ
+// only by details such as addresses.
+func reason(err error) string {
+	var herr *client.HandshakeError
+	var cerr *websocket.CloseError
+	var nerr net.Error
+	switch {
+	case errors.As(err, &herr):
+		return fmt.Sprintf("http status %d", herr.StatusCode)
+	case errors.As(err, &cerr):
+		return fmt.Sprintf("websocket close %d", cerr.Code)
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection refused"
+	case errors.Is(err, syscall.ECONNRESET):
+		return "connection reset"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &nerr) && nerr.Timeout():
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "unexpected EOF"
+	case errors.Is(err, errNotRun):
+		return "not run"
+	default:
+		return "other"
+	}
+}
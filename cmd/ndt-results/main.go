@@ -0,0 +1,57 @@
+// ndt-results inspects the results saved by ndt-server in a data directory.
+//
+// It reads the ndt7 files, compressed or not and including streaming results
+// files, even those left incomplete under a temporary name by a server that
+// died during the subtest, and the ndt5 files, and prints a summary per day, protocol and kind
+// of subtest, or exports a record per subtest as CSV or JSON Lines.
+//
+// Usage:
+//
+//	ndt-results -datadir /var/spool/ndt [-format summary|csv|jsonl]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/m-lab/go/flagx"
+	"github.com/m-lab/go/rtx"
+)
+
+var (
+	datadir = flag.String("datadir", "/var/spool/ndt", "The data directory of ndt-server")
+	format  = flagx.Enum{
+		Options: []string{"summary", "csv", "jsonl"},
+		Value:   "summary",
+	}
+)
+
+func init() {
+	flag.Var(&format, "format", "The output format: summary, csv or jsonl")
+}
+
+func main() {
+	flag.Parse()
+	records, unreadable, err := readRecords(*datadir, func(name string, err error) {
+		log.Printf("cannot read %s: %v", name, err)
+	})
+	rtx.Must(err, "cannot walk %s", *datadir)
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].StartTime.Before(records[j].StartTime)
+	})
+	switch format.Value {
+	case "csv":
+		err = writeCSV(os.Stdout, records)
+	case "jsonl":
+		err = writeJSONL(os.Stdout, records)
+	default:
+		err = writeSummary(os.Stdout, summarize(records))
+		if err == nil && unreadable > 0 {
+			fmt.Fprintf(os.Stderr, "%d files could not be read\n", unreadable)
+		}
+	}
+	rtx.Must(err, "cannot write output")
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/m-lab/ndt-server/data"
+	"github.com/m-lab/ndt-server/ndt7/model"
+	"github.com/m-lab/ndt-server/ndt7/results"
+	"github.com/m-lab/ndt-server/ndt7/spec"
+	"github.com/m-lab/ndt-server/ndt7/summary"
+)
+
+// Record is the summary of a subtest, common to ndt5 and ndt7.
+type Record struct {
+	// Date is the UTC date of the test, as YYYY-MM-DD.
+	Date string
+	// Protocol is "ndt5" or "ndt7".
+	Protocol string
+	// Kind is "download", "upload" or, for ndt7, "responsiveness". The ndt5
+	// s2c and c2s subtests are reported as downloads and uploads.
+	Kind      string
+	UUID      string
+	StartTime time.Time
+	ClientIP  string
+	// Streams is the number of streams of multi-stream ndt7 subtests, or 1.
+	Streams            int
+	MeanThroughputMbps float64
+	// MinRTTMillis is the minimum RTT measured by TCP, or zero when unknown.
+	MinRTTMillis float64
+	// Error is the error of an ndt5 subtest. ndt7 subtests saved in truncated
+	// streaming results files, e.g., because the server died during the
+	// subtest, have the error "incomplete". An empty TerminationReason is not
+	// an error, since older servers never set it.
+	Error string `json:",omitempty"`
+	// EarlyExit is whether an ndt7 subtest ended early at the client's request.
+	EarlyExit bool
+	// File is the file containing the subtest.
+	File string
+}
+
+// walk calls fn for every ndt5 and ndt7 result file in datadir, including the
+// streaming results files that were never closed, e.g., because the server
+// died during the subtest and has not recovered them yet.
+func walk(datadir string, fn func(protocol, name string)) error {
+	return filepath.WalkDir(datadir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		base := d.Name()
+		switch {
+		case results.IsStreamTemp(name):
+			fn("ndt7", name)
+		case strings.HasPrefix(base, "."):
+			// Files being written.
+		case strings.HasPrefix(base, "ndt7-") && strings.Contains(base, ".json"):
+			fn("ndt7", name)
+		case strings.HasSuffix(base, ".json") && strings.Contains("/"+filepath.ToSlash(name), "/ndt5/"):
+			fn("ndt5", name)
+		}
+		return nil
+	})
+}
+
+// open opens the named file, decompressing it if its name ends with ".gz".
+func open(name string) (io.ReadCloser, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(name, ".gz") {
+		return f, nil
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{gz, f}, nil
+}
+
+// readNDT7 returns the records of the subtests in an ndt7 file.
+func readNDT7(name string) ([]Record, error) {
+	var result *data.NDT7Result
+	complete := true
+	if strings.Contains(filepath.Base(name), ".jsonl") {
+		r, ok, err := results.ReadStreamFile(name)
+		if err != nil {
+			return nil, err
+		}
+		result, complete = r, ok
+	} else {
+		f, err := open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		result = &data.NDT7Result{}
+		if err := json.NewDecoder(f).Decode(result); err != nil {
+			return nil, err
+		}
+	}
+	base := Record{
+		Date:      result.StartTime.UTC().Format("2006-01-02"),
+		Protocol:  "ndt7",
+		StartTime: result.StartTime,
+		ClientIP:  result.ClientIP,
+		Streams:   1,
+		File:      name,
+	}
+	if !complete {
+		base.Error = "incomplete"
+	}
+	var records []Record
+	for _, sub := range []struct {
+		kind spec.SubtestKind
+		ad   *model.ArchivalData
+	}{
+		{spec.SubtestDownload, result.Download},
+		{spec.SubtestUpload, result.Upload},
+		{spec.SubtestResponsiveness, result.Responsiveness},
+	} {
+		if sub.ad != nil {
+			records = append(records, ndt7Record(base, sub.kind, sub.ad))
+		}
+	}
+	for _, sub := range []struct {
+		kind    spec.SubtestKind
+		streams []*model.ArchivalData
+	}{
+		{spec.SubtestDownload, result.DownloadStreams},
+		{spec.SubtestUpload, result.UploadStreams},
+	} {
+		if len(sub.streams) > 0 {
+			records = append(records, sessionRecord(base, sub.kind, result.Session, sub.streams))
+		}
+	}
+	return records, nil
+}
+
+// ndt7Record returns the record of a single-stream ndt7 subtest.
+func ndt7Record(r Record, kind spec.SubtestKind, ad *model.ArchivalData) Record {
+	r.Kind = string(kind)
+	r.UUID = ad.UUID
+	s := ad.Summary
+	if s == nil && kind != spec.SubtestResponsiveness {
+		// Results written before the server sent summaries.
+		s = summary.New(kind, ad)
+	}
+	if s != nil {
+		r.MeanThroughputMbps = s.MeanThroughputMbps
+		r.MinRTTMillis = float64(s.MinRTT) / 1000
+	}
+	r.EarlyExit = ad.TerminationReason == model.TerminationEarlyExit
+	return r
+}
+
+// sessionRecord returns the record of a multi-stream ndt7 subtest.
+func sessionRecord(r Record, kind spec.SubtestKind, session *model.SessionData, streams []*model.ArchivalData) Record {
+	r.Kind = string(kind)
+	r.Streams = len(streams)
+	r.MinRTTMillis = math.Inf(1)
+	for _, ad := range streams {
+		sr := ndt7Record(r, kind, ad)
+		if r.UUID == "" {
+			r.UUID = sr.UUID
+		}
+		r.MeanThroughputMbps += sr.MeanThroughputMbps
+		if sr.MinRTTMillis > 0 {
+			r.MinRTTMillis = math.Min(r.MinRTTMillis, sr.MinRTTMillis)
+		}
+		r.EarlyExit = r.EarlyExit || sr.EarlyExit
+	}
+	if math.IsInf(r.MinRTTMillis, 1) {
+		r.MinRTTMillis = 0
+	}
+	if session != nil && session.AggregateThroughputMbps > 0 {
+		r.MeanThroughputMbps = session.AggregateThroughputMbps
+	}
+	return r
+}
+
+// readNDT5 returns the records of the subtests in an ndt5 file.
+func readNDT5(name string) ([]Record, error) {
+	f, err := open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	result := &data.NDT5Result{}
+	if err := json.NewDecoder(f).Decode(result); err != nil {
+		return nil, err
+	}
+	base := Record{
+		Date:      result.StartTime.UTC().Format("2006-01-02"),
+		Protocol:  "ndt5",
+		StartTime: result.StartTime,
+		ClientIP:  result.ClientIP,
+		Streams:   1,
+		File:      name,
+	}
+	if result.Control != nil {
+		base.UUID = result.Control.UUID
+	}
+	var records []Record
+	if s2c := result.S2C; s2c != nil {
+		r := base
+		r.Kind = string(spec.SubtestDownload)
+		r.MeanThroughputMbps = s2c.MeanThroughputMbps
+		r.MinRTTMillis = float64(s2c.MinRTT) / float64(time.Millisecond)
+		r.Error = s2c.Error
+		records = append(records, r)
+	}
+	if c2s := result.C2S; c2s != nil {
+		r := base
+		r.Kind = string(spec.SubtestUpload)
+		r.MeanThroughputMbps = c2s.MeanThroughputMbps
+		r.Error = c2s.Error
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// readRecords returns the records of every result file in datadir. It also
+// returns the number of files that could not be read, after reporting them
+// to warn.
+func readRecords(datadir string, warn func(name string, err error)) ([]Record, int, error) {
+	var records []Record
+	unreadable := 0
+	err := walk(datadir, func(protocol, name string) {
+		var r []Record
+		var err error
+		if protocol == "ndt7" {
+			r, err = readNDT7(name)
+		} else {
+			r, err = readNDT5(name)
+		}
+		if err != nil {
+			unreadable++
+			warn(name, err)
+			return
+		}
+		records = append(records, r...)
+	})
+	return records, unreadable, err
+}
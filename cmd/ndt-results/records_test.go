@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/m-lab/ndt-server/data"
+	"github.com/m-lab/ndt-server/ndt5/c2s"
+	"github.com/m-lab/ndt-server/ndt5/control"
+	"github.com/m-lab/ndt-server/ndt5/s2c"
+	"github.com/m-lab/ndt-server/ndt7/model"
+	"github.com/m-lab/ndt-server/ndt7/results"
+	"github.com/m-lab/ndt-server/ndt7/spec"
+)
+
+func writeNDT7(t *testing.T, datadir string, result *data.NDT7Result) {
+	fp, err := results.NewFile(result.Download.UUID, datadir, spec.SubtestDownload, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fp.WriteResult(result); err != nil {
+		t.Fatal(err)
+	}
+	if err := fp.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadRecordsAndSummarize(t *testing.T) {
+	datadir := t.TempDir()
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i, mbps := range []float64{10, 20, 30} {
+		reason := model.TerminationRuntime
+		if i == 2 {
+			reason = model.TerminationEarlyExit
+		}
+		writeNDT7(t, datadir, &data.NDT7Result{
+			StartTime: start,
+			Download: &model.ArchivalData{
+				UUID:              "uuid" + string(rune('a'+i)),
+				TerminationReason: reason,
+				Summary:           &model.Summary{MeanThroughputMbps: mbps, MinRTT: 5000},
+			},
+		})
+	}
+	ndt5dir := filepath.Join(datadir, "ndt5", "2024", "05", "01")
+	if err := os.MkdirAll(ndt5dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(&data.NDT5Result{
+		StartTime: start,
+		Control:   &control.ArchivalData{UUID: "ndt5uuid"},
+		S2C:       &s2c.ArchivalData{MeanThroughputMbps: 50, MinRTT: 7 * time.Millisecond},
+		C2S:       &c2s.ArchivalData{Error: "timeout"},
+	})
+	if err := os.WriteFile(filepath.Join(ndt5dir, "ndt5uuid.json"), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(ndt5dir, "broken.json"), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var warned []string
+	records, unreadable, err := readRecords(datadir, func(name string, err error) {
+		warned = append(warned, name)
+	})
+	if err != nil {
+		t.Fatalf("readRecords() error = %v", err)
+	}
+	if len(records) != 5 || unreadable != 1 || len(warned) != 1 {
+		t.Fatalf("readRecords() = %d records, %d unreadable", len(records), unreadable)
+	}
+
+	groups := summarize(records)
+	want := []Group{
+		{Date: "2024-05-01", Protocol: "ndt5", Kind: "download", Tests: 1, P10: 50, P50: 50, P90: 50, MinRTTMillis: 7},
+		{Date: "2024-05-01", Protocol: "ndt5", Kind: "upload", Tests: 1, Errors: 1},
+		{Date: "2024-05-01", Protocol: "ndt7", Kind: "download", Tests: 3, EarlyExits: 1, P10: 10, P50: 20, P90: 30, MinRTTMillis: 5},
+	}
+	if len(groups) != len(want) {
+		t.Fatalf("summarize() = %+v", groups)
+	}
+	for i := range want {
+		if groups[i] != want[i] {
+			t.Errorf("summarize()[%d] = %+v, want %+v", i, groups[i], want[i])
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := writeCSV(&buf, records); err != nil {
+		t.Fatalf("writeCSV() error = %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 6 || !strings.HasPrefix(lines[0], "Date,Protocol,Kind") {
+		t.Errorf("writeCSV() = %q", buf.String())
+	}
+	buf.Reset()
+	if err := writeJSONL(&buf, records); err != nil {
+		t.Fatalf("writeJSONL() error = %v", err)
+	}
+	if n := strings.Count(buf.String(), "\n"); n != 5 {
+		t.Errorf("writeJSONL() wrote %d lines, want 5", n)
+	}
+}
+
+func TestSessionRecord(t *testing.T) {
+	streams := []*model.ArchivalData{
+		{UUID: "a", TerminationReason: model.TerminationRuntime, Summary: &model.Summary{MeanThroughputMbps: 10, MinRTT: 4000}},
+		{UUID: "b", Summary: &model.Summary{MeanThroughputMbps: 15, MinRTT: 3000}},
+	}
+	r := sessionRecord(Record{}, spec.SubtestDownload, nil, streams)
+	if r.UUID != "a" || r.Streams != 2 || r.MeanThroughputMbps != 25 || r.MinRTTMillis != 3 || r.Error != "" {
+		t.Errorf("sessionRecord() = %+v", r)
+	}
+}
+
+func TestReadNDT7_Incomplete(t *testing.T) {
+	datadir := t.TempDir()
+	// Files without a TerminationReason, e.g., written by older servers, are
+	// not errors.
+	writeNDT7(t, datadir, &data.NDT7Result{Download: &model.ArchivalData{UUID: "old"}})
+	// Streaming results files that were never closed, as left behind when the
+	// server dies during the subtest, are incomplete.
+	fp, err := results.NewStreamFile("truncated", datadir, spec.SubtestDownload, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Remove the file once done, without ever giving it its final name.
+	defer fp.Abort()
+	if err := fp.WriteHeader(&data.NDT7Result{Download: &model.ArchivalData{UUID: "truncated"}}); err != nil {
+		t.Fatal(err)
+	}
+	fp.RecordServerMeasurement(model.Measurement{AppInfo: &model.AppInfo{NumBytes: 1}})
+
+	records, unreadable, err := readRecords(datadir, func(string, error) {})
+	if err != nil || unreadable != 0 || len(records) != 2 {
+		t.Fatalf("readRecords() = %+v, %d, %v", records, unreadable, err)
+	}
+	for _, r := range records {
+		want := ""
+		if r.UUID == "truncated" {
+			want = "incomplete"
+		}
+		if r.Error != want {
+			t.Errorf("record %s error = %q, want %q", r.UUID, r.Error, want)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// Group summarizes the subtests of the same day, protocol and kind.
+type Group struct {
+	Date     string
+	Protocol string
+	Kind     string
+	Tests    int
+	Errors   int
+	// EarlyExits is the number of ndt7 subtests ended early by the client.
+	EarlyExits int
+	// P10, P50 and P90 are percentiles of the throughput in Mbit/s, among the
+	// subtests that measured it.
+	P10, P50, P90 float64
+	// MinRTTMillis is the median of the minimum RTT, among the subtests that
+	// measured it.
+	MinRTTMillis float64
+}
+
+// percentile returns the p-th percentile of sorted, using the nearest-rank
+// method, or zero if sorted is empty.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// summarize groups records by day, protocol and kind, in this order.
+func summarize(records []Record) []Group {
+	type key struct{ date, protocol, kind string }
+	type values struct {
+		g      Group
+		mbps   []float64
+		minRTT []float64
+	}
+	groups := map[key]*values{}
+	for _, r := range records {
+		k := key{r.Date, r.Protocol, r.Kind}
+		v := groups[k]
+		if v == nil {
+			v = &values{g: Group{Date: r.Date, Protocol: r.Protocol, Kind: r.Kind}}
+			groups[k] = v
+		}
+		v.g.Tests++
+		if r.Error != "" {
+			v.g.Errors++
+		}
+		if r.EarlyExit {
+			v.g.EarlyExits++
+		}
+		if r.MeanThroughputMbps > 0 {
+			v.mbps = append(v.mbps, r.MeanThroughputMbps)
+		}
+		if r.MinRTTMillis > 0 {
+			v.minRTT = append(v.minRTT, r.MinRTTMillis)
+		}
+	}
+	var out []Group
+	for _, v := range groups {
+		sort.Float64s(v.mbps)
+		sort.Float64s(v.minRTT)
+		v.g.P10 = percentile(v.mbps, 10)
+		v.g.P50 = percentile(v.mbps, 50)
+		v.g.P90 = percentile(v.mbps, 90)
+		v.g.MinRTTMillis = percentile(v.minRTT, 50)
+		out = append(out, v.g)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if a.Date != b.Date {
+			return a.Date < b.Date
+		}
+		if a.Protocol != b.Protocol {
+			return a.Protocol < b.Protocol
+		}
+		return a.Kind < b.Kind
+	})
+	return out
+}
+
+// writeSummary writes groups as a table.
+func writeSummary(w io.Writer, groups []Group) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "DATE\tPROTOCOL\tKIND\tTESTS\tERRORS\tEARLY_EXITS\tP10_MBPS\tP50_MBPS\tP90_MBPS\tMIN_RTT_MS\t")
+	for _, g := range groups {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%d\t%.2f\t%.2f\t%.2f\t%.2f\t\n",
+			g.Date, g.Protocol, g.Kind, g.Tests, g.Errors, g.EarlyExits,
+			g.P10, g.P50, g.P90, g.MinRTTMillis)
+	}
+	return tw.Flush()
+}
+
+// csvHeader is the header of the CSV export, in the order of csvRow.
+var csvHeader = []string{
+	"Date", "Protocol", "Kind", "UUID", "StartTime", "ClientIP", "Streams",
+	"MeanThroughputMbps", "MinRTTMillis", "Error", "EarlyExit", "File",
+}
+
+func csvRow(r Record) []string {
+	return []string{
+		r.Date, r.Protocol, r.Kind, r.UUID, r.StartTime.UTC().Format(time.RFC3339Nano),
+		r.ClientIP, strconv.Itoa(r.Streams),
+		strconv.FormatFloat(r.MeanThroughputMbps, 'f', -1, 64),
+		strconv.FormatFloat(r.MinRTTMillis, 'f', -1, 64),
+		r.Error, strconv.FormatBool(r.EarlyExit), r.File,
+	}
+}
+
+// writeCSV writes records as CSV, with a header.
+func writeCSV(w io.Writer, records []Record) error {
+	cw := csv.NewWriter(w)
+	cw.Write(csvHeader)
+	for _, r := range records {
+		cw.Write(csvRow(r))
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeJSONL writes records as JSON Lines.
+func writeJSONL(w io.Writer, records []Record) error {
+	enc := json.NewEncoder(w)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+// ndt7-client runs ndt7 download and upload subtests against an ndt-server.
+//
+// It prints the throughput while the subtests run and a summary at the end,
+// and optionally writes all the measurements to a JSON Lines file. Use it to
+// check a fresh deployment without a browser, e.g., against a local server
+// using the self-signed certificates of gen_local_test_certs.bash:
+//
+//	ndt7-client -server https://localhost -insecure
+//
+// The exit status is 1 if any subtest fails.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"os"
+	"os/signal"
+
+	"github.com/m-lab/go/flagx"
+	"github.com/m-lab/go/rtx"
+	"github.com/m-lab/ndt-server/ndt7/client"
+	"github.com/m-lab/ndt-server/ndt7/spec"
+	"github.com/m-lab/ndt-server/version"
+)
+
+var (
+	server    = flag.String("server", "https://localhost", "The URL of the server, e.g., https://ndt.example.com or http://localhost:8080")
+	earlyExit = flag.Int64("early_exit", 0, "End the subtests after this many MB, which the server must allow (0 runs the full subtests)")
+	duration  = flag.Duration("duration", 0, "The runtime of the subtests, within the bounds of the server (0 uses the server default)")
+	token     = flag.String("token", "", "The access token required by the server, if any")
+	insecure  = flag.Bool("insecure", false, "Do not verify the certificate of the server, e.g., when it is self-signed")
+	jsonFile  = flag.String("json", "", "Write all the measurements to this file as JSON Lines")
+	test      = flagx.Enum{
+		Options: []string{"download", "upload", "both"},
+		Value:   "both",
+	}
+	clientMetadata flagx.KeyValue
+)
+
+func init() {
+	flag.Var(&test, "test", "The subtests to run: download, upload or both")
+	flag.Var(&clientMetadata, "metadata", "Client metadata archived with the results, e.g., client_name=probe,site=lga01")
+}
+
+// subtests returns the subtests selected by the -test flag.
+func subtests(value string) []spec.SubtestKind {
+	switch value {
+	case "download":
+		return []spec.SubtestKind{spec.SubtestDownload}
+	case "upload":
+		return []spec.SubtestKind{spec.SubtestUpload}
+	default:
+		return []spec.SubtestKind{spec.SubtestDownload, spec.SubtestUpload}
+	}
+}
+
+func main() {
+	flag.Parse()
+	c, err := client.New(*server)
+	rtx.Must(err, "invalid server URL %q", *server)
+	c.UserAgent = "ndt7-client/" + version.Version
+	c.AccessToken = *token
+	c.EarlyExit = *earlyExit
+	c.Duration = *duration
+	c.Metadata = clientMetadata.Get()
+	if *insecure {
+		c.Dialer.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	var f *os.File
+	var measurements *json.Encoder
+	if *jsonFile != "" {
+		f, err = os.Create(*jsonFile)
+		rtx.Must(err, "cannot create %s", *jsonFile)
+		measurements = json.NewEncoder(f)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	results := run(ctx, c, subtests(test.Value), os.Stdout, measurements)
+	cancel()
+	printSummary(os.Stdout, results)
+	if f != nil {
+		rtx.Must(f.Close(), "cannot write %s", *jsonFile)
+	}
+	for _, r := range results {
+		if r.err != nil {
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/m-lab/go/testingx"
+	"github.com/m-lab/ndt-server/ndt7/client"
+	"github.com/m-lab/ndt-server/ndt7/ndt7test"
+	"github.com/m-lab/ndt-server/ndt7/spec"
+)
+
+func newClient(t *testing.T) *client.Client {
+	h, srv := ndt7test.NewNDT7Server(t)
+	t.Cleanup(srv.Close)
+	h.MinRuntime, h.MaxRuntime = time.Second, spec.DefaultRuntime
+	c, err := client.New(srv.URL)
+	testingx.Must(t, err, "failed to create client")
+	c.Duration = time.Second
+	c.Metadata = map[string]string{"client_name": "ndt7-client-test"}
+	return c
+}
+
+func TestRun(t *testing.T) {
+	c := newClient(t)
+	out := &bytes.Buffer{}
+	jsonl := &bytes.Buffer{}
+	results := run(context.Background(), c, subtests("both"), out, json.NewEncoder(jsonl))
+	if len(results) != 2 {
+		t.Fatalf("run() returned %d results, want 2", len(results))
+	}
+	for _, r := range results {
+		if r.err != nil || r.uuid == "" || r.summary == nil || r.mbps <= 0 {
+			t.Errorf("wrong %s result: %+v", r.kind, r)
+		}
+	}
+	if !strings.Contains(out.String(), "Mbit/s") {
+		t.Errorf("no progress printed: %q", out.String())
+	}
+
+	summary := &bytes.Buffer{}
+	printSummary(summary, results)
+	lines := strings.Split(strings.TrimSpace(summary.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "download") || !strings.HasPrefix(lines[1], "upload") {
+		t.Fatalf("wrong summary: %q", summary.String())
+	}
+	if !strings.Contains(lines[0], "retransmission") || strings.Contains(lines[1], "retransmission") {
+		t.Errorf("retransmission should only be printed for downloads: %q", summary.String())
+	}
+
+	seen := map[spec.SubtestKind]map[string]bool{}
+	s := bufio.NewScanner(jsonl)
+	for s.Scan() {
+		var m client.Measurement
+		testingx.Must(t, json.Unmarshal(s.Bytes(), &m), "failed to decode %s", s.Text())
+		if seen[m.Test] == nil {
+			seen[m.Test] = map[string]bool{}
+		}
+		seen[m.Test][m.Origin] = true
+	}
+	for _, kind := range subtests("both") {
+		if !seen[kind][client.OriginServer] || !seen[kind][client.OriginClient] {
+			t.Errorf("missing %s measurements: %v", kind, seen[kind])
+		}
+	}
+}
+
+func TestRun_Refused(t *testing.T) {
+	c := newClient(t)
+	// The default early exit policy of the server does not allow 3 MB.
+	c.EarlyExit = 3
+	results := run(context.Background(), c, subtests("download"), &bytes.Buffer{}, nil)
+	var herr *client.HandshakeError
+	if len(results) != 1 || !errors.As(results[0].err, &herr) || herr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("run() = %+v, want a handshake error", results)
+	}
+	summary := &bytes.Buffer{}
+	printSummary(summary, results)
+	if !strings.HasPrefix(summary.String(), "download failed: ") {
+		t.Errorf("wrong summary: %q", summary.String())
+	}
+}
+
+func TestRun_Canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if results := run(ctx, newClient(t), subtests("both"), &bytes.Buffer{}, nil); len(results) != 0 {
+		t.Errorf("run() = %+v, want no results", results)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/m-lab/ndt-server/ndt7/client"
+	"github.com/m-lab/ndt-server/ndt7/model"
+	"github.com/m-lab/ndt-server/ndt7/spec"
+)
+
+// result is the outcome of a subtest.
+type result struct {
+	kind spec.SubtestKind
+	// uuid is the UUID of the subtest, as sent by the server.
+	uuid string
+	// mbps is the last throughput measured by the receiver of the data, i.e.,
+	// the client for downloads and the server for uploads.
+	mbps float64
+	// summary is the final result sent by the server, if any.
+	summary *model.Summary
+	err     error
+}
+
+// run runs the subtests in order. It prints their progress to out and writes
+// all their measurements to measurements, unless it is nil.
+func run(ctx context.Context, c *client.Client, kinds []spec.SubtestKind, out io.Writer, measurements *json.Encoder) []*result {
+	var results []*result
+	for _, kind := range kinds {
+		if ctx.Err() != nil {
+			break
+		}
+		results = append(results, runSubtest(ctx, c, kind, out, measurements))
+	}
+	return results
+}
+
+func runSubtest(ctx context.Context, c *client.Client, kind spec.SubtestKind, out io.Writer, measurements *json.Encoder) *result {
+	r := &result{kind: kind}
+	start := c.Download
+	if kind == spec.SubtestUpload {
+		start = c.Upload
+	}
+	st, err := start(ctx)
+	if err != nil {
+		r.err = err
+		return r
+	}
+	progress := false
+	for m := range st.Measurements {
+		if measurements != nil && r.err == nil {
+			if err := measurements.Encode(&m); err != nil {
+				r.err = fmt.Errorf("cannot write measurements: %w", err)
+			}
+		}
+		if m.Origin == client.OriginServer {
+			if m.ConnectionInfo != nil && m.ConnectionInfo.UUID != "" {
+				r.uuid = m.ConnectionInfo.UUID
+			}
+			if m.Summary != nil {
+				r.summary = m.Summary
+			}
+		}
+		if mbps, elapsed, ok := throughput(&m); ok {
+			r.mbps = mbps
+			fmt.Fprintf(out, "\r%-8s %10.2f Mbit/s %6.1f s", kind, mbps, elapsed)
+			progress = true
+		}
+	}
+	if progress {
+		fmt.Fprintln(out)
+	}
+	if err := st.Err(); err != nil {
+		r.err = err
+	}
+	return r
+}
+
+// throughput returns the throughput in Mbit/s and the elapsed time in seconds
+// of a measurement collected by the receiver of the data of the subtest.
+func throughput(m *client.Measurement) (float64, float64, bool) {
+	receiver := client.OriginClient
+	if m.Test == spec.SubtestUpload {
+		receiver = client.OriginServer
+	}
+	if m.Origin != receiver || m.AppInfo == nil || m.AppInfo.ElapsedTime <= 0 {
+		return 0, 0, false
+	}
+	// NumBytes is in bytes and ElapsedTime is in microseconds.
+	mbps := 8 * float64(m.AppInfo.NumBytes) / float64(m.AppInfo.ElapsedTime)
+	return mbps, float64(m.AppInfo.ElapsedTime) / 1e6, true
+}
+
+// printSummary prints the results of the subtests to w. It prefers the summary
+// computed by the server to the measurements of the receiver.
+func printSummary(w io.Writer, results []*result) {
+	for _, r := range results {
+		switch {
+		case r.err != nil:
+			fmt.Fprintf(w, "%-8s failed: %v\n", r.kind, r.err)
+		case r.summary != nil:
+			fmt.Fprintf(w, "%-8s %10.2f Mbit/s  MinRTT %.2f ms  ",
+				r.kind, r.summary.MeanThroughputMbps, float64(r.summary.MinRTT)/1000)
+			// The server does not compute the retransmission rate of uploads.
+			if r.kind != spec.SubtestUpload {
+				fmt.Fprintf(w, "retransmission %.2f%%  ", 100*r.summary.RetransmissionRate)
+			}
+			fmt.Fprintf(w, "UUID %s\n", r.uuid)
+		default:
+			fmt.Fprintf(w, "%-8s %10.2f Mbit/s  UUID %s\n", r.kind, r.mbps, r.uuid)
+		}
+	}
+}
@@ -67,6 +67,13 @@ type NDT7Result struct {
 	EndTime   time.Time
 
 	// ndt7
-	Upload   *model.ArchivalData `json:",omitempty"`
-	Download *model.ArchivalData `json:",omitempty"`
+	Upload         *model.ArchivalData `json:",omitempty"`
+	Download       *model.ArchivalData `json:",omitempty"`
+	Responsiveness *model.ArchivalData `json:",omitempty"`
+
+	// Multi-stream ndt7 tests save the archival data of every stream in either
+	// UploadStreams or DownloadStreams, and leave Upload and Download empty.
+	Session         *model.SessionData    `json:",omitempty"`
+	UploadStreams   []*model.ArchivalData `json:",omitempty"`
+	DownloadStreams []*model.ArchivalData `json:",omitempty"`
 }
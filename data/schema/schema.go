@@ -0,0 +1,249 @@
+// Package schema generates JSON Schemas for the archival records of ndt-server
+// and validates results against them.
+//
+// The checked-in ndt5.json and ndt7.json files are the published schemas of
+// data.NDT5Result and data.NDT7Result. Fields may be added to the records,
+// but never removed, so that historical data remains compatible. The
+// generate-schemas command fails when a field was removed, and regenerates the
+// files after adding fields with:
+//
+//	cd cmd/generate-schemas
+//	go run . -ndt7 /tmp/ndt7.json -ndt5 /tmp/ndt5.json \
+//		-ndt7-jsonschema ../../data/schema/ndt7.json \
+//		-ndt5-jsonschema ../../data/schema/ndt5.json
+package schema
+
+import (
+	"embed"
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Version is the JSON Schema dialect of the generated schemas.
+const Version = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema is the subset of JSON Schema needed to describe the JSON encoding of
+// Go values.
+type Schema struct {
+	Version              string             `json:"$schema,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Type                 Types              `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+
+	// Bool, when not nil, makes this a boolean schema, which accepts every
+	// value when true and no value when false.
+	Bool *bool `json:"-"`
+}
+
+// Types is the value of the "type" keyword. It is encoded as a string when it
+// contains a single type.
+type Types []string
+
+// MarshalJSON implements json.Marshaler.
+func (t Types) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+	return json.Marshal([]string(t))
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (t *Types) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*t = Types{s}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(t))
+}
+
+// plain has the fields of Schema, without its methods.
+type plain Schema
+
+// MarshalJSON implements json.Marshaler.
+func (s *Schema) MarshalJSON() ([]byte, error) {
+	if s.Bool != nil {
+		return json.Marshal(*s.Bool)
+	}
+	return json.Marshal((*plain)(s))
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (s *Schema) UnmarshalJSON(b []byte) error {
+	var v bool
+	if err := json.Unmarshal(b, &v); err == nil {
+		*s = Schema{Bool: &v}
+		return nil
+	}
+	return json.Unmarshal(b, (*plain)(s))
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	rawMessageType    = reflect.TypeOf(json.RawMessage{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// Generate returns the schema of the JSON encoding of v, following the rules
+// of encoding/json. Fields without omitempty are required, and objects do not
+// accept undeclared properties.
+func Generate(title string, v interface{}) *Schema {
+	s := generate(reflect.TypeOf(v), map[reflect.Type]bool{})
+	s.Version = Version
+	s.Title = title
+	return s
+}
+
+func anything() *Schema {
+	t := true
+	return &Schema{Bool: &t}
+}
+
+func nullable(s *Schema) *Schema {
+	if s.Bool == nil && len(s.Type) > 0 && s.Type[len(s.Type)-1] != "null" {
+		s.Type = append(s.Type, "null")
+	}
+	return s
+}
+
+func generate(t reflect.Type, visiting map[reflect.Type]bool) *Schema {
+	switch {
+	case t == timeType:
+		return &Schema{Type: Types{"string"}, Format: "date-time"}
+	case t == rawMessageType:
+		return anything()
+	case t.Kind() != reflect.Ptr && reflect.PointerTo(t).Implements(jsonMarshalerType):
+		// We cannot know what custom marshalers produce.
+		return anything()
+	case t.Kind() != reflect.Ptr && reflect.PointerTo(t).Implements(textMarshalerType):
+		return &Schema{Type: Types{"string"}}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: Types{"boolean"}}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return &Schema{Type: Types{"integer"}}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: Types{"number"}}
+	case reflect.String:
+		return &Schema{Type: Types{"string"}}
+	case reflect.Ptr:
+		return nullable(generate(t.Elem(), visiting))
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// Base64 encoded.
+			return &Schema{Type: Types{"string", "null"}}
+		}
+		return &Schema{Type: Types{"array", "null"}, Items: generate(t.Elem(), visiting)}
+	case reflect.Array:
+		return &Schema{Type: Types{"array"}, Items: generate(t.Elem(), visiting)}
+	case reflect.Map:
+		return &Schema{Type: Types{"object", "null"}, AdditionalProperties: generate(t.Elem(), visiting)}
+	case reflect.Struct:
+		if visiting[t] {
+			// Recursive types are not expanded.
+			return anything()
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+		no := false
+		s := &Schema{
+			Type:                 Types{"object"},
+			Properties:           map[string]*Schema{},
+			AdditionalProperties: &Schema{Bool: &no},
+		}
+		addFields(s, t, visiting)
+		sort.Strings(s.Required)
+		return s
+	}
+	// E.g., interfaces.
+	return anything()
+}
+
+// addFields adds the fields of the struct type t to s. The fields of embedded
+// structs are promoted, unless t has fields with the same name.
+func addFields(s *Schema, t reflect.Type, visiting map[reflect.Type]bool) {
+	var embedded []reflect.Type
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		ft := f.Type
+		if f.Anonymous && name == "" {
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				embedded = append(embedded, ft)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fs := generate(ft, visiting)
+		if hasOption(opts, "string") {
+			fs = &Schema{Type: Types{"string"}}
+		}
+		s.Properties[name] = fs
+		if !hasOption(opts, "omitempty") {
+			s.Required = append(s.Required, name)
+		}
+	}
+	for _, et := range embedded {
+		promoted := &Schema{Properties: map[string]*Schema{}}
+		addFields(promoted, et, visiting)
+		for name, fs := range promoted.Properties {
+			if _, ok := s.Properties[name]; !ok {
+				s.Properties[name] = fs
+			}
+		}
+		for _, name := range promoted.Required {
+			if s.Properties[name] == promoted.Properties[name] {
+				s.Required = append(s.Required, name)
+			}
+		}
+	}
+}
+
+func hasOption(opts, name string) bool {
+	for _, o := range strings.Split(opts, ",") {
+		if o == name {
+			return true
+		}
+	}
+	return false
+}
+
+//go:embed ndt5.json ndt7.json
+var published embed.FS
+
+// Published returns the checked-in schema of the results of protocol, i.e.,
+// "ndt5" or "ndt7".
+func Published(protocol string) (*Schema, error) {
+	b, err := published.ReadFile(protocol + ".json")
+	if err != nil {
+		return nil, err
+	}
+	s := &Schema{}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
@@ -0,0 +1,133 @@
+package schema
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/m-lab/ndt-server/data"
+	"github.com/m-lab/ndt-server/ndt5/control"
+	"github.com/m-lab/ndt-server/ndt7/model"
+	"github.com/m-lab/ndt-server/sink"
+)
+
+func TestPublished(t *testing.T) {
+	for protocol, row := range map[string]interface{}{
+		"ndt5": data.NDT5Result{},
+		"ndt7": data.NDT7Result{},
+	} {
+		prev, err := Published(protocol)
+		if err != nil {
+			t.Fatalf("Published(%s) error = %v", protocol, err)
+		}
+		cur := Generate(strings.ToUpper(protocol)+"Result", row)
+		// WARNING: never remove fields, see data/result.go.
+		if removed := Removed(prev, cur); len(removed) > 0 {
+			t.Errorf("fields removed from the %s schema: %v", protocol, removed)
+			continue
+		}
+		if !reflect.DeepEqual(prev, cur) {
+			t.Errorf("the published %s schema is outdated; run generate-schemas", protocol)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	type embedded struct {
+		A int
+		B string
+	}
+	type row struct {
+		embedded
+		B        float64
+		C        *time.Time `json:",omitempty"`
+		D        []string   `json:"d"`
+		E        map[string]int
+		F        int64 `json:",string"`
+		Skipped  bool  `json:"-"`
+		internal bool
+	}
+	got, err := json.Marshal(Generate("Row", row{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"$schema":"https://json-schema.org/draft/2020-12/schema","title":"Row","type":"object",` +
+		`"properties":{"A":{"type":"integer"},"B":{"type":"number"},` +
+		`"C":{"type":["string","null"],"format":"date-time"},` +
+		`"E":{"type":["object","null"],"additionalProperties":{"type":"integer"}},` +
+		`"F":{"type":"string"},"d":{"type":["array","null"],"items":{"type":"string"}}},` +
+		`"required":["A","B","E","F","d"],"additionalProperties":false}`
+	if string(got) != want {
+		t.Errorf("Generate() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestSchema_Validate(t *testing.T) {
+	s, err := Published("ndt7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := &data.NDT7Result{
+		StartTime: time.Now(),
+		Download: &model.ArchivalData{
+			UUID:               "uuid",
+			ServerMeasurements: []model.Measurement{{AppInfo: &model.AppInfo{NumBytes: 1}}},
+		},
+	}
+	if err := s.Validate(valid); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+	if err := s.Validate(&data.NDT5Result{Control: &control.ArchivalData{}}); err == nil {
+		t.Errorf("Validate() accepted an ndt5 result")
+	}
+	for name, doc := range map[string]string{
+		"unknown-property": `{"Unknown":1}`,
+		"wrong-type":       `{"ServerPort":"443"}`,
+		"not-integer":      `{"ServerPort":1.5}`,
+	} {
+		var v map[string]interface{}
+		b, _ := json.Marshal(valid)
+		json.Unmarshal(b, &v)
+		json.Unmarshal([]byte(doc), &v)
+		if err := s.Validate(v); err == nil {
+			t.Errorf("%s: Validate() accepted %s", name, doc)
+		}
+	}
+}
+
+func TestValidator_Validate(t *testing.T) {
+	v, err := NewValidator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := &sink.Result{Protocol: "ndt7", Data: &data.NDT7Result{StartTime: time.Now()}}
+	if err := v.Validate(valid); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+	invalid := &sink.Result{Protocol: "ndt5", Data: &data.NDT7Result{Download: &model.ArchivalData{}}}
+	if err := v.Validate(invalid); err == nil {
+		t.Errorf("Validate() accepted an ndt7 result as ndt5")
+	}
+	if err := v.Validate(&sink.Result{Protocol: "other"}); err != nil {
+		t.Errorf("Validate() of an unknown protocol error = %v", err)
+	}
+}
+
+func TestRemoved(t *testing.T) {
+	type inner struct{ X, Y int }
+	type prev struct {
+		A []inner
+		B int
+	}
+	type cur struct {
+		A []struct{ X int }
+		C int
+	}
+	got := Removed(Generate("", prev{}), Generate("", cur{}))
+	want := []string{"A[].Y", "B"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Removed() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,139 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Validate verifies that the JSON encoding of v conforms to s. The error
+// describes the first violation, and the path of the offending value.
+func (s *Schema) Validate(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	// Preserve the precision of large integers.
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return err
+	}
+	return s.validate("$", doc)
+}
+
+// typeOf returns the JSON Schema type of a decoded JSON value.
+func typeOf(doc interface{}) string {
+	switch d := doc.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if strings.ContainsAny(d.String(), ".eE") {
+			return "number"
+		}
+		return "integer"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "unknown"
+}
+
+func (s *Schema) allows(t string) bool {
+	if len(s.Type) == 0 {
+		return true
+	}
+	for _, st := range s.Type {
+		if st == t || (st == "number" && t == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Schema) validate(path string, doc interface{}) error {
+	if s.Bool != nil {
+		if !*s.Bool {
+			return fmt.Errorf("%s: unexpected value", path)
+		}
+		return nil
+	}
+	if t := typeOf(doc); !s.allows(t) {
+		return fmt.Errorf("%s: got %s, want %s", path, t, strings.Join(s.Type, " or "))
+	}
+	switch d := doc.(type) {
+	case []interface{}:
+		if s.Items == nil {
+			return nil
+		}
+		for i, item := range d {
+			if err := s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := d[name]; !ok {
+				return fmt.Errorf("%s: missing required property %s", path, name)
+			}
+		}
+		names := make([]string, 0, len(d))
+		for name := range d {
+			names = append(names, name)
+		}
+		// Report violations deterministically.
+		sort.Strings(names)
+		for _, name := range names {
+			ps, ok := s.Properties[name]
+			if !ok {
+				ps = s.AdditionalProperties
+			}
+			if ps == nil {
+				continue
+			}
+			if err := ps.validate(path+"."+name, d[name]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Removed returns the paths of the properties of prev that cur does not have,
+// e.g., "Download.ServerMeasurements[].TCPInfo.RTT". Results conforming to
+// prev may then not conform to cur.
+func Removed(prev, cur *Schema) []string {
+	var removed []string
+	removedFrom("", prev, cur, &removed)
+	sort.Strings(removed)
+	return removed
+}
+
+func removedFrom(path string, prev, cur *Schema, removed *[]string) {
+	if prev == nil || cur == nil || cur.Bool != nil {
+		// Nothing to compare, or cur accepts (or rejects) anything.
+		return
+	}
+	for name, ps := range prev.Properties {
+		p := name
+		if path != "" {
+			p = path + "." + name
+		}
+		cs, ok := cur.Properties[name]
+		if !ok {
+			*removed = append(*removed, p)
+			continue
+		}
+		removedFrom(p, ps, cs, removed)
+	}
+	removedFrom(path+"[]", prev.Items, cur.Items, removed)
+	removedFrom(path+"{}", prev.AdditionalProperties, cur.AdditionalProperties, removed)
+}
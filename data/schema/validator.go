@@ -0,0 +1,53 @@
+package schema
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/m-lab/ndt-server/logging"
+	"github.com/m-lab/ndt-server/sink"
+)
+
+var (
+	// ValidationErrors counts the results that do not conform to their schema.
+	ValidationErrors = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ndt_result_schema_errors_total",
+			Help: "A counter of results that do not conform to the published schema.",
+		},
+		[]string{"protocol"},
+	)
+)
+
+// Validator is a sink.Validator that validates results against the schema of
+// their protocol. Use it in a sink.Validated to refuse invalid results before
+// they are written.
+type Validator map[string]*Schema
+
+// NewValidator returns a Validator using the published schemas.
+func NewValidator() (Validator, error) {
+	v := Validator{}
+	for _, protocol := range []string{"ndt5", "ndt7"} {
+		s, err := Published(protocol)
+		if err != nil {
+			return nil, err
+		}
+		v[protocol] = s
+	}
+	return v, nil
+}
+
+// Validate implements sink.Validator. Invalid results are also logged and
+// counted.
+func (v Validator) Validate(r *sink.Result) error {
+	s, ok := v[r.Protocol]
+	if !ok {
+		return nil
+	}
+	err := s.Validate(r.Data)
+	if err != nil {
+		logging.Logger.WithError(err).Warn("result " + r.UUID + " does not conform to the " + r.Protocol + " schema")
+		ValidationErrors.WithLabelValues(r.Protocol).Inc()
+	}
+	return err
+}
@@ -4,6 +4,7 @@ go 1.25
 
 require (
 	github.com/apex/log v1.9.0
+	github.com/go-jose/go-jose/v4 v4.1.3
 	github.com/google/uuid v1.6.0
 	github.com/gorilla/handlers v1.5.2
 	github.com/gorilla/websocket v1.5.3
@@ -12,15 +13,16 @@ require (
 	github.com/m-lab/tcp-info v1.8.0
 	github.com/m-lab/uuid v1.0.2
 	github.com/prometheus/client_golang v1.23.2
+	github.com/prometheus/common v0.67.5
 	go.uber.org/goleak v1.3.0
+	golang.org/x/net v0.49.0
 	gopkg.in/m-lab/pipe.v3 v3.0.0-20180108231244-604e84f43ee0
 )
 
 require (
-	github.com/go-jose/go-jose/v4 v4.1.3 // indirect
+	github.com/kylelemons/godebug v1.1.0 // indirect
 	github.com/munnerz/goautoneg v0.0.0-20191010083416-a7dc8b61c822 // indirect
 	go.yaml.in/yaml/v2 v2.4.3 // indirect
-	golang.org/x/net v0.49.0 // indirect
 	golang.org/x/text v0.33.0 // indirect
 )
 
@@ -33,7 +35,6 @@ require (
 	github.com/justinas/alice v1.2.0 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/prometheus/client_model v0.6.2 // indirect
-	github.com/prometheus/common v0.67.5 // indirect
 	github.com/prometheus/procfs v0.19.2 // indirect
 	golang.org/x/crypto v0.47.0
 	golang.org/x/exp v0.0.0-20260112195511-716be5621a96
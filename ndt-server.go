@@ -9,6 +9,8 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -17,14 +19,23 @@ import (
 	"github.com/m-lab/go/flagx"
 	"github.com/m-lab/go/prometheusx"
 	"github.com/m-lab/go/rtx"
+	"github.com/m-lab/ndt-server/data/schema"
 	"github.com/m-lab/ndt-server/logging"
 	"github.com/m-lab/ndt-server/metadata"
+	"github.com/m-lab/ndt-server/ndt5"
 	ndt5handler "github.com/m-lab/ndt-server/ndt5/handler"
 	"github.com/m-lab/ndt-server/ndt5/plain"
+	"github.com/m-lab/ndt-server/ndt7/admission"
 	"github.com/m-lab/ndt-server/ndt7/handler"
 	"github.com/m-lab/ndt-server/ndt7/listener"
+	"github.com/m-lab/ndt-server/ndt7/model"
+	"github.com/m-lab/ndt-server/ndt7/results"
 	"github.com/m-lab/ndt-server/ndt7/spec"
+	"github.com/m-lab/ndt-server/ndt7/stability"
+	"github.com/m-lab/ndt-server/ndt7/transport"
 	"github.com/m-lab/ndt-server/platformx"
+	"github.com/m-lab/ndt-server/sink"
+	"github.com/m-lab/ndt-server/spool"
 	"github.com/m-lab/ndt-server/version"
 	"github.com/m-lab/tcp-info/eventsocket"
 	"golang.org/x/crypto/acme"
@@ -52,12 +63,42 @@ var (
 	dataDir          = flag.String("datadir", "/var/spool/ndt", "The directory in which to write data files")
 	htmlDir          = flag.String("htmldir", "html", "The directory from which to serve static web content.")
 	compress         = flag.Bool("compress-results", true, "Whether to compress result files")
+	spoolMinFreeMB   = flag.Uint64("spool.min_free_mb", 100, "Refuse new tests when datadir has fewer free MB")
+	spoolMinInodes   = flag.Uint64("spool.min_free_inodes", 1000, "Refuse new tests when datadir has fewer free inodes")
+	spoolMaxMB       = flag.Int64("spool.max_mb", 0, "Delete the oldest files in datadir above this many MB (0 disables it)")
+	spoolInterval    = flag.Duration("spool.check_interval", 10*time.Second, "The interval between checks of datadir")
+	resultsValidate  = flag.Bool("results.validate", false, "Validate results against the published schemas, and refuse to save the invalid ones")
+	resultsFile      = flag.Bool("results.file", true, "Whether to save results to files in datadir")
+	webhookURL       = flag.String("results.webhook.url", "", "Also POST results as JSON to this URL (empty disables it)")
+	webhookDir       = flag.String("results.webhook.queue_dir", "", "The directory in which to queue results until the webhook accepts them")
+	webhookMax       = flag.Int("results.webhook.max_queued", 1000, "The maximum number of results queued for the webhook. New results are dropped when the queue is full")
 	deploymentLabels = flagx.KeyValue{}
 	tokenVerifyKey   = flagx.FileBytesArray{}
 	tokenRequired5   bool
 	tokenRequired7   bool
 	isLameDuck       bool
+	lameDuckMu       sync.Mutex
+	sigtermLameDuck  bool
+	spoolLameDuck    bool
 	tokenMachine     = flagx.StringFile{}
+	ndt7MinRuntime   = flag.Duration("ndt7.duration.min", spec.DefaultRuntime, "The minimum ndt7 subtest duration that clients may request")
+	ndt7MaxRuntime   = flag.Duration("ndt7.duration.max", spec.DefaultRuntime, "The maximum ndt7 subtest duration that clients may request")
+	ndt7MaxStreams   = flag.Int("ndt7.streams.max", 0, "The maximum number of parallel streams in a multi-stream ndt7 test (0 disables them)")
+	ndt7MaxRate      = flag.Float64("ndt7.download.max_rate", 0, "Cap the rate of every ndt7 download at this many Mbit/s (0 disables it). Clients with an access token may request a lower cap with the max_rate parameter")
+	ndt7StableTol    = flag.Float64("ndt7.stable.tolerance", 0, "End ndt7 download and upload tests once the rate stays within this percentage (0 disables it)")
+	ndt7StableWindow = flag.Duration("ndt7.stable.window", 2*time.Second, "The interval over which ndt7 rates are measured to detect stability")
+	ndt7StableCount  = flag.Int("ndt7.stable.count", 4, "The number of consecutive ndt7 rates that must be within the stability tolerance")
+	ndt7MaxDownloads = flag.Int("ndt7.admission.max_downloads", 0, "The maximum number of ndt7 downloads running at the same time (0 disables the limit)")
+	ndt7MaxUploads   = flag.Int("ndt7.admission.max_uploads", 0, "The maximum number of ndt7 uploads running at the same time (0 disables the limit)")
+	ndt7QueueSize    = flag.Int("ndt7.admission.queue_size", 10, "The maximum number of ndt7 tests of every direction waiting for admission above the limit")
+	ndt7QueueWait    = flag.Duration("ndt7.admission.max_wait", 5*time.Second, "The maximum time an ndt7 test waits for admission before being refused")
+	ndt7RetryAfter   = flag.Duration("ndt7.admission.retry_after", spec.DefaultRuntime, "The delay after which refused ndt7 clients should retry, sent as Retry-After")
+	earlyExitMB      = flagx.StringArray{}
+	earlyExitTime    = flagx.StringArray{}
+	ndt7CC           = flagx.StringArray{}
+	ndt7Stream       = flag.Bool("ndt7.stream_results", false, "Write ndt7 results incrementally as JSON Lines while the test runs")
+	ndt7ResultTTL    = flag.Duration("ndt7.result.retention", 0, "Serve archived results by UUID at "+spec.ResultURLPath+" for this long after the test, to clients with an access token (0 disables it)")
+	ndt7HTTP2        = flag.Bool("ndt7.http2", false, "Whether to serve ndt7 TLS tests over HTTP/2. WebSockets over HTTP/2 also require GODEBUG=http2xconnect=1")
 
 	// A metric to use to signal that the server is in lame duck mode.
 	lameDuck = promauto.NewGauge(prometheus.GaugeOpts{
@@ -75,6 +116,9 @@ func init() {
 	flag.BoolVar(&tokenRequired7, "ndt7.token.required", false, "Require access token in NDT7 requests")
 	flag.Var(&tokenMachine, "token.machine", "Use given machine name to verify token claims")
 	flag.Var(&deploymentLabels, "label", "Labels to identify the type of deployment.")
+	flag.Var(&earlyExitMB, "ndt7.early_exit.mb", "Accepted ndt7 early_exit thresholds in MB (default 250)")
+	flag.Var(&earlyExitTime, "ndt7.early_exit.time", "Accepted ndt7 early_exit_time thresholds as <seconds>:<MB>, ending the test after <seconds> once <MB> have been transferred")
+	flag.Var(&ndt7CC, "ndt7.cc", "Congestion control algorithms that ndt7 clients may request, e.g., bbr or cubic. The first one is the default (default bbr)")
 	flag.Var(&autocertHostname, "autocert.hostname", "File containing the public hostname to request TLS certs for")
 }
 
@@ -141,13 +185,27 @@ func httpServer(addr string, handler http.Handler) *http.Server {
 		ReadTimeout:  time.Minute,
 		WriteTimeout: time.Minute,
 		// Disable HTTP/2 to fix WebSocket compatibility issues with gorilla/websocket
-		// which doesn't support RFC 8441 HTTP/2 Extended CONNECT for WebSockets
+		// which doesn't support RFC 8441 HTTP/2 Extended CONNECT for WebSockets.
+		// See ndt7TLSServer for the ndt7 exception.
 		TLSNextProto: make(map[string]func(*http.Server, *tls.Conn, http.Handler)),
 	}
 
 	return server
 }
 
+// ndt7TLSServer creates a new *http.Server for ndt7 TLS tests. When enabled with
+// the ndt7.http2 flag, the server negotiates HTTP/2, and the ndt7 handler
+// accepts WebSockets over HTTP/2 streams (RFC 8441) in addition to HTTP/1.1.
+func ndt7TLSServer(addr string, handler http.Handler) *http.Server {
+	server := httpServer(addr, handler)
+	// HTTP/2 handlers need the TCP connection to measure it.
+	server.ConnContext = transport.ConnContext
+	if *ndt7HTTP2 {
+		server.TLSNextProto = nil
+	}
+	return server
+}
+
 // parseDeploymentLabels() returns an array of key-value pairs of type
 // []metadata.NameValue with the deployment label pairs passed in through
 // the "label" flag.
@@ -174,8 +232,28 @@ func parseDeploymentLabels() []metadata.NameValue {
 
 // Set internal lame duck status and metric.
 func setLameDuck(status float64) {
-	isLameDuck = status != 0
-	lameDuck.Set(status)
+	lameDuckMu.Lock()
+	defer lameDuckMu.Unlock()
+	sigtermLameDuck = status != 0
+	updateLameDuck()
+}
+
+// Set lame duck status while datadir cannot accept new results.
+func setSpoolLameDuck(unwritable bool) {
+	lameDuckMu.Lock()
+	defer lameDuckMu.Unlock()
+	spoolLameDuck = unwritable
+	updateLameDuck()
+}
+
+// updateLameDuck must be called with lameDuckMu held.
+func updateLameDuck() {
+	isLameDuck = sigtermLameDuck || spoolLameDuck
+	if isLameDuck {
+		lameDuck.Set(1)
+	} else {
+		lameDuck.Set(0)
+	}
 }
 
 // Handle requests to the /health endpoint.
@@ -193,6 +271,12 @@ func main() {
 	rtx.Must(flagx.ArgsFromEnv(flag.CommandLine), "Could not parse env args")
 
 	serverMetadata := parseDeploymentLabels()
+	if *ndt7ResultTTL > results.MaxRetention {
+		log.Fatalf("ndt7.result.retention must be at most %v", results.MaxRetention)
+	}
+	if *ndt7MinRuntime > *ndt7MaxRuntime {
+		log.Fatalf("ndt7.duration.min (%v) must be at most ndt7.duration.max (%v)", *ndt7MinRuntime, *ndt7MaxRuntime)
+	}
 
 	// TODO: Decide if signal handling is the right approach here.
 	go catchSigterm()
@@ -201,6 +285,10 @@ func main() {
 	defer promSrv.Close()
 
 	platformx.WarnIfNotFullySupported()
+	if *ndt7HTTP2 && !strings.Contains(os.Getenv("GODEBUG"), "http2xconnect=1") {
+		// Clients will fall back to WebSockets over HTTP/1.1.
+		log.Println("WARNING: ndt7.http2 is enabled but GODEBUG=http2xconnect=1 is not set; WebSockets over HTTP/2 are disabled")
+	}
 
 	// Setup sequence of access control http.Handlers. NewVerifier errors are
 	// not fatal as long as tokens are not required. This allows access tokens
@@ -208,7 +296,7 @@ func main() {
 	// verifier is handled safely by Setup and only prints a warning when access
 	// token verification is disabled.
 	v, err := token.NewVerifier(tokenVerifyKey.Get()...)
-	if (tokenRequired5 || tokenRequired7) && err != nil {
+	if (tokenRequired5 || tokenRequired7 || *ndt7ResultTTL > 0) && err != nil {
 		rtx.Must(err, "Failed to load verifier for when tokens are required")
 	}
 
@@ -220,19 +308,81 @@ func main() {
 	rtx.Must(eventSrv.Listen(), "Could not listen on", *eventsocket.Filename)
 	go eventSrv.Serve(ctx)
 
+	// Give their final names to the streaming results files of the subtests
+	// interrupted when the server last stopped, before running new subtests.
+	recovered, err := results.Recover(*dataDir)
+	if err != nil {
+		log.Println("WARNING: cannot recover the interrupted ndt7 results files:", err)
+	}
+	if len(recovered) > 0 {
+		log.Printf("Recovered %d interrupted ndt7 results files", len(recovered))
+	}
+
+	// When results are written to datadir, monitor it, and enter lame duck
+	// mode while results cannot be written.
+	var spoolMgr *spool.Manager
+	if *resultsFile || *ndt7Stream {
+		spoolMgr = &spool.Manager{
+			Dir:           *dataDir,
+			MinFreeBytes:  *spoolMinFreeMB << 20,
+			MinFreeInodes: *spoolMinInodes,
+			MaxBytes:      *spoolMaxMB << 20,
+			Interval:      *spoolInterval,
+			OnChange: func(writable bool) {
+				setSpoolLameDuck(!writable)
+			},
+			// Keep the streaming results files of interrupted subtests.
+			Recover: results.RecoverFile,
+		}
+		go spoolMgr.Run(ctx)
+	}
+
+	// Make the result sinks and start delivering queued webhook results.
+	ndt5Sink, ndt7Sink := sink.Multi{}, sink.Multi{}
+	if *resultsFile {
+		ndt5Sink = append(ndt5Sink, &ndt5.FileSink{DataDir: *dataDir + "/ndt5"})
+		ndt7Sink = append(ndt7Sink, &results.FileSink{DataDir: *dataDir, Compress: *compress})
+	}
+	if *webhookURL != "" {
+		if *webhookDir == "" {
+			log.Fatal("-results.webhook.queue_dir is required with -results.webhook.url")
+		}
+		webhook, err := sink.NewWebhook(*webhookURL, *webhookDir, *webhookMax)
+		rtx.Must(err, "Could not create the webhook sink")
+		go webhook.Run(ctx)
+		ndt5Sink = append(ndt5Sink, webhook)
+		ndt7Sink = append(ndt7Sink, webhook)
+	}
+	// Refuse invalid results before any sink saves them.
+	var ndt5Results, ndt7Results sink.ResultSink = ndt5Sink, ndt7Sink
+	if *resultsValidate {
+		validator, err := schema.NewValidator()
+		rtx.Must(err, "Could not load the result schemas")
+		ndt5Results = sink.Validated{ResultSink: ndt5Sink, Validator: validator}
+		ndt7Results = sink.Validated{ResultSink: ndt7Sink, Validator: validator}
+	}
+	// Refuse ndt5 tests while their result files cannot be written. The ndt7
+	// handler checks the spool itself.
+	if *resultsFile {
+		ndt5Results = sink.Gated{ResultSink: ndt5Results, Gate: spoolMgr}
+	}
+
 	// Enforce tokens and tx controllers on the same ndt5 resource.
 	// NOTE: raw ndt5 requests cannot honor tokens or differentiate between upload/downloads.
 	ndt5Paths := controller.Paths{
 		"/ndt_protocol": true,
 	}
-	// Enforce Tx limits only on downloads.
+	// Enforce Tx limits only on downloads and responsiveness tests, which may
+	// also download.
 	ndt7TxPaths := controller.Paths{
-		spec.DownloadURLPath: true,
+		spec.DownloadURLPath:       true,
+		spec.ResponsivenessURLPath: true,
 	}
-	// Enforce tokens on uploads and downloads.
+	// Enforce tokens on all subtests.
 	ndt7TokenPaths := controller.Paths{
-		spec.DownloadURLPath: true,
-		spec.UploadURLPath:   true,
+		spec.DownloadURLPath:       true,
+		spec.UploadURLPath:         true,
+		spec.ResponsivenessURLPath: true,
 	}
 	// NDT5 uses a raw server, which requires tx5. NDT7 is HTTP only.
 	ac5, tx5 := controller.Setup(ctx, v, tokenRequired5, tokenMachine.Value, ndt5Paths, ndt5Paths)
@@ -240,7 +390,7 @@ func main() {
 
 	// The ndt5 protocol serving non-HTTP-based tests - forwards to Ws-based
 	// server if the first three bytes are "GET".
-	ndt5Server := plain.NewServer(*dataDir+"/ndt5", *ndt5WsAddr, serverMetadata)
+	ndt5Server := plain.NewServer(ndt5Results, *ndt5WsAddr, serverMetadata)
 	rtx.Must(
 		ndt5Server.ListenAndServe(ctx, *ndt5Addr, tx5),
 		"Could not start raw server")
@@ -249,7 +399,7 @@ func main() {
 	// connect to the raw server, which will forward things along.
 	ndt5WsMux := http.NewServeMux()
 	ndt5WsMux.Handle("/", http.FileServer(http.Dir(*htmlDir)))
-	ndt5WsMux.Handle("/ndt_protocol", ndt5handler.NewWS(*dataDir+"/ndt5", serverMetadata))
+	ndt5WsMux.Handle("/ndt_protocol", ndt5handler.NewWS(ndt5Results, serverMetadata))
 	ndt5WsServer := httpServer(
 		*ndt5WsAddr,
 		// NOTE: do not use `ac.Then()` to prevent 'double jeopardy' for
@@ -263,16 +413,61 @@ func main() {
 	// The ndt7 listener serving up NDT7 tests, likely on standard ports.
 	ndt7Mux := http.NewServeMux()
 	ndt7Mux.Handle("/", http.FileServer(http.Dir(*htmlDir)))
+	var earlyExit *model.EarlyExitPolicy
+	if len(earlyExitMB) > 0 || len(earlyExitTime) > 0 {
+		earlyExit, err = handler.ParseEarlyExitPolicy(earlyExitMB, earlyExitTime)
+		rtx.Must(err, "Could not parse the ndt7 early exit policy")
+	}
+	var stable *stability.Criteria
+	if *ndt7StableTol > 0 {
+		stable = &stability.Criteria{
+			Window:    *ndt7StableWindow,
+			Tolerance: *ndt7StableTol / 100,
+			Count:     *ndt7StableCount,
+		}
+	}
+	var admit *admission.Controller
+	if *ndt7MaxDownloads > 0 || *ndt7MaxUploads > 0 {
+		admit = &admission.Controller{
+			MaxDownloads: *ndt7MaxDownloads,
+			MaxUploads:   *ndt7MaxUploads,
+			QueueSize:    *ndt7QueueSize,
+			MaxWait:      *ndt7QueueWait,
+			RetryAfter:   *ndt7RetryAfter,
+		}
+	}
 	ndt7Handler := &handler.Handler{
-		DataDir:         *dataDir,
-		SecurePort:      *ndt7Addr,
-		InsecurePort:    *ndt7AddrCleartext,
-		ServerMetadata:  serverMetadata,
-		CompressResults: *compress,
-		Events:          eventSrv,
+		DataDir:            *dataDir,
+		SecurePort:         *ndt7Addr,
+		InsecurePort:       *ndt7AddrCleartext,
+		ServerMetadata:     serverMetadata,
+		CompressResults:    *compress,
+		Sink:               ndt7Results,
+		Spool:              spoolMgr,
+		ResultRetention:    *ndt7ResultTTL,
+		Events:             eventSrv,
+		MinRuntime:         *ndt7MinRuntime,
+		MaxRuntime:         *ndt7MaxRuntime,
+		MaxStreams:         *ndt7MaxStreams,
+		EarlyExit:          earlyExit,
+		Stability:          stable,
+		CongestionControls: ndt7CC,
+		MaxDownloadRate:    *ndt7MaxRate,
+		StreamResults:      *ndt7Stream,
+		Admission:          admit,
 	}
 	ndt7Mux.Handle(spec.DownloadURLPath, http.HandlerFunc(ndt7Handler.Download))
 	ndt7Mux.Handle(spec.UploadURLPath, http.HandlerFunc(ndt7Handler.Upload))
+	ndt7Mux.Handle(spec.ResponsivenessURLPath, http.HandlerFunc(ndt7Handler.Responsiveness))
+	ndt7Mux.Handle(spec.EarlyExitPolicyURLPath, http.HandlerFunc(ndt7Handler.EarlyExitPolicy))
+	if *ndt7ResultTTL > 0 {
+		// Archived results contain the client IP, and UUIDs may be guessed, so
+		// they always require an access token. There are no tx limits.
+		acResult, _ := controller.Setup(ctx, v, true, tokenMachine.Value, controller.Paths{}, controller.Paths{
+			spec.ResultURLPath: true,
+		})
+		ndt7Mux.Handle(spec.ResultURLPath, acResult.Then(http.HandlerFunc(ndt7Handler.Result)))
+	}
 	ndt7ServerCleartext := httpServer(
 		*ndt7AddrCleartext,
 		ac7.Then(logging.MakeAccessLogHandler(ndt7Mux)),
@@ -285,7 +480,7 @@ func main() {
 		// The ndt5 protocol serving WsS-based tests.
 		ndt5WssMux := http.NewServeMux()
 		ndt5WssMux.Handle("/", http.FileServer(http.Dir(*htmlDir)))
-		ndt5WssMux.Handle("/ndt_protocol", ndt5handler.NewWSS(*dataDir+"/ndt5", *certFile, *keyFile, serverMetadata))
+		ndt5WssMux.Handle("/ndt_protocol", ndt5handler.NewWSS(ndt5Results, *certFile, *keyFile, serverMetadata))
 		ndt5WssServer := httpServer(
 			*ndt5WssAddr,
 			ac5.Then(logging.MakeAccessLogHandler(ndt5WssMux)),
@@ -295,7 +490,7 @@ func main() {
 		defer ndt5WssServer.Close()
 
 		// The ndt7 listener serving up WSS based tests
-		ndt7Server := httpServer(
+		ndt7Server := ndt7TLSServer(
 			*ndt7Addr,
 			ac7.Then(logging.MakeAccessLogHandler(ndt7Mux)),
 		)
@@ -313,7 +508,7 @@ func main() {
 			}
 
 			// The ndt7 listener serving up WSS based tests
-			ndt7Server := httpServer(
+			ndt7Server := ndt7TLSServer(
 				*ndt7Addr,
 				ac7.Then(logging.MakeAccessLogHandler(ndt7Mux)),
 			)
@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"log"
 	"net/http"
 	"net/http/httptest"
@@ -19,6 +21,10 @@ import (
 	"github.com/m-lab/go/prometheusx/promtest"
 	"github.com/m-lab/go/rtx"
 	"github.com/m-lab/ndt-server/metadata"
+	ndt5client "github.com/m-lab/ndt-server/ndt5/client"
+	"github.com/m-lab/ndt-server/ndt5/ndt"
+	"github.com/m-lab/ndt-server/ndt5/protocol"
+	ndt7client "github.com/m-lab/ndt-server/ndt7/client"
 	"go.uber.org/goleak"
 	"gopkg.in/m-lab/pipe.v3"
 )
@@ -54,8 +60,8 @@ func setupMain(t *testing.T) func() {
 
 	// Create self-signed certs in a temp directory.
 	dir := t.TempDir()
-	certFile := "cert.pem"
-	keyFile := "key.pem"
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
 
 	rtx.Must(
 		pipe.Run(
@@ -148,7 +154,7 @@ func Test_MainIntegrationTest(t *testing.T) {
 
 	type testcase struct {
 		name string
-		cmd  string
+		run  func() error
 		// ignoreData's default value (false) will NOT ignore whether data is
 		// produced. This is good, because it forces tests which ignore their output
 		// data to explicitly specify this fact.
@@ -157,76 +163,72 @@ func Test_MainIntegrationTest(t *testing.T) {
 	tests := []testcase{
 		// NOTE: Legacy C++ clients (web100clt, libndt-client, measurement_kit) have been
 		// removed due to compatibility issues with modern compilers. NDT5 protocol is
-		// tested via the Node.js WebSocket client below. NDT7 is tested via ndt7-client-go.
+		// tested via the ndt5 Go client below, and NDT7 via the ndt7 Go client.
 
+		// Test ndt5 plain clients connected to the RAW port
+		{
+			name: "Upload & Download ndt5 plain TLV",
+			run:  ndt5Test(ndt.Plain, ndt5Addr, protocol.TLV, ndt5client.TestC2S|ndt5client.TestS2C),
+		},
+		{
+			name: "Upload & Download ndt5 plain JSON",
+			run:  ndt5Test(ndt.Plain, ndt5Addr, protocol.JSON, ndt5client.TestC2S|ndt5client.TestS2C|ndt5client.TestMETA),
+		},
 		// Test ndt5 WS clients connected to the HTTP port
 		{
 			name: "Upload & Download ndt5 WS",
-			cmd: "timeout 45s node ./testdata/unittest_client.js --server=localhost " +
-				" --port=" + wsAddr + " --protocol=ws --tests=22",
+			run:  ndt5Test(ndt.WS, wsAddr, protocol.JSON, ndt5client.TestC2S|ndt5client.TestS2C),
 		},
 		{
 			name: "Upload ndt5 WS",
-			cmd: "timeout 45s node ./testdata/unittest_client.js --server=localhost " +
-				" --port=" + wsAddr + " --protocol=ws --tests=18",
+			run:  ndt5Test(ndt.WS, wsAddr, protocol.JSON, ndt5client.TestC2S),
 		},
 		{
 			name: "Download ndt5 WS",
-			cmd: "timeout 45s node ./testdata/unittest_client.js --server=localhost " +
-				" --port=" + wsAddr + " --protocol=ws --tests=20",
+			run:  ndt5Test(ndt.WS, wsAddr, protocol.JSON, ndt5client.TestS2C),
 		},
 		// Test ndt5 WS clients connecting to the raw port
 		{
 			name: "Connect ndt5 WS (upload and download) to RAW port",
-			cmd: "timeout 45s node ./testdata/unittest_client.js --server=localhost " +
-				" --port=" + ndt5Addr + " --protocol=ws --tests=22",
+			run:  ndt5Test(ndt.WS, ndt5Addr, protocol.JSON, ndt5client.TestC2S|ndt5client.TestS2C),
 		},
 		{
 			// Start both tests, but kill the client during the upload test.
 			// This causes the server to wait for a test that never comes. After the
 			// timeout, the server should have cleaned up all outstanding goroutines.
 			name: "Upload & Download ndt5 WS with S2C Timeout",
-			cmd: "timeout 45s node ./testdata/unittest_client.js --server=localhost " +
-				" --port=" + wsAddr +
-				" --protocol=ws --abort-c2s-early --tests=22 & " +
-				"sleep 25",
+			run:  ndt5AbortedTest(ndt.WS, wsAddr),
 		},
 		// Test WSS clients with the ndt5 protocol.
 		{
 			name: "Upload ndt5 WSS",
-			cmd: "timeout 45s node ./testdata/unittest_client.js --server=localhost " +
-				" --port=" + wssAddr + " --protocol=wss --acceptinvalidcerts --tests=18",
+			run:  ndt5Test(ndt.WSS, wssAddr, protocol.JSON, ndt5client.TestC2S),
 		},
 		{
 			name: "Download ndt5 WSS",
-			cmd: "timeout 45s node ./testdata/unittest_client.js --server=localhost " +
-				" --port=" + wssAddr + " --protocol=wss --acceptinvalidcerts --tests=20",
+			run:  ndt5Test(ndt.WSS, wssAddr, protocol.JSON, ndt5client.TestS2C),
 		},
 		{
 			name: "Upload & Download ndt5 WSS",
-			cmd: "timeout 45s node ./testdata/unittest_client.js --server=localhost " +
-				" --port=" + wssAddr + " --protocol=wss --acceptinvalidcerts --tests=22",
+			run:  ndt5Test(ndt.WSS, wssAddr, protocol.JSON, ndt5client.TestC2S|ndt5client.TestS2C),
 		},
 		{
 			// Start both tests, but kill the client during the upload test.
 			// This causes the server to wait for a test that never comes. After the
 			// timeout, the server should have cleaned up all outstanding goroutines.
 			name: "Upload & Download ndt5 WSS with S2C Timeout",
-			cmd: "timeout 45s node ./testdata/unittest_client.js --server=localhost " +
-				" --port=" + wssAddr +
-				" --protocol=wss --acceptinvalidcerts --abort-c2s-early --tests=22 & " +
-				"sleep 25",
+			run:  ndt5AbortedTest(ndt.WSS, wssAddr),
 		},
 		// Test NDT7 clients
 		{
 			name: "Test the ndt7 protocol",
-			cmd:  "timeout 45s ndt7-client -no-verify -server localhost:" + ndt7Addr,
+			run:  ndt7Test("wss", ndt7Addr),
 			// Ignore data because Travis does not support BBR.  Once Travis does support BBR, delete this.
 			ignoreData: true,
 		},
 		{
 			name: "Test the ndt7 protocol in cleartext",
-			cmd:  "timeout 45s ndt7-client -scheme ws -server localhost:" + ndt7AddrCleartext,
+			run:  ndt7Test("ws", ndt7AddrCleartext),
 			// Ignore data because Travis does not support BBR.  Once Travis does support BBR, delete this.
 			ignoreData: true,
 		},
@@ -247,10 +249,8 @@ func Test_MainIntegrationTest(t *testing.T) {
 			go t.Run(tc.name, func(t *testing.T) {
 				defer wg.Done()
 				preFileCount := countFiles(dataDir)
-				stdout, stderr, err := pipe.DividedOutput(pipe.Script(tc.name, pipe.System(tc.cmd)))
-				if err != nil {
-					t.Errorf("ERROR %s gave error %q (Command: %s)\nStdout: %s\nStderr: %s\n",
-						tc.name, err, tc.cmd, string(stdout), string(stderr))
+				if err := tc.run(); err != nil {
+					t.Errorf("ERROR %s gave error %q", tc.name, err)
 				}
 				postFileCount := countFiles(dataDir)
 				if !tc.ignoreData {
@@ -259,13 +259,70 @@ func Test_MainIntegrationTest(t *testing.T) {
 						t.Error("No files produced. Before test:", preFileCount, "files. After test:", postFileCount, "files.")
 					}
 				}
-				t.Logf("%s (command=%q) has completed successfully", tc.name, tc.cmd)
+				t.Logf("%s has completed successfully", tc.name)
 			})
 		}(c)
 	}
 	wg.Wait()
 }
 
+// ndt5Test returns a function running the given tests with an ndt5 client of
+// the given kind connected to localhost:port.
+func ndt5Test(kind ndt.ConnectionType, port string, encoding protocol.Encoding, tests int) func() error {
+	return func() error {
+		c := ndt5client.New(kind, "localhost:"+port)
+		c.Encoding = encoding
+		c.Tests = tests
+		// Accept the self-signed certificate.
+		c.Dialer.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		_, err := c.Run(context.Background())
+		return err
+	}
+}
+
+// ndt5AbortedTest returns a function running the upload and download tests
+// with an ndt5 client that disappears during the upload test, and then waits
+// for the server to give up.
+func ndt5AbortedTest(kind ndt.ConnectionType, port string) func() error {
+	return func() error {
+		c := ndt5client.New(kind, "localhost:"+port)
+		c.Tests = ndt5client.TestC2S | ndt5client.TestS2C
+		c.Dialer.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		if _, err := c.Run(ctx); err == nil {
+			return errors.New("the test completed before the client disappeared")
+		}
+		time.Sleep(25 * time.Second)
+		return nil
+	}
+}
+
+// ndt7Test returns a function running the download and upload subtests with
+// an ndt7 client connected to localhost:port using the given scheme.
+func ndt7Test(scheme, port string) func() error {
+	return func() error {
+		c, err := ndt7client.New(scheme + "://localhost:" + port)
+		if err != nil {
+			return err
+		}
+		// Accept the self-signed certificate.
+		c.Dialer.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		ctx, cancel := context.WithTimeout(context.Background(), 45*time.Second)
+		defer cancel()
+		for _, start := range []func(context.Context) (*ndt7client.Subtest, error){c.Download, c.Upload} {
+			st, err := start(ctx)
+			if err != nil {
+				return err
+			}
+			if _, err := st.Wait(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
 func Test_ParseDeploymentLabels(t *testing.T) {
 	tests := []struct {
 		name   string
@@ -0,0 +1,139 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/gorilla/websocket"
+	"github.com/m-lab/ndt-server/ndt5/protocol"
+)
+
+// channel reads and writes TLV messages on the control connection.
+type channel interface {
+	readTLV() (protocol.MessageType, []byte, error)
+	writeTLV(kind protocol.MessageType, body []byte) error
+	Close() error
+}
+
+// tlv returns the TLV encoding of a message.
+func tlv(kind protocol.MessageType, body []byte) ([]byte, error) {
+	if len(body) > 0xFFFF {
+		return nil, fmt.Errorf("%s message too long: %d bytes", kind, len(body))
+	}
+	b := make([]byte, 3, 3+len(body))
+	b[0] = byte(kind)
+	b[1] = byte(len(body) >> 8)
+	b[2] = byte(len(body))
+	return append(b, body...), nil
+}
+
+// plainChannel is the control connection of plain clients, where messages are
+// written directly on the TCP connection.
+type plainChannel struct {
+	net.Conn
+}
+
+func (pc *plainChannel) readTLV() (protocol.MessageType, []byte, error) {
+	header := make([]byte, 3)
+	if _, err := io.ReadFull(pc, header); err != nil {
+		return protocol.MsgUnknown, nil, err
+	}
+	body := make([]byte, int(header[1])<<8|int(header[2]))
+	if _, err := io.ReadFull(pc, body); err != nil {
+		return protocol.MsgUnknown, nil, err
+	}
+	return protocol.MessageType(header[0]), body, nil
+}
+
+func (pc *plainChannel) writeTLV(kind protocol.MessageType, body []byte) error {
+	b, err := tlv(kind, body)
+	if err != nil {
+		return err
+	}
+	_, err = pc.Write(b)
+	return err
+}
+
+// wsChannel is the control connection of WS and WSS clients, where every
+// message is a binary WebSocket message.
+type wsChannel struct {
+	*websocket.Conn
+}
+
+func (wc *wsChannel) readTLV() (protocol.MessageType, []byte, error) {
+	_, b, err := wc.ReadMessage()
+	if err != nil {
+		return protocol.MsgUnknown, nil, err
+	}
+	if len(b) < 3 {
+		return protocol.MsgUnknown, nil, fmt.Errorf("message too short: %d bytes", len(b))
+	}
+	if size := int(b[1])<<8 | int(b[2]); size != len(b)-3 {
+		return protocol.MsgUnknown, nil, fmt.Errorf("message length (%d) does not match length of data received (%d)", size, len(b)-3)
+	}
+	return protocol.MessageType(b[0]), b[3:], nil
+}
+
+func (wc *wsChannel) writeTLV(kind protocol.MessageType, body []byte) error {
+	b, err := tlv(kind, body)
+	if err != nil {
+		return err
+	}
+	return wc.WriteMessage(websocket.BinaryMessage, b)
+}
+
+// control sends and receives the messages of the control protocol using the
+// negotiated encoding.
+type control struct {
+	ch       channel
+	encoding protocol.Encoding
+}
+
+// send sends a message of the given kind.
+func (c *control) send(kind protocol.MessageType, msg string) error {
+	if c.encoding == protocol.JSON {
+		msg = (&protocol.JSONMessage{Msg: msg}).String()
+	}
+	return c.ch.writeTLV(kind, []byte(msg))
+}
+
+// receiveRaw receives a message of one of the expected kinds, and returns its
+// kind and its body without decoding it.
+func (c *control) receiveRaw(expected ...protocol.MessageType) (protocol.MessageType, []byte, error) {
+	kind, body, err := c.ch.readTLV()
+	if err != nil {
+		return kind, nil, err
+	}
+	if !slices.Contains(expected, kind) {
+		if kind == protocol.MsgError {
+			return kind, nil, fmt.Errorf("server error: %s", strings.TrimSpace(c.decode(body)))
+		}
+		return kind, nil, fmt.Errorf("wrong message type: wanted one of %v, got %s", expected, kind)
+	}
+	return kind, body, nil
+}
+
+// receive is like receiveRaw, but returns the decoded message.
+func (c *control) receive(expected ...protocol.MessageType) (protocol.MessageType, string, error) {
+	kind, body, err := c.receiveRaw(expected...)
+	if err != nil {
+		return kind, "", err
+	}
+	return kind, c.decode(body), nil
+}
+
+// decode returns the message contained in body.
+func (c *control) decode(body []byte) string {
+	if c.encoding != protocol.JSON {
+		return string(body)
+	}
+	var m protocol.JSONMessage
+	if err := json.Unmarshal(body, &m); err != nil {
+		return string(body)
+	}
+	return m.Msg
+}
@@ -0,0 +1,262 @@
+// Package client implements a client of the legacy ndt5 protocol.
+//
+// The client speaks the protocol spoken by the ndt5 server: plain clients
+// connect over TCP and log in using either MsgLogin, which selects TLV
+// messages, or MsgExtendedLogin, which selects JSON messages, and then read the
+// kickoff string. WS and WSS clients connect to the "/ndt_protocol" WebSocket
+// endpoint and always use JSON messages. The tests run on separate connections
+// to the ports announced by the server, using the same kind of connection.
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/m-lab/ndt-server/metadata"
+	"github.com/m-lab/ndt-server/ndt5/ndt"
+	"github.com/m-lab/ndt-server/ndt5/protocol"
+)
+
+// Tests that a client may request. The server only runs the tests that it
+// supports, and it requires every client to support TestStatus, which is
+// always requested.
+const (
+	TestC2S    = 2
+	TestS2C    = 4
+	TestStatus = 16
+	TestMETA   = 32
+)
+
+// Kickoff is the string sent by the server to plain clients after the login
+// message.
+const Kickoff = "123456 654321"
+
+// URLPath is the path of the WebSocket endpoints of the server.
+const URLPath = "/ndt_protocol"
+
+// Values of the SrvQueue message.
+const (
+	srvQueueReady     = "0"
+	srvQueueFault     = "9977"
+	srvQueueHeartbeat = "9990"
+)
+
+// maxRuntime bounds the runtime of a client. The server gives up after 45s.
+const maxRuntime = time.Minute
+
+// Client runs ndt5 tests against a server. Configure the optional fields
+// before calling Run.
+type Client struct {
+	// Dialer is the WebSocket dialer of WS and WSS clients. Use
+	// Dialer.TLSClientConfig to configure TLS.
+	Dialer websocket.Dialer
+	// Encoding is the encoding of the messages of plain clients, i.e.,
+	// protocol.TLV or protocol.JSON. WS and WSS clients always use JSON.
+	Encoding protocol.Encoding
+	// Tests are the tests to run, e.g., TestC2S|TestS2C.
+	Tests int
+	// Version is the client version sent with MsgExtendedLogin.
+	Version string
+	// Metadata is sent to the server by the META test. The server accepts at
+	// most 20 values.
+	Metadata []metadata.NameValue
+
+	kind ndt.ConnectionType
+	addr string
+}
+
+// New creates a client connecting to the server at addr, e.g.,
+// "ndt.example.com:3001", using connections of the given kind. By default,
+// the client runs the C2S, S2C and META tests using JSON messages.
+func New(kind ndt.ConnectionType, addr string) *Client {
+	return &Client{
+		Dialer: websocket.Dialer{
+			HandshakeTimeout: 10 * time.Second,
+		},
+		Encoding: protocol.JSON,
+		Tests:    TestC2S | TestS2C | TestMETA,
+		Version:  "v3.7.0",
+		kind:     kind,
+		addr:     addr,
+	}
+}
+
+// Result contains the results of the tests run by the server.
+type Result struct {
+	// ServerVersion is the version sent by the server after the login.
+	ServerVersion string
+	// C2S and S2C are the results of the C2S and S2C tests. They are nil if
+	// the server did not run them.
+	C2S *C2SResult
+	S2C *S2CResult
+	// Meta is true if the server ran the META test.
+	Meta bool
+	// Results is the text of the MsgResults messages sent by the server.
+	Results string
+}
+
+// Run runs the tests and returns their results. The returned result contains
+// the results of the tests completed before any error.
+func (c *Client) Run(ctx context.Context) (*Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, maxRuntime)
+	defer cancel()
+	ch, err := c.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer ch.Close()
+	// Interrupt blocking reads and writes when ctx is done.
+	stop := context.AfterFunc(ctx, func() { ch.Close() })
+	defer stop()
+	ctl := &control{ch: ch, encoding: protocol.JSON}
+	if c.kind == ndt.Plain {
+		ctl.encoding = c.Encoding
+	}
+	result := &Result{}
+	err = c.run(ctx, ctl, result)
+	if err != nil && ctx.Err() != nil {
+		// The error is a consequence of closing the connection.
+		err = ctx.Err()
+	}
+	return result, err
+}
+
+func (c *Client) run(ctx context.Context, ctl *control, result *Result) error {
+	tests, err := c.login(ctl, result)
+	if err != nil {
+		return err
+	}
+	for _, test := range tests {
+		switch test {
+		case strconv.Itoa(TestC2S):
+			result.C2S, err = c.c2s(ctx, ctl)
+		case strconv.Itoa(TestS2C):
+			result.S2C, err = c.s2c(ctx, ctl)
+		case strconv.Itoa(TestMETA):
+			err = c.meta(ctl)
+			result.Meta = err == nil
+		default:
+			err = fmt.Errorf("unknown test %q", test)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for {
+		kind, msg, err := ctl.receive(protocol.MsgResults, protocol.MsgLogout)
+		if err != nil {
+			return err
+		}
+		if kind == protocol.MsgLogout {
+			return nil
+		}
+		result.Results += msg
+	}
+}
+
+// connect opens the control connection.
+func (c *Client) connect(ctx context.Context) (channel, error) {
+	if c.kind != ndt.Plain {
+		conn, err := c.dialWS(ctx, c.addr, "ndt")
+		if err != nil {
+			return nil, err
+		}
+		return &wsChannel{conn}, nil
+	}
+	conn, err := c.dialPlain(ctx, c.addr)
+	if err != nil {
+		return nil, err
+	}
+	return &plainChannel{conn}, nil
+}
+
+// dialPlain opens a plain TCP connection to addr.
+func (c *Client) dialPlain(ctx context.Context, addr string) (net.Conn, error) {
+	d := &net.Dialer{}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	return conn, nil
+}
+
+// dialWS opens a WebSocket connection to addr using the given subprotocol.
+func (c *Client) dialWS(ctx context.Context, addr, subprotocol string) (*websocket.Conn, error) {
+	u := url.URL{Scheme: "ws", Host: addr, Path: URLPath}
+	if c.kind == ndt.WSS {
+		u.Scheme = "wss"
+	}
+	headers := http.Header{}
+	headers.Add("Sec-WebSocket-Protocol", subprotocol)
+	conn, _, err := c.Dialer.DialContext(ctx, u.String(), headers)
+	if err != nil {
+		return nil, err
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetReadDeadline(deadline)
+	conn.SetWriteDeadline(deadline)
+	return conn, nil
+}
+
+// login logs in and returns the tests that the server will run.
+func (c *Client) login(ctl *control, result *Result) ([]string, error) {
+	tests := c.Tests | TestStatus
+	var err error
+	if ctl.encoding == protocol.JSON {
+		msg := &protocol.JSONMessage{Msg: c.Version, Tests: strconv.Itoa(tests)}
+		err = ctl.ch.writeTLV(protocol.MsgExtendedLogin, []byte(msg.String()))
+	} else {
+		err = ctl.ch.writeTLV(protocol.MsgLogin, []byte{byte(tests)})
+	}
+	if err != nil {
+		return nil, err
+	}
+	// The server sends the kickoff string to plain clients once it has
+	// received the login message.
+	if pc, ok := ctl.ch.(*plainChannel); ok {
+		kickoff := make([]byte, len(Kickoff))
+		if _, err := io.ReadFull(pc, kickoff); err != nil {
+			return nil, err
+		}
+		if string(kickoff) != Kickoff {
+			return nil, fmt.Errorf("wrong kickoff string %q", kickoff)
+		}
+	}
+	// The server sends SrvQueue messages until the tests can start.
+	for {
+		_, msg, err := ctl.receive(protocol.SrvQueue)
+		if err != nil {
+			return nil, err
+		}
+		if msg == srvQueueReady {
+			break
+		}
+		switch msg {
+		case srvQueueFault:
+			return nil, fmt.Errorf("server terminated the test with SrvQueue %s", msg)
+		case srvQueueHeartbeat:
+			if err := ctl.send(protocol.MsgWaiting, ""); err != nil {
+				return nil, err
+			}
+		}
+	}
+	_, result.ServerVersion, err = ctl.receive(protocol.MsgLogin)
+	if err != nil {
+		return nil, err
+	}
+	_, msg, err := ctl.receive(protocol.MsgLogin)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(msg), nil
+}
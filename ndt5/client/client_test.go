@@ -0,0 +1,222 @@
+package client_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/m-lab/go/testingx"
+	"github.com/m-lab/ndt-server/data"
+	"github.com/m-lab/ndt-server/metadata"
+	"github.com/m-lab/ndt-server/ndt5/client"
+	"github.com/m-lab/ndt-server/ndt5/handler"
+	"github.com/m-lab/ndt-server/ndt5/ndt"
+	"github.com/m-lab/ndt-server/ndt5/plain"
+	"github.com/m-lab/ndt-server/ndt5/protocol"
+	"github.com/m-lab/ndt-server/netx"
+	"github.com/m-lab/ndt-server/sink"
+)
+
+// chanSink passes the saved results to a channel.
+type chanSink chan *data.NDT5Result
+
+func (cs chanSink) Save(r *sink.Result) error {
+	cs <- r.Data.(*data.NDT5Result)
+	return nil
+}
+
+type accepter struct{}
+
+func (accepter) Accept(l net.Listener) (net.Conn, error) {
+	return l.Accept()
+}
+
+// writeCert writes a self-signed certificate for 127.0.0.1 in dir, and returns
+// the names of the certificate and key files and a pool containing the
+// certificate.
+func writeCert(t *testing.T, dir string) (string, string, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	testingx.Must(t, err, "failed to generate key")
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	testingx.Must(t, err, "failed to create certificate")
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	testingx.Must(t, err, "failed to marshal key")
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	testingx.Must(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600), "failed to write cert")
+	testingx.Must(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600), "failed to write key")
+	cert, err := x509.ParseCertificate(der)
+	testingx.Must(t, err, "failed to parse certificate")
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return certFile, keyFile, pool
+}
+
+// servers are the ndt5 servers used by the tests.
+type servers struct {
+	plain, ws, wss string
+	pool           *x509.CertPool
+	results        chanSink
+}
+
+// serve starts an http.Server for h on a netx.Listener and returns its address.
+func serve(t *testing.T, h http.Handler, certFile, keyFile string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	testingx.Must(t, err, "failed to listen")
+	mux := http.NewServeMux()
+	mux.Handle(client.URLPath, h)
+	srv := &http.Server{Handler: mux}
+	ln := netx.NewListener(l.(*net.TCPListener))
+	go func() {
+		if certFile != "" {
+			srv.ServeTLS(ln, certFile, keyFile)
+		} else {
+			srv.Serve(ln)
+		}
+	}()
+	t.Cleanup(func() { srv.Close() })
+	return l.Addr().String()
+}
+
+func startServers(t *testing.T) *servers {
+	s := &servers{results: make(chanSink, 10)}
+	certFile, keyFile, pool := writeCert(t, t.TempDir())
+	s.pool = pool
+	s.ws = serve(t, handler.NewWS(s.results, nil), "", "")
+	s.wss = serve(t, handler.NewWSS(s.results, certFile, keyFile, nil), certFile, keyFile)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	ps := plain.NewServer(s.results, s.ws, nil)
+	testingx.Must(t, ps.ListenAndServe(ctx, "127.0.0.1:0", accepter{}), "failed to start plain server")
+	s.plain = ps.Addr().String()
+	return s
+}
+
+// clients returns a client for every supported combination of connection
+// and encoding.
+func (s *servers) clients() map[string]*client.Client {
+	tlv := client.New(ndt.Plain, s.plain)
+	tlv.Encoding = protocol.TLV
+	wss := client.New(ndt.WSS, s.wss)
+	wss.Dialer.TLSClientConfig = &tls.Config{RootCAs: s.pool}
+	return map[string]*client.Client{
+		"plain-tlv":  tlv,
+		"plain-json": client.New(ndt.Plain, s.plain),
+		"ws":         client.New(ndt.WS, s.ws),
+		// The plain server forwards WebSocket connections to the WS server.
+		"ws-to-plain-port": client.New(ndt.WS, s.plain),
+		"wss":              wss,
+	}
+}
+
+func TestClient_Meta(t *testing.T) {
+	s := startServers(t)
+	for name, c := range s.clients() {
+		c.Tests = client.TestMETA
+		c.Metadata = []metadata.NameValue{{Name: "client.name", Value: name}}
+		result, err := c.Run(context.Background())
+		if err != nil {
+			t.Errorf("%s: Run() failed: %v", name, err)
+			continue
+		}
+		if !result.Meta || result.C2S != nil || result.S2C != nil {
+			t.Errorf("%s: wrong result; got %+v", name, result)
+		}
+		record := <-s.results
+		if len(record.Control.ClientMetadata) != 1 || record.Control.ClientMetadata[0].Value != name {
+			t.Errorf("%s: wrong client metadata; got %+v", name, record.Control.ClientMetadata)
+		}
+	}
+}
+
+func TestClient_Run(t *testing.T) {
+	if testing.Short() {
+		t.Skip("C2S and S2C tests take 20 seconds")
+	}
+	s := startServers(t)
+	for name, c := range s.clients() {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			result, err := c.Run(context.Background())
+			testingx.Must(t, err, "Run() failed")
+			if result.C2S == nil || result.C2S.BytesSent == 0 || result.C2S.ServerKbps <= 0 {
+				t.Errorf("wrong c2s result; got %+v", result.C2S)
+			}
+			if result.S2C == nil || result.S2C.BytesReceived == 0 || result.S2C.ServerKbps <= 0 ||
+				result.S2C.Web100["NDTResult.S2C.UUID"] == "" {
+				t.Errorf("wrong s2c result; got %+v", result.S2C)
+			}
+			if !result.Meta || result.ServerVersion == "" || result.Results == "" {
+				t.Errorf("wrong result; got %+v", result)
+			}
+		})
+	}
+}
+
+// closedGate is a sink.Gate that refuses every test.
+type closedGate struct{}
+
+func (closedGate) Err() error { return errors.New("datadir is full") }
+func (closedGate) Fail(error) {}
+
+func TestClient_GateClosed(t *testing.T) {
+	results := make(chanSink, 1)
+	rs := sink.Gated{ResultSink: results, Gate: closedGate{}}
+	ws := serve(t, handler.NewWS(rs, nil), "", "")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ps := plain.NewServer(rs, ws, nil)
+	testingx.Must(t, ps.ListenAndServe(ctx, "127.0.0.1:0", accepter{}), "failed to start plain server")
+	for name, c := range map[string]*client.Client{
+		"plain": client.New(ndt.Plain, ps.Addr().String()),
+		"ws":    client.New(ndt.WS, ws),
+	} {
+		c.Tests = client.TestMETA
+		if _, err := c.Run(context.Background()); err == nil {
+			t.Errorf("%s: Run() succeeded while the gate is closed", name)
+		}
+	}
+	select {
+	case r := <-results:
+		t.Errorf("a refused test saved a result: %+v", r)
+	default:
+	}
+}
+
+func TestClient_Refused(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	testingx.Must(t, err, "failed to listen")
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			conn.Write([]byte("not a kickoff"))
+			conn.Close()
+		}
+	}()
+	defer l.Close()
+	c := client.New(ndt.Plain, l.Addr().String())
+	if _, err := c.Run(context.Background()); err == nil {
+		t.Error("Run() succeeded with a wrong kickoff string")
+	}
+}
@@ -0,0 +1,303 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/m-lab/ndt-server/ndt5/ndt"
+	"github.com/m-lab/ndt-server/ndt5/protocol"
+)
+
+// c2sDuration is the duration of the C2S test. The server measures the
+// first 10 seconds.
+const c2sDuration = 10 * time.Second
+
+// C2SResult contains the results of the C2S (upload) test.
+type C2SResult struct {
+	// ServerKbps is the throughput measured by the server, in Kbit/s.
+	ServerKbps float64
+	// ClientKbps is the throughput measured by the client, in Kbit/s.
+	ClientKbps float64
+	// BytesSent is the number of bytes sent by the client.
+	BytesSent int64
+}
+
+// S2CResult contains the results of the S2C (download) test.
+type S2CResult struct {
+	// ServerKbps is the throughput measured by the server, in Kbit/s.
+	ServerKbps float64
+	// UnsentBytes and TotalSentBytes are the bytes that the server could not
+	// send and the bytes that it sent.
+	UnsentBytes    int64
+	TotalSentBytes int64
+	// ClientKbps is the throughput measured by the client, in Kbit/s, which is
+	// sent to the server.
+	ClientKbps float64
+	// BytesReceived is the number of bytes received by the client.
+	BytesReceived int64
+	// Web100 contains the variables sent by the server at the end of the test,
+	// e.g., "MinRTT".
+	Web100 map[string]string
+}
+
+// isTimeout returns whether err is caused by a deadline. The WebSocket library
+// does not wrap the original error, so we cannot use os.ErrDeadlineExceeded.
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// testConn is a connection to the port of a test.
+type testConn interface {
+	// fill sends data until deadline.
+	fill(deadline time.Time) (int64, error)
+	// drain receives data until the server closes the connection.
+	drain() (int64, error)
+	Close() error
+}
+
+type plainTestConn struct {
+	net.Conn
+}
+
+func (tc *plainTestConn) fill(deadline time.Time) (int64, error) {
+	tc.SetWriteDeadline(deadline)
+	data := make([]byte, 8192)
+	var total int64
+	for time.Now().Before(deadline) {
+		n, err := tc.Write(data)
+		total += int64(n)
+		if isTimeout(err) {
+			break
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+func (tc *plainTestConn) drain() (int64, error) {
+	return io.Copy(io.Discard, tc)
+}
+
+type wsTestConn struct {
+	*websocket.Conn
+}
+
+func (tc *wsTestConn) fill(deadline time.Time) (int64, error) {
+	tc.SetWriteDeadline(deadline)
+	// Messages of a little less than 80 KiB, so that, including the
+	// WebSocket header of masked messages, they are a multiple of 8 KiB.
+	msg, err := websocket.NewPreparedMessage(websocket.BinaryMessage, make([]byte, 81920-14))
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for time.Now().Before(deadline) {
+		err := tc.WritePreparedMessage(msg)
+		if isTimeout(err) {
+			break
+		}
+		if err != nil {
+			return total, err
+		}
+		total += 81920 - 14
+	}
+	return total, nil
+}
+
+func (tc *wsTestConn) drain() (int64, error) {
+	var total int64
+	for {
+		_, r, err := tc.NextReader()
+		if err != nil {
+			// The server closes the connection without a close message.
+			return total, nil
+		}
+		n, err := io.Copy(io.Discard, r)
+		total += n
+		if err != nil {
+			return total, nil
+		}
+	}
+}
+
+// prepare waits for the TestPrepare message of a test and connects to the
+// announced port.
+func (c *Client) prepare(ctx context.Context, ctl *control, direction string) (testConn, error) {
+	_, msg, err := ctl.receive(protocol.TestPrepare)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(strings.TrimSpace(msg))
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid port %q", direction, msg)
+	}
+	host, _, err := net.SplitHostPort(c.addr)
+	if err != nil {
+		return nil, err
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	if c.kind == ndt.Plain {
+		conn, err := c.dialPlain(ctx, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &plainTestConn{conn}, nil
+	}
+	conn, err := c.dialWS(ctx, addr, direction)
+	if err != nil {
+		return nil, err
+	}
+	return &wsTestConn{conn}, nil
+}
+
+// kbps returns the throughput of a transfer of the given bytes, in Kbit/s.
+func kbps(bytes int64, d time.Duration) float64 {
+	return 8 * float64(bytes) / 1000 / d.Seconds()
+}
+
+// c2s runs the C2S test.
+func (c *Client) c2s(ctx context.Context, ctl *control) (*C2SResult, error) {
+	tc, err := c.prepare(ctx, ctl, "c2s")
+	if err != nil {
+		return nil, err
+	}
+	defer tc.Close()
+	if _, _, err := ctl.receive(protocol.TestStart); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	sent, err := tc.fill(start.Add(c2sDuration))
+	if err != nil {
+		return nil, err
+	}
+	result := &C2SResult{
+		BytesSent:  sent,
+		ClientKbps: kbps(sent, time.Since(start)),
+	}
+	_, msg, err := ctl.receive(protocol.TestMsg)
+	if err != nil {
+		return nil, err
+	}
+	if result.ServerKbps, err = strconv.ParseFloat(strings.TrimSpace(msg), 64); err != nil {
+		return nil, fmt.Errorf("c2s: invalid throughput %q", msg)
+	}
+	if _, _, err := ctl.receive(protocol.TestFinalize); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// s2cMessage is the JSON encoding of the S2C results sent by the server.
+type s2cMessage struct {
+	ThroughputValue  string
+	UnsentDataAmount string
+	TotalSentByte    string
+}
+
+// parseS2CResults parses the results of the S2C test sent by the server, which
+// are a JSON object or three space-separated numbers.
+func parseS2CResults(encoding protocol.Encoding, body []byte, result *S2CResult) error {
+	var fields []string
+	if encoding == protocol.JSON {
+		var m s2cMessage
+		if err := json.Unmarshal(body, &m); err != nil {
+			return err
+		}
+		fields = []string{m.ThroughputValue, m.UnsentDataAmount, m.TotalSentByte}
+	} else {
+		fields = strings.Fields(string(body))
+	}
+	if len(fields) != 3 {
+		return fmt.Errorf("s2c: invalid results %q", body)
+	}
+	var err error
+	if result.ServerKbps, err = strconv.ParseFloat(fields[0], 64); err != nil {
+		return err
+	}
+	if result.UnsentBytes, err = strconv.ParseInt(fields[1], 10, 64); err != nil {
+		return err
+	}
+	result.TotalSentBytes, err = strconv.ParseInt(fields[2], 10, 64)
+	return err
+}
+
+// s2c runs the S2C test.
+func (c *Client) s2c(ctx context.Context, ctl *control) (*S2CResult, error) {
+	tc, err := c.prepare(ctx, ctl, "s2c")
+	if err != nil {
+		return nil, err
+	}
+	defer tc.Close()
+	if _, _, err := ctl.receive(protocol.TestStart); err != nil {
+		return nil, err
+	}
+	// The server sends data for 10 seconds, and then closes the connection.
+	start := time.Now()
+	received, err := tc.drain()
+	if err != nil {
+		return nil, err
+	}
+	result := &S2CResult{
+		BytesReceived: received,
+		ClientKbps:    kbps(received, time.Since(start)),
+		Web100:        map[string]string{},
+	}
+	_, body, err := ctl.receiveRaw(protocol.TestMsg)
+	if err != nil {
+		return nil, err
+	}
+	if err := parseS2CResults(ctl.encoding, body, result); err != nil {
+		return nil, err
+	}
+	err = ctl.send(protocol.TestMsg, strconv.FormatFloat(result.ClientKbps, 'f', -1, 64))
+	if err != nil {
+		return nil, err
+	}
+	// The server sends its variables, one per message, until TestFinalize.
+	for {
+		kind, msg, err := ctl.receive(protocol.TestMsg, protocol.TestFinalize)
+		if err != nil {
+			return nil, err
+		}
+		if kind == protocol.TestFinalize {
+			return result, nil
+		}
+		for _, line := range strings.Split(msg, "\n") {
+			if name, value, ok := strings.Cut(line, ":"); ok {
+				result.Web100[strings.TrimSpace(name)] = strings.TrimSpace(value)
+			}
+		}
+	}
+}
+
+// meta runs the META test.
+func (c *Client) meta(ctl *control) error {
+	if _, _, err := ctl.receive(protocol.TestPrepare); err != nil {
+		return err
+	}
+	if _, _, err := ctl.receive(protocol.TestStart); err != nil {
+		return err
+	}
+	for _, nv := range c.Metadata {
+		if err := ctl.send(protocol.TestMsg, nv.Name+":"+nv.Value); err != nil {
+			return err
+		}
+	}
+	// An empty message ends the test.
+	if err := ctl.send(protocol.TestMsg, ""); err != nil {
+		return err
+	}
+	_, _, err := ctl.receive(protocol.TestFinalize)
+	return err
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/m-lab/ndt-server/ndt5/protocol"
 	"github.com/m-lab/ndt-server/ndt5/singleserving"
 	"github.com/m-lab/ndt-server/ndt5/ws"
+	"github.com/m-lab/ndt-server/sink"
 )
 
 // WSHandler is both an ndt.Server and an http.Handler to allow websocket-based
@@ -34,11 +35,11 @@ func (hf *httpFactory) SingleServingServer(dir string) (ndt.SingleMeasurementSer
 type httpHandler struct {
 	serverFactory  ndt.SingleMeasurementServerFactory
 	connectionType ndt.ConnectionType
-	datadir        string
+	sink           sink.ResultSink
 	metadata       []metadata.NameValue
 }
 
-func (s *httpHandler) DataDir() string                    { return s.datadir }
+func (s *httpHandler) ResultSink() sink.ResultSink        { return s.sink }
 func (s *httpHandler) ConnectionType() ndt.ConnectionType { return s.connectionType }
 func (s *httpHandler) Metadata() []metadata.NameValue     { return s.metadata }
 
@@ -74,11 +75,11 @@ func (s *httpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 // NewWS returns a handler suitable for http-based connections.
-func NewWS(datadir string, metadata []metadata.NameValue) WSHandler {
+func NewWS(rs sink.ResultSink, metadata []metadata.NameValue) WSHandler {
 	return &httpHandler{
 		serverFactory:  &httpFactory{},
 		connectionType: ndt.WS,
-		datadir:        datadir,
+		sink:           rs,
 		metadata:       metadata,
 	}
 }
@@ -93,14 +94,14 @@ func (hf *httpsFactory) SingleServingServer(dir string) (ndt.SingleMeasurementSe
 }
 
 // NewWSS returns a handler suitable for https-based connections.
-func NewWSS(datadir, certFile, keyFile string, metadata []metadata.NameValue) WSHandler {
+func NewWSS(rs sink.ResultSink, certFile, keyFile string, metadata []metadata.NameValue) WSHandler {
 	return &httpHandler{
 		serverFactory: &httpsFactory{
 			certFile: certFile,
 			keyFile:  keyFile,
 		},
 		connectionType: ndt.WSS,
-		datadir:        datadir,
+		sink:           rs,
 		metadata:       metadata,
 	}
 }
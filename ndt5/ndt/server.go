@@ -5,6 +5,7 @@ import (
 
 	"github.com/m-lab/ndt-server/metadata"
 	"github.com/m-lab/ndt-server/ndt5/protocol"
+	"github.com/m-lab/ndt-server/sink"
 )
 
 // ConnectionType records whether this test is performed over plain TCP,
@@ -42,7 +43,7 @@ var (
 type Server interface {
 	SingleMeasurementServerFactory
 	ConnectionType() ConnectionType
-	DataDir() string
+	ResultSink() sink.ResultSink
 	Metadata() []metadata.NameValue
 	LoginCeremony(protocol.Connection) (int, error)
 }
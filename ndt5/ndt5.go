@@ -26,6 +26,7 @@ import (
 	"github.com/m-lab/ndt-server/ndt5/ndt"
 	"github.com/m-lab/ndt-server/ndt5/protocol"
 	"github.com/m-lab/ndt-server/ndt5/s2c"
+	"github.com/m-lab/ndt-server/sink"
 )
 
 const (
@@ -37,31 +38,48 @@ const (
 	cTestMETA   = 32
 )
 
-// SaveData archives the data to disk.
-func SaveData(record *data.NDT5Result, datadir string) {
-	if record == nil {
-		log.Println("nil record won't be saved")
-		return
-	}
+// saveData writes record to a file in datadir and returns the file name.
+func saveData(record *data.NDT5Result, datadir string) (string, error) {
 	dir := path.Join(datadir, record.StartTime.Format("2006/01/02"))
 	err := os.MkdirAll(dir, 0777)
 	if err != nil {
-		log.Printf("Could not create directory %s: %v\n", dir, err)
-		return
+		return "", err
 	}
 	file, err := protocol.UUIDToFile(dir, record.Control.UUID)
 	if err != nil {
-		log.Println("Could not open file:", err)
-		return
+		return "", err
 	}
-	defer file.Close()
 	enc := json.NewEncoder(file)
 	err = enc.Encode(record)
 	if err != nil {
-		log.Println("ERROR: Could not encode", record, "to", file.Name(), "err:", err)
-		return
+		file.Abort()
+		return "", err
+	}
+	return file.Name(), file.Close()
+}
+
+// FileSink saves ndt5 results to files in DataDir.
+type FileSink struct {
+	DataDir string
+}
+
+// Dir implements sink.FileBacked.
+func (fs *FileSink) Dir() string {
+	return fs.DataDir
+}
+
+// Save implements sink.ResultSink. The data of r must be a *data.NDT5Result.
+func (fs *FileSink) Save(r *sink.Result) error {
+	record, ok := r.Data.(*data.NDT5Result)
+	if !ok || record == nil {
+		return fmt.Errorf("ndt5: cannot save %T", r.Data)
+	}
+	name, err := saveData(record, fs.DataDir)
+	if err != nil {
+		return err
 	}
-	log.Println("Wrote", file.Name())
+	log.Println("Wrote", name)
+	return nil
 }
 
 func panicMsgToErrType(msg string) string {
@@ -140,9 +158,21 @@ func handleControlChannel(conn protocol.Connection, s ndt.Server, isMon string)
 		ClientIP:   cIP,
 		ClientPort: cPort,
 	}
+	// Refused tests have no result to save.
+	refused := false
 	defer func() {
+		if refused {
+			return
+		}
 		record.EndTime = time.Now()
-		SaveData(record, s.DataDir())
+		err := s.ResultSink().Save(&sink.Result{
+			Protocol: "ndt5",
+			UUID:     record.Control.UUID,
+			Data:     record,
+		})
+		if err != nil {
+			log.Println("ERROR: Could not save", record.Control.UUID, "err:", err)
+		}
 	}()
 
 	tests, err := s.LoginCeremony(conn)
@@ -192,6 +222,16 @@ func handleControlChannel(conn protocol.Connection, s ndt.Server, isMon string)
 
 	m := conn.Messager()
 	record.Control.MessageProtocol = m.Encoding().String()
+	if err := sink.Check(s.ResultSink()); err != nil {
+		// The results cannot be saved, so refuse to run the tests.
+		log.Println("Refusing tests, cannot save results:", err)
+		ndt5metrics.ClientTestErrors.WithLabelValues(connType, "control", "Spool").Inc()
+		refused = true
+		rtx.PanicOnError(
+			m.SendMessage(protocol.SrvQueue, []byte("9977")),
+			"SrvQueue - Could not send SrvQueue (uuid: %s)", record.Control.UUID)
+		return
+	}
 	rtx.PanicOnError(
 		m.SendMessage(protocol.SrvQueue, []byte("0")),
 		"SrvQueue - Could not send SrvQueue (uuid: %s)", record.Control.UUID)
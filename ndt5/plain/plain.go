@@ -19,6 +19,7 @@ import (
 	"github.com/m-lab/ndt-server/ndt5/protocol"
 	"github.com/m-lab/ndt-server/ndt5/singleserving"
 	"github.com/m-lab/ndt-server/netx"
+	"github.com/m-lab/ndt-server/sink"
 )
 
 // plainServer handles requests that are TCP-based but not HTTP(S) based. If it
@@ -28,7 +29,7 @@ type plainServer struct {
 	wsAddr   string
 	dialer   *net.Dialer
 	listener *netx.Listener
-	datadir  string
+	sink     sink.ResultSink
 	timeout  time.Duration
 	metadata []metadata.NameValue
 }
@@ -168,7 +169,7 @@ func (ps *plainServer) ListenAndServe(ctx context.Context, addr string, tx Accep
 }
 
 func (ps *plainServer) ConnectionType() ndt.ConnectionType { return ndt.Plain }
-func (ps *plainServer) DataDir() string                    { return ps.datadir }
+func (ps *plainServer) ResultSink() sink.ResultSink        { return ps.sink }
 func (ps *plainServer) Metadata() []metadata.NameValue     { return ps.metadata }
 func (ps *plainServer) LoginCeremony(conn protocol.Connection) (int, error) {
 	flex, ok := conn.(protocol.MeasuredFlexibleConnection)
@@ -219,7 +220,7 @@ type Server interface {
 // NewServer creates a new TCP listener to serve the client. It forwards all
 // connection requests that look like HTTP to a different address (assumed to be
 // on the same host).
-func NewServer(datadir, wsAddr string, metadata []metadata.NameValue) Server {
+func NewServer(rs sink.ResultSink, wsAddr string, metadata []metadata.NameValue) Server {
 	return &plainServer{
 		wsAddr: wsAddr,
 		// The dialer is only contacting localhost. The timeout should be set to a
@@ -228,7 +229,7 @@ func NewServer(datadir, wsAddr string, metadata []metadata.NameValue) Server {
 		dialer: &net.Dialer{
 			Timeout: 1 * time.Second,
 		},
-		datadir: datadir,
+		sink: rs,
 		// No client should wait around for more than 2 minutes.
 		timeout:  2 * time.Minute,
 		metadata: metadata,
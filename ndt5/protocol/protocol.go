@@ -7,17 +7,17 @@ import (
 	"flag"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"net"
-	"os"
 	"path"
+	"strconv"
 	"time"
 
 	"github.com/gorilla/websocket"
 
 	"github.com/m-lab/ndt-server/ndt5/web100"
 	"github.com/m-lab/ndt-server/netx"
+	"github.com/m-lab/ndt-server/spool"
 )
 
 var verbose = flag.Bool("ndt5.protocol.verbose", false, "Print the contents of every message to the log")
@@ -100,17 +100,14 @@ type Connection interface {
 
 var badUUID = "ERROR_DISCOVERING_UUID"
 
-// UUIDToFile converts a UUID into a newly-created open file with the extension '.json'.
-func UUIDToFile(dir, uuid string) (*os.File, error) {
+// UUIDToFile converts a UUID into a newly-created open file with the extension
+// '.json'. The file only appears in dir once it is closed.
+func UUIDToFile(dir, uuid string) (*spool.File, error) {
 	if uuid == badUUID {
-		f, err := ioutil.TempFile(dir, badUUID+"*.json")
-		if err != nil {
-			log.Println("Could not create filename for data")
-			return nil, err
-		}
-		return f, nil
+		// Make the name unique, since many connections may share the bad UUID.
+		uuid = badUUID + strconv.FormatInt(time.Now().UnixNano(), 10)
 	}
-	return os.Create(path.Join(dir, uuid+".json"))
+	return spool.Create(path.Join(dir, uuid+".json"))
 }
 
 // Measurable things can be measured over a given timeframe.
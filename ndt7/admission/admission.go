@@ -0,0 +1,211 @@
+// Package admission limits the number of ndt7 subtests running at the same
+// time, so that concurrent subtests do not silently split the bandwidth of a
+// busy server.
+//
+// Every direction, i.e., download and upload, has its own limit. When all the
+// slots of a direction are taken, new subtests wait in a short queue, in
+// arrival order, and are refused once the queue is full or they waited too
+// long.
+package admission
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/m-lab/ndt-server/ndt7/spec"
+)
+
+var (
+	// ErrQueueFull is returned when a subtest cannot wait, since the queue of
+	// its direction is full.
+	ErrQueueFull = errors.New("admission: queue is full")
+	// ErrTimeout is returned when a subtest waited in the queue for MaxWait.
+	ErrTimeout = errors.New("admission: timed out in the queue")
+	// ErrTooLarge is returned when a subtest needs more slots than the limit,
+	// e.g., a multi-stream subtest with more streams than the limit.
+	ErrTooLarge = errors.New("admission: more streams than the limit")
+)
+
+var (
+	// QueuedTests is the number of subtests waiting in the queue.
+	QueuedTests = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ndt7_admission_queued_tests",
+			Help: "The number of ndt7 subtests waiting for admission.",
+		},
+		[]string{"direction"},
+	)
+	// QueueWait is the time that admitted subtests waited in the queue.
+	QueueWait = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "ndt7_admission_queue_wait_seconds",
+			Help:    "A histogram of the time ndt7 subtests waited for admission.",
+			Buckets: []float64{0, .1, .25, .5, 1, 2.5, 5, 10, 30},
+		},
+		[]string{"direction"},
+	)
+)
+
+// Controller admits ndt7 subtests.
+type Controller struct {
+	// MaxDownloads and MaxUploads are the maximum numbers of downloads and
+	// uploads running at the same time. There is no limit when zero.
+	MaxDownloads int
+	MaxUploads   int
+	// QueueSize is the maximum number of subtests of every direction waiting
+	// for slots. A multi-stream subtest waits as a single subtest. Subtests are
+	// not queued when QueueSize or MaxWait is zero.
+	QueueSize int
+	// MaxWait is the maximum time a subtest waits in the queue.
+	MaxWait time.Duration
+	// RetryAfter is the delay after which refused clients should retry. When
+	// zero, it is spec.DefaultRuntime, i.e., the time a running subtest needs
+	// to free its slot.
+	RetryAfter time.Duration
+
+	mu     sync.Mutex
+	limits map[spec.SubtestKind]*limit
+}
+
+// limit tracks the subtests of a direction.
+type limit struct {
+	// running is the number of slots taken.
+	running int
+	// waiters are the queued subtests, in arrival order. Released slots are
+	// handed over to the first waiters.
+	waiters []*waiter
+}
+
+// waiter is a queued subtest.
+type waiter struct {
+	slots int
+	// ready is closed once the slots have been handed over.
+	ready chan struct{}
+}
+
+// grant hands the free slots over to the first waiters that fit.
+func (l *limit) grant(n int) {
+	for len(l.waiters) > 0 && l.running+l.waiters[0].slots <= n {
+		w := l.waiters[0]
+		l.waiters = l.waiters[1:]
+		l.running += w.slots
+		close(w.ready)
+	}
+}
+
+// max returns the limit of the given direction, or zero if there is none.
+func (c *Controller) max(direction spec.SubtestKind) int {
+	switch direction {
+	case spec.SubtestDownload:
+		return c.MaxDownloads
+	case spec.SubtestUpload:
+		return c.MaxUploads
+	}
+	return 0
+}
+
+// state returns the limit of the given direction. The caller must hold mu.
+func (c *Controller) state(direction spec.SubtestKind) *limit {
+	if c.limits == nil {
+		c.limits = map[spec.SubtestKind]*limit{}
+	}
+	l := c.limits[direction]
+	if l == nil {
+		l = &limit{}
+		c.limits[direction] = l
+	}
+	return l
+}
+
+// Admit waits until a subtest in the given direction may run with the given
+// number of slots, i.e., one per stream. On success, the caller must call
+// release once the subtest is done. Admit also returns how long the subtest
+// waited in the queue. It fails with ErrTooLarge, ErrQueueFull, ErrTimeout, or
+// the error of ctx if ctx is done while waiting.
+func (c *Controller) Admit(ctx context.Context, direction spec.SubtestKind, slots int) (release func(), waited time.Duration, err error) {
+	n := c.max(direction)
+	if n <= 0 {
+		return func() {}, 0, nil
+	}
+	if slots > n {
+		return nil, 0, ErrTooLarge
+	}
+	c.mu.Lock()
+	l := c.state(direction)
+	if l.running+slots <= n && len(l.waiters) == 0 {
+		l.running += slots
+		c.mu.Unlock()
+		return c.releaser(direction, slots), 0, nil
+	}
+	if len(l.waiters) >= c.QueueSize || c.MaxWait <= 0 {
+		c.mu.Unlock()
+		return nil, 0, ErrQueueFull
+	}
+	w := &waiter{slots: slots, ready: make(chan struct{})}
+	l.waiters = append(l.waiters, w)
+	QueuedTests.WithLabelValues(string(direction)).Inc()
+	c.mu.Unlock()
+	defer QueuedTests.WithLabelValues(string(direction)).Dec()
+
+	start := time.Now()
+	timer := time.NewTimer(c.MaxWait)
+	defer timer.Stop()
+	select {
+	case <-w.ready:
+		waited = time.Since(start)
+		QueueWait.WithLabelValues(string(direction)).Observe(waited.Seconds())
+		return c.releaser(direction, slots), waited, nil
+	case <-timer.C:
+		err = ErrTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	c.mu.Lock()
+	for i, q := range l.waiters {
+		if q == w {
+			l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+			// The subtests behind this one may fit now.
+			l.grant(n)
+			c.mu.Unlock()
+			return nil, time.Since(start), err
+		}
+	}
+	c.mu.Unlock()
+	// The slots were handed over while giving up, so pass them on.
+	c.release(direction, slots)
+	return nil, time.Since(start), err
+}
+
+// releaser returns a function releasing the slots of direction once.
+func (c *Controller) releaser(direction spec.SubtestKind, slots int) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() { c.release(direction, slots) })
+	}
+}
+
+// release frees the slots of direction and hands them over to the first
+// waiters, if any.
+func (c *Controller) release(direction spec.SubtestKind, slots int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l := c.state(direction)
+	l.running -= slots
+	l.grant(c.max(direction))
+}
+
+// RetryAfterSeconds returns the value of the Retry-After header sent to
+// refused clients.
+func (c *Controller) RetryAfterSeconds() int {
+	d := c.RetryAfter
+	if d <= 0 {
+		d = spec.DefaultRuntime
+	}
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,133 @@
+package admission
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/m-lab/ndt-server/ndt7/spec"
+)
+
+func TestController_Admit(t *testing.T) {
+	c := &Controller{MaxDownloads: 1, QueueSize: 1, MaxWait: time.Minute}
+	ctx := context.Background()
+
+	release, waited, err := c.Admit(ctx, spec.SubtestDownload, 1)
+	if err != nil || waited != 0 {
+		t.Fatalf("Admit() = %v, %v", waited, err)
+	}
+	// Uploads are not limited.
+	if _, _, err := c.Admit(ctx, spec.SubtestUpload, 1); err != nil {
+		t.Errorf("Admit(upload) error = %v", err)
+	}
+
+	type admitted struct {
+		release func()
+		waited  time.Duration
+		err     error
+	}
+	queued := make(chan admitted)
+	go func() {
+		r, w, err := c.Admit(ctx, spec.SubtestDownload, 1)
+		queued <- admitted{r, w, err}
+	}()
+	// Wait for the second download to be queued.
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		c.mu.Lock()
+		n := len(c.state(spec.SubtestDownload).waiters)
+		c.mu.Unlock()
+		if n == 1 {
+			break
+		}
+	}
+	if _, _, err := c.Admit(ctx, spec.SubtestDownload, 1); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Admit() error = %v, want %v", err, ErrQueueFull)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	release()
+	release() // Releasing twice must not free another slot.
+	a := <-queued
+	if a.err != nil || a.waited < 10*time.Millisecond {
+		t.Fatalf("queued Admit() = %v, %v", a.waited, a.err)
+	}
+	c.mu.Lock()
+	running := c.state(spec.SubtestDownload).running
+	c.mu.Unlock()
+	if running != 1 {
+		t.Errorf("running downloads = %d, want 1", running)
+	}
+	a.release()
+	if release, _, err := c.Admit(ctx, spec.SubtestDownload, 1); err != nil {
+		t.Errorf("Admit() error = %v", err)
+	} else {
+		release()
+	}
+}
+
+func TestController_AdmitTimeout(t *testing.T) {
+	c := &Controller{MaxUploads: 1, QueueSize: 1, MaxWait: 10 * time.Millisecond}
+	release, _, err := c.Admit(context.Background(), spec.SubtestUpload, 1)
+	if err != nil {
+		t.Fatalf("Admit() error = %v", err)
+	}
+	defer release()
+	if _, waited, err := c.Admit(context.Background(), spec.SubtestUpload, 1); !errors.Is(err, ErrTimeout) || waited < c.MaxWait {
+		t.Errorf("Admit() = %v, %v, want %v", waited, err, ErrTimeout)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.MaxWait = time.Minute
+	if _, _, err := c.Admit(ctx, spec.SubtestUpload, 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("Admit() error = %v, want %v", err, context.Canceled)
+	}
+	c.MaxWait = 0
+	if _, _, err := c.Admit(context.Background(), spec.SubtestUpload, 1); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Admit() error = %v, want %v", err, ErrQueueFull)
+	}
+}
+
+func TestController_AdmitSlots(t *testing.T) {
+	c := &Controller{MaxDownloads: 2, QueueSize: 1, MaxWait: time.Minute}
+	ctx := context.Background()
+	release, _, err := c.Admit(ctx, spec.SubtestDownload, 1)
+	if err != nil {
+		t.Fatalf("Admit() error = %v", err)
+	}
+	if _, _, err := c.Admit(ctx, spec.SubtestDownload, 3); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Admit() error = %v, want %v", err, ErrTooLarge)
+	}
+	// A subtest with two streams waits until both slots are free.
+	admitted := make(chan error)
+	go func() {
+		_, _, err := c.Admit(ctx, spec.SubtestDownload, 2)
+		admitted <- err
+	}()
+	select {
+	case err := <-admitted:
+		t.Fatalf("Admit() returned %v while a slot was taken", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	release()
+	if err := <-admitted; err != nil {
+		t.Fatalf("Admit() error = %v", err)
+	}
+	c.mu.Lock()
+	running := c.state(spec.SubtestDownload).running
+	c.mu.Unlock()
+	if running != 2 {
+		t.Errorf("running slots = %d, want 2", running)
+	}
+}
+
+func TestController_RetryAfterSeconds(t *testing.T) {
+	c := &Controller{}
+	if got := c.RetryAfterSeconds(); got != int(spec.DefaultRuntime.Seconds()) {
+		t.Errorf("RetryAfterSeconds() = %d", got)
+	}
+	c.RetryAfter = 1500 * time.Millisecond
+	if got := c.RetryAfterSeconds(); got != 2 {
+		t.Errorf("RetryAfterSeconds() = %d, want 2", got)
+	}
+}
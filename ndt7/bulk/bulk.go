@@ -0,0 +1,76 @@
+// Package bulk implements the binary messages that saturate the link during
+// downloads, whose size scales as documented in the appendix of the ndt7
+// specification.
+package bulk
+
+import (
+	"math/rand"
+
+	"github.com/m-lab/ndt-server/ndt7/spec"
+	"github.com/m-lab/ndt-server/ndt7/transport"
+)
+
+// initialMessageSize is the size of the first binary message.
+const initialMessageSize = 1 << 13
+
+// Sender writes binary messages of random data, and doubles their size as the
+// amount of data written grows.
+type Sender struct {
+	size    int
+	message *transport.PreparedMessage
+	sent    int64
+}
+
+func makePreparedMessage(size int) (*transport.PreparedMessage, error) {
+	data := make([]byte, size)
+	_, err := rand.Read(data)
+	if err != nil {
+		return nil, err
+	}
+	return transport.NewPreparedMessage(data)
+}
+
+// NewSender returns a Sender of messages of the initial size.
+func NewSender() (*Sender, error) {
+	message, err := makePreparedMessage(initialMessageSize)
+	if err != nil {
+		return nil, err
+	}
+	return &Sender{size: initialMessageSize, message: message}, nil
+}
+
+// Send writes the current message to conn.
+func (s *Sender) Send(conn transport.Conn) error {
+	if err := transport.WritePreparedMessage(conn, s.message); err != nil {
+		return err
+	}
+	s.sent += int64(s.size)
+	return nil
+}
+
+// Sent returns the number of bytes of the binary messages sent so far.
+func (s *Sender) Sent() int64 {
+	return s.sent
+}
+
+// Scale doubles the size of the message once it is small compared with the
+// data sent so far. We're not accounting for the size of JSON messages because
+// that is small compared to the bulk message size. The net effect is slightly
+// slowing down the scaling, but this is currently fine. We need to gather data
+// from large scale deployments of this algorithm anyway, so there's no point
+// in engaging in fine grained calibration before knowing.
+func (s *Sender) Scale() error {
+	if int64(s.size) >= spec.MaxScaledMessageSize {
+		return nil // No further scaling is required
+	}
+	if int64(s.size) > s.sent/spec.ScalingFraction {
+		return nil // message size still too big compared to sent data
+	}
+	message, err := makePreparedMessage(s.size * 2)
+	if err != nil {
+		return err
+	}
+	s.size *= 2
+	s.message = message
+	return nil
+}
@@ -0,0 +1,32 @@
+package bulk
+
+import (
+	"testing"
+
+	"github.com/m-lab/ndt-server/ndt7/spec"
+)
+
+func TestSender_Scale(t *testing.T) {
+	s, err := NewSender()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Scale(); err != nil || s.size != initialMessageSize {
+		t.Fatalf("Scale() before sending = %d, %v", s.size, err)
+	}
+	// Once enough data was sent, the size doubles.
+	s.sent = int64(initialMessageSize * spec.ScalingFraction)
+	if err := s.Scale(); err != nil || s.size != 2*initialMessageSize {
+		t.Fatalf("Scale() = %d, %v, want %d", s.size, err, 2*initialMessageSize)
+	}
+	// The size never exceeds spec.MaxScaledMessageSize.
+	for i := 0; i < 20; i++ {
+		s.sent = int64(s.size * spec.ScalingFraction)
+		if err := s.Scale(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s.size != spec.MaxScaledMessageSize {
+		t.Errorf("size = %d, want %d", s.size, spec.MaxScaledMessageSize)
+	}
+}
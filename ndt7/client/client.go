@@ -0,0 +1,207 @@
+// Package client implements an ndt7 client.
+//
+// The client runs the download and upload subtests as described in the ndt7
+// specification: it scales the size of the binary messages it sends, parses
+// the measurement messages sent by the server, sends its own application-level
+// measurements, and returns all the measurements as a stream.
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/m-lab/ndt-server/ndt7/model"
+	"github.com/m-lab/ndt-server/ndt7/spec"
+)
+
+// Origins of a measurement.
+const (
+	// OriginServer means that the measurement was sent by the server.
+	OriginServer = "server"
+	// OriginClient means that the measurement was collected by the client.
+	OriginClient = "client"
+)
+
+// Measurement is a measurement of a subtest, collected by the server or by
+// the client.
+type Measurement struct {
+	// Origin is who collected the measurement, i.e., OriginServer or
+	// OriginClient.
+	Origin string
+	// Test is the subtest of the measurement.
+	Test spec.SubtestKind
+	model.Measurement
+}
+
+// MeasurementInterval is the interval between the measurements collected by
+// the client.
+const MeasurementInterval = spec.AveragePoissonSamplingInterval
+
+// Client runs ndt7 subtests against a server. Configure the optional fields
+// before starting the first subtest.
+type Client struct {
+	// Dialer is the WebSocket dialer. Use Dialer.TLSClientConfig to configure
+	// TLS for "wss" URLs.
+	Dialer websocket.Dialer
+	// UserAgent is the User-Agent header of the requests. It is not sent when
+	// empty.
+	UserAgent string
+	// AccessToken is the access token of the requests. It is not sent when
+	// empty.
+	AccessToken string
+	// EarlyExit is the number of MB after which the server ends the subtests.
+	// It must be accepted by the early exit policy of the server. Zero means
+	// that the subtests run for their full runtime.
+	EarlyExit int64
+	// Duration is the requested runtime of the subtests, which must be within
+	// the bounds of the server. Zero means the server's default runtime.
+	Duration time.Duration
+	// Metadata is sent to the server as query parameters and archived as
+	// client metadata, e.g., "client_name".
+	Metadata map[string]string
+	// Params are other query parameters, e.g., spec.CongestionControlParameterName.
+	Params url.Values
+
+	server *url.URL
+}
+
+// New creates a client for the server at serverURL, e.g.,
+// "wss://ndt.example.com". The "http" and "https" schemes are accepted too and
+// mean "ws" and "wss", respectively. The path of serverURL is ignored.
+func New(serverURL string) (*Client, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "ws", "wss":
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	default:
+		return nil, fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+	return &Client{
+		Dialer: websocket.Dialer{
+			HandshakeTimeout: 10 * time.Second,
+			ReadBufferSize:   spec.DefaultWebsocketBufferSize,
+			WriteBufferSize:  spec.DefaultWebsocketBufferSize,
+		},
+		server: u,
+	}, nil
+}
+
+// URL returns the URL of the subtest with the given path, including the query
+// parameters configured in c.
+func (c *Client) URL(path string) *url.URL {
+	u := *c.server
+	u.Path = path
+	q := url.Values{}
+	for name, values := range c.Params {
+		q[name] = append([]string(nil), values...)
+	}
+	for name, value := range c.Metadata {
+		q.Set(name, value)
+	}
+	if c.AccessToken != "" {
+		q.Set("access_token", c.AccessToken)
+	}
+	if c.EarlyExit > 0 {
+		q.Set(spec.EarlyExitParameterName, strconv.FormatInt(c.EarlyExit, 10))
+	}
+	if c.Duration > 0 {
+		q.Set(spec.DurationParameterName, strconv.FormatInt(int64(c.Duration/time.Second), 10))
+	}
+	u.RawQuery = q.Encode()
+	return &u
+}
+
+// maxRuntime returns the time after which the client gives up waiting for the
+// server to end a subtest.
+func (c *Client) maxRuntime() time.Duration {
+	if c.Duration > 0 {
+		return spec.MaxRuntimeFor(c.Duration)
+	}
+	return spec.MaxRuntime
+}
+
+// HandshakeError is returned when the server refuses to start a subtest.
+type HandshakeError struct {
+	// StatusCode and Header are the status code and the headers of the
+	// response of the server.
+	StatusCode int
+	Header     http.Header
+}
+
+func (e *HandshakeError) Error() string {
+	return fmt.Sprintf("websocket handshake failed with status %d", e.StatusCode)
+}
+
+// Download starts a download subtest.
+func (c *Client) Download(ctx context.Context) (*Subtest, error) {
+	return c.start(ctx, spec.SubtestDownload, spec.DownloadURLPath, download)
+}
+
+// Upload starts an upload subtest.
+func (c *Client) Upload(ctx context.Context) (*Subtest, error) {
+	return c.start(ctx, spec.SubtestUpload, spec.UploadURLPath, upload)
+}
+
+// runFunc runs a subtest over conn and sends its measurements to st.
+type runFunc func(ctx context.Context, conn *websocket.Conn, st *Subtest) error
+
+func (c *Client) start(ctx context.Context, kind spec.SubtestKind, path string, run runFunc) (*Subtest, error) {
+	headers := http.Header{}
+	headers.Add("Sec-WebSocket-Protocol", spec.SecWebSocketProtocol)
+	if c.UserAgent != "" {
+		headers.Add("User-Agent", c.UserAgent)
+	}
+	conn, resp, err := c.Dialer.DialContext(ctx, c.URL(path).String(), headers)
+	if err != nil {
+		if errors.Is(err, websocket.ErrBadHandshake) && resp != nil {
+			return nil, &HandshakeError{StatusCode: resp.StatusCode, Header: resp.Header}
+		}
+		return nil, err
+	}
+	if conn.Subprotocol() != spec.SecWebSocketProtocol {
+		conn.Close()
+		return nil, fmt.Errorf("server selected the wrong subprotocol %q", conn.Subprotocol())
+	}
+	measurements := make(chan Measurement, 64)
+	st := &Subtest{Kind: kind, Measurements: measurements, out: measurements}
+	go func() {
+		defer close(measurements)
+		defer conn.Close()
+		// Liveness: the server must end the subtest within its maximum runtime.
+		deadline := time.Now().Add(c.maxRuntime())
+		conn.SetReadDeadline(deadline)
+		conn.SetWriteDeadline(deadline)
+		conn.SetReadLimit(spec.MaxMessageSize)
+		// Interrupt blocking reads and writes when ctx is done.
+		stop := context.AfterFunc(ctx, func() { conn.Close() })
+		defer stop()
+		if err := run(ctx, conn, st); err != nil && ctx.Err() != nil {
+			// The error is a consequence of closing the connection.
+			st.err = ctx.Err()
+		} else {
+			st.err = err
+		}
+	}()
+	return st, nil
+}
+
+// closeError converts the error ending a subtest, which is nil when the server
+// closed the connection normally.
+func closeError(err error) error {
+	if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		return nil
+	}
+	return err
+}
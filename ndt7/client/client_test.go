@@ -0,0 +1,146 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/m-lab/go/testingx"
+	"github.com/m-lab/ndt-server/ndt7/client"
+	"github.com/m-lab/ndt-server/ndt7/model"
+	"github.com/m-lab/ndt-server/ndt7/ndt7test"
+	"github.com/m-lab/ndt-server/ndt7/spec"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		server  string
+		want    string
+		wantErr bool
+	}{
+		{server: "ws://localhost:8080", want: "ws://localhost:8080/ndt/v7/download"},
+		{server: "https://ndt.example.com/ignored", want: "wss://ndt.example.com/ndt/v7/download"},
+		{server: "ftp://ndt.example.com", wantErr: true},
+		{server: "%", wantErr: true},
+	}
+	for _, tt := range tests {
+		c, err := client.New(tt.server)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("New(%q) error = %v, wantErr %v", tt.server, err, tt.wantErr)
+			continue
+		}
+		if err == nil && c.URL(spec.DownloadURLPath).String() != tt.want {
+			t.Errorf("New(%q) URL = %s, want %s", tt.server, c.URL(spec.DownloadURLPath), tt.want)
+		}
+	}
+}
+
+func TestClient_URL(t *testing.T) {
+	c, err := client.New("ws://localhost")
+	testingx.Must(t, err, "failed to create client")
+	c.AccessToken = "token"
+	c.EarlyExit = 250
+	c.Duration = 5 * time.Second
+	c.Metadata = map[string]string{"client_name": "test"}
+	c.Params = url.Values{"cc": {"cubic"}}
+	got := c.URL(spec.UploadURLPath).Query()
+	want := url.Values{
+		"access_token": {"token"},
+		"early_exit":   {"250"},
+		"duration":     {"5"},
+		"client_name":  {"test"},
+		"cc":           {"cubic"},
+	}
+	if got.Encode() != want.Encode() {
+		t.Errorf("wrong query; got %s, want %s", got.Encode(), want.Encode())
+	}
+}
+
+func TestClient_Download(t *testing.T) {
+	ndt7h, srv := ndt7test.NewNDT7Server(t)
+	defer srv.Close()
+	ndt7h.MinRuntime, ndt7h.MaxRuntime = time.Second, spec.DefaultRuntime
+
+	c, err := client.New(srv.URL)
+	testingx.Must(t, err, "failed to create client")
+	c.Duration = time.Second
+	c.Metadata = map[string]string{"client_name": "client_test"}
+	st, err := c.Download(context.Background())
+	testingx.Must(t, err, "failed to start download")
+	result, err := st.Wait()
+	testingx.Must(t, err, "download failed")
+	if result.UUID == "" || len(result.ServerMeasurements) == 0 || len(result.ClientMeasurements) == 0 {
+		t.Fatalf("missing measurements; got %+v", result)
+	}
+	if result.Summary == nil || result.Summary.UUID != result.UUID || result.Summary.MeanThroughputMbps <= 0 {
+		t.Errorf("wrong summary; got %+v", result.Summary)
+	}
+	last := result.ClientMeasurements[len(result.ClientMeasurements)-1]
+	if last.AppInfo == nil || last.AppInfo.NumBytes <= 0 {
+		t.Errorf("wrong client measurement; got %+v", last)
+	}
+}
+
+func TestClient_Upload(t *testing.T) {
+	ndt7h, srv := ndt7test.NewNDT7Server(t)
+	defer srv.Close()
+	ndt7h.EarlyExit = &model.EarlyExitPolicy{MB: []int64{1}}
+
+	c, err := client.New(srv.URL)
+	testingx.Must(t, err, "failed to create client")
+	c.EarlyExit = 1
+	start := time.Now()
+	st, err := c.Upload(context.Background())
+	testingx.Must(t, err, "failed to start upload")
+	var server, client int
+	for m := range st.Measurements {
+		if m.Test != spec.SubtestUpload {
+			t.Errorf("wrong subtest; got %s", m.Test)
+		}
+		if m.Origin == "server" {
+			server++
+		} else {
+			client++
+		}
+	}
+	testingx.Must(t, st.Err(), "upload failed")
+	if server == 0 || client == 0 {
+		t.Errorf("missing measurements; got %d server, %d client", server, client)
+	}
+	if elapsed := time.Since(start); elapsed >= spec.DefaultRuntime {
+		t.Errorf("upload did not end early; took %v", elapsed)
+	}
+}
+
+func TestClient_Refused(t *testing.T) {
+	_, srv := ndt7test.NewNDT7Server(t)
+	defer srv.Close()
+
+	c, err := client.New(srv.URL)
+	testingx.Must(t, err, "failed to create client")
+	c.EarlyExit = 3 // Not allowed by the default policy.
+	_, err = c.Download(context.Background())
+	var herr *client.HandshakeError
+	if !errors.As(err, &herr) || herr.StatusCode != http.StatusBadRequest {
+		t.Errorf("wrong error; got %v", err)
+	}
+}
+
+func TestClient_Cancel(t *testing.T) {
+	_, srv := ndt7test.NewNDT7Server(t)
+	defer srv.Close()
+
+	c, err := client.New(srv.URL)
+	testingx.Must(t, err, "failed to create client")
+	ctx, cancel := context.WithCancel(context.Background())
+	st, err := c.Download(ctx)
+	testingx.Must(t, err, "failed to start download")
+	<-st.Measurements
+	cancel()
+	if _, err := st.Wait(); !errors.Is(err, context.Canceled) {
+		t.Errorf("wrong error; got %v", err)
+	}
+}
@@ -0,0 +1,53 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/m-lab/ndt-server/ndt7/model"
+)
+
+// download receives binary messages and measurement messages until the server
+// closes the connection. Every MeasurementInterval, it sends the number of
+// bytes received so far to the server.
+func download(ctx context.Context, conn *websocket.Conn, st *Subtest) error {
+	start := time.Now()
+	next := start.Add(MeasurementInterval)
+	var total int64
+	for {
+		mtype, r, err := conn.NextReader()
+		if err != nil {
+			st.emit(ctx, OriginClient, appInfo(start, total))
+			return closeError(err)
+		}
+		if mtype == websocket.BinaryMessage {
+			n, err := io.Copy(io.Discard, r)
+			total += n
+			if err != nil {
+				return err
+			}
+		} else {
+			var m model.Measurement
+			if err := json.NewDecoder(r).Decode(&m); err != nil {
+				return err
+			}
+			if !st.emit(ctx, OriginServer, m) {
+				return ctx.Err()
+			}
+		}
+		if time.Now().Before(next) {
+			continue
+		}
+		next = time.Now().Add(MeasurementInterval)
+		m := appInfo(start, total)
+		if err := conn.WriteJSON(m); err != nil {
+			return err
+		}
+		if !st.emit(ctx, OriginClient, m) {
+			return ctx.Err()
+		}
+	}
+}
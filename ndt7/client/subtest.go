@@ -0,0 +1,84 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/m-lab/ndt-server/ndt7/model"
+	"github.com/m-lab/ndt-server/ndt7/spec"
+)
+
+// Subtest is a running subtest.
+type Subtest struct {
+	// Kind is the kind of the subtest.
+	Kind spec.SubtestKind
+	// Measurements receives the measurements of the subtest as they are
+	// collected. It is closed when the subtest ends. Callers must receive
+	// from Measurements until it is closed, or use Wait.
+	Measurements <-chan Measurement
+
+	out chan<- Measurement
+	err error
+}
+
+// Err returns the error that ended the subtest, or nil if the server ended the
+// subtest normally. It must only be called after Measurements is closed.
+func (st *Subtest) Err() error {
+	return st.err
+}
+
+// Result contains all the measurements of a subtest.
+type Result struct {
+	// UUID is the UUID of the subtest, as sent by the server.
+	UUID string
+	// ServerMeasurements and ClientMeasurements are the measurements sent by
+	// the server and collected by the client, respectively.
+	ServerMeasurements []model.Measurement
+	ClientMeasurements []model.Measurement
+	// Summary is the final result of the subtest, as computed by the server.
+	// It is nil when the server did not send it, e.g., because the subtest
+	// failed.
+	Summary *model.Summary
+}
+
+// Wait receives the measurements of the subtest until it ends, and returns
+// them with the error that ended the subtest. The result is never nil.
+func (st *Subtest) Wait() (*Result, error) {
+	r := &Result{}
+	for m := range st.Measurements {
+		if m.Origin == OriginClient {
+			r.ClientMeasurements = append(r.ClientMeasurements, m.Measurement)
+			continue
+		}
+		r.ServerMeasurements = append(r.ServerMeasurements, m.Measurement)
+		if m.ConnectionInfo != nil && m.ConnectionInfo.UUID != "" {
+			r.UUID = m.ConnectionInfo.UUID
+		}
+		if m.Summary != nil {
+			r.Summary = m.Summary
+		}
+	}
+	return r, st.Err()
+}
+
+// emit sends m to the receiver of Measurements. It returns false when ctx is
+// done before the receiver is ready.
+func (st *Subtest) emit(ctx context.Context, origin string, m model.Measurement) bool {
+	select {
+	case st.out <- Measurement{Origin: origin, Test: st.Kind, Measurement: m}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// appInfo returns a client measurement of the application-level bytes
+// transferred since start.
+func appInfo(start time.Time, total int64) model.Measurement {
+	return model.Measurement{
+		AppInfo: &model.AppInfo{
+			NumBytes:    total,
+			ElapsedTime: int64(time.Since(start) / time.Microsecond),
+		},
+	}
+}
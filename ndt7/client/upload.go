@@ -0,0 +1,110 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/m-lab/ndt-server/ndt7/model"
+	"github.com/m-lab/ndt-server/ndt7/spec"
+)
+
+// initialMessageSize is the size of the first binary message of an upload.
+const initialMessageSize = 1 << 13
+
+// makeMessage returns a binary message of the given size with random content.
+func makeMessage(size int) (*websocket.PreparedMessage, error) {
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		return nil, err
+	}
+	return websocket.NewPreparedMessage(websocket.BinaryMessage, data)
+}
+
+// upload sends binary messages until the server closes the connection, while
+// a separate goroutine receives the measurement messages of the server.
+func upload(ctx context.Context, conn *websocket.Conn, st *Subtest) error {
+	var rerr error
+	done := make(chan struct{})
+	go func() {
+		rerr = receive(ctx, conn, st)
+		close(done)
+	}()
+	start := time.Now()
+	total, err := send(ctx, conn, st, start, done)
+	if err != nil {
+		conn.Close() // Stop the receiver.
+	}
+	<-done
+	st.emit(ctx, OriginClient, appInfo(start, total))
+	if err != nil {
+		return err
+	}
+	return rerr
+}
+
+// send sends binary messages until done is closed or writing fails, which
+// means that the server closed the connection. Every MeasurementInterval, it
+// sends the number of bytes sent so far to the server. It returns the number
+// of bytes sent.
+func send(ctx context.Context, conn *websocket.Conn, st *Subtest, start time.Time, done <-chan struct{}) (int64, error) {
+	size := initialMessageSize
+	message, err := makeMessage(size)
+	if err != nil {
+		return 0, err
+	}
+	next := start.Add(MeasurementInterval)
+	var total int64
+	for {
+		select {
+		case <-done:
+			return total, nil
+		default:
+		}
+		if conn.WritePreparedMessage(message) != nil {
+			return total, nil // The receiver reports why.
+		}
+		total += int64(size)
+		// Scale the message size as recommended in the appendix of the spec.
+		if int64(size) < spec.MaxScaledMessageSize && int64(size) <= total/spec.ScalingFraction {
+			size *= 2
+			if message, err = makeMessage(size); err != nil {
+				return total, err
+			}
+		}
+		if time.Now().Before(next) {
+			continue
+		}
+		next = time.Now().Add(MeasurementInterval)
+		m := appInfo(start, total)
+		if conn.WriteJSON(m) != nil {
+			return total, nil
+		}
+		if !st.emit(ctx, OriginClient, m) {
+			return total, ctx.Err()
+		}
+	}
+}
+
+// receive receives the measurement messages of the server until the server
+// closes the connection.
+func receive(ctx context.Context, conn *websocket.Conn, st *Subtest) error {
+	for {
+		mtype, r, err := conn.NextReader()
+		if err != nil {
+			return closeError(err)
+		}
+		if mtype != websocket.TextMessage {
+			continue // Unexpected, but harmless.
+		}
+		var m model.Measurement
+		if err := json.NewDecoder(r).Decode(&m); err != nil {
+			return err
+		}
+		if !st.emit(ctx, OriginServer, m) {
+			return ctx.Err()
+		}
+	}
+}
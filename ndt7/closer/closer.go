@@ -6,10 +6,11 @@ import (
 
 	"github.com/gorilla/websocket"
 	"github.com/m-lab/ndt-server/logging"
+	"github.com/m-lab/ndt-server/ndt7/transport"
 )
 
 // StartClosing will start closing the websocket connection.
-func StartClosing(conn *websocket.Conn) {
+func StartClosing(conn transport.Conn) {
 	msg := websocket.FormatCloseMessage(
 		websocket.CloseNormalClosure, "Done sending")
 	d := time.Now().Add(time.Second) // Liveness!
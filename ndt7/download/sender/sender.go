@@ -3,62 +3,49 @@ package sender
 
 import (
 	"context"
-	"math/rand"
 	"time"
 
-	"github.com/gorilla/websocket"
 	"github.com/m-lab/ndt-server/logging"
+	"github.com/m-lab/ndt-server/ndt7/bulk"
 	"github.com/m-lab/ndt-server/ndt7/closer"
 	"github.com/m-lab/ndt-server/ndt7/measurer"
 	ndt7metrics "github.com/m-lab/ndt-server/ndt7/metrics"
 	"github.com/m-lab/ndt-server/ndt7/model"
 	"github.com/m-lab/ndt-server/ndt7/ping"
 	"github.com/m-lab/ndt-server/ndt7/spec"
+	"github.com/m-lab/ndt-server/ndt7/stability"
+	"github.com/m-lab/ndt-server/ndt7/summary"
+	"github.com/m-lab/ndt-server/ndt7/transport"
 )
 
-// Params defines the parameters for the sender to end the test early.
-type Params struct {
-	IsEarlyExit bool
-	MaxBytes    int64
-}
-
-func makePreparedMessage(size int) (*websocket.PreparedMessage, error) {
-	data := make([]byte, size)
-	_, err := rand.Read(data)
-	if err != nil {
-		return nil, err
-	}
-	return websocket.NewPreparedMessage(websocket.BinaryMessage, data)
-}
-
 // Start sends binary messages (bulk download) and measurement messages (status
 // messages) to the client conn. Each measurement message will also be saved to
-// data.
+// data. After each measurement message, the sender sends a ping using pinger
+// and includes the latest RTT sample in the next measurement message.
 //
 // Liveness guarantee: the sender will not be stuck sending for more than the
 // MaxRuntime of the subtest. This is enforced by setting the write deadline to
 // Time.Now() + MaxRuntime.
-func Start(ctx context.Context, conn *websocket.Conn, data *model.ArchivalData, params *Params) error {
+func Start(ctx context.Context, conn transport.Conn, data *model.ArchivalData, params *spec.Params, pinger *ping.Pinger) error {
 	logging.Logger.Debug("sender: start")
 	proto := ndt7metrics.ConnLabel(conn)
 
 	// Start collecting connection measurements. Measurements will be sent to
-	// src until DefaultRuntime, when the src channel is closed.
+	// src until params.Runtime, when the src channel is closed.
 	mr := measurer.New(conn, data.UUID)
-	src := mr.Start(ctx, spec.DefaultRuntime)
+	src := mr.Start(ctx, params.Runtime)
 	defer logging.Logger.Debug("sender: stop")
 	defer mr.Stop(src)
 
 	logging.Logger.Debug("sender: generating random buffer")
-	bulkMessageSize := 1 << 13
-	preparedMessage, err := makePreparedMessage(bulkMessageSize)
+	messages, err := bulk.NewSender()
 	if err != nil {
-		logging.Logger.WithError(err).Warn("sender: makePreparedMessage failed")
+		logging.Logger.WithError(err).Warn("sender: bulk.NewSender failed")
 		ndt7metrics.ClientSenderErrors.WithLabelValues(
 			proto, string(spec.SubtestDownload), "make-prepared-message").Inc()
 		return err
 	}
-	deadline := time.Now().Add(spec.MaxRuntime)
+	deadline := time.Now().Add(spec.MaxRuntimeFor(params.Runtime))
 	err = conn.SetWriteDeadline(deadline) // Liveness!
 	if err != nil {
 		logging.Logger.WithError(err).Warn("sender: conn.SetWriteDeadline failed")
@@ -68,20 +55,43 @@ func Start(ctx context.Context, conn *websocket.Conn, data *model.ArchivalData,
 	}
 
 	// Record measurement start time, and prepare recording of the endtime on return.
-	data.StartTime = time.Now().UTC()
+	start := time.Now()
+	data.StartTime = start.UTC()
 	defer func() {
 		data.EndTime = time.Now().UTC()
+		data.ActualDuration = time.Since(start)
 	}()
-	var totalSent int64
+	// finish records why the subtest ended, sends the summary of the subtest
+	// to the client and starts closing the connection.
+	finish := func(reason, label string) error {
+		data.TerminationReason = reason
+		if err := summary.Send(conn, spec.SubtestDownload, data); err != nil {
+			logging.Logger.WithError(err).Warn("sender: summary.Send failed")
+			ndt7metrics.ClientSenderErrors.WithLabelValues(
+				proto, string(spec.SubtestDownload), "write-summary").Inc()
+			return err
+		}
+		closer.StartClosing(conn)
+		ndt7metrics.ClientSenderErrors.WithLabelValues(
+			proto, string(spec.SubtestDownload), label).Inc()
+		return nil
+	}
+	var detector *stability.Detector
+	if params.Stability != nil {
+		detector = stability.New(*params.Stability)
+	}
 	for {
 		select {
 		case m, ok := <-src:
 			if !ok { // This means that the measurer has terminated.
-				closer.StartClosing(conn)
-				ndt7metrics.ClientSenderErrors.WithLabelValues(
-					proto, string(spec.SubtestDownload), "measurer-closed").Inc()
-				return nil
+				return finish(model.TerminationRuntime, "measurer-closed")
 			}
+			// Report the application-level bytes written so far.
+			m.AppInfo = &model.AppInfo{
+				NumBytes:    messages.Sent(),
+				ElapsedTime: int64(time.Since(start) / time.Microsecond),
+			}
+			m.WSPingInfo = pinger.Latest()
 			if err := conn.WriteJSON(m); err != nil {
 				logging.Logger.WithError(err).Warn("sender: conn.WriteJSON failed")
 				ndt7metrics.ClientSenderErrors.WithLabelValues(
@@ -90,46 +100,36 @@ func Start(ctx context.Context, conn *websocket.Conn, data *model.ArchivalData,
 			}
 			// Only save measurements sent to the client.
 			data.ServerMeasurements = append(data.ServerMeasurements, m)
-			if err := ping.SendTicks(conn, deadline); err != nil {
+			if params.Recorder != nil {
+				params.Recorder.RecordServerMeasurement(m)
+			}
+			if err := pinger.SendTicks(conn, deadline); err != nil {
 				logging.Logger.WithError(err).Warn("sender: ping.SendTicks failed")
 				ndt7metrics.ClientSenderErrors.WithLabelValues(
 					proto, string(spec.SubtestDownload), "ping-send-ticks").Inc()
 				return err
 			}
 			// End the test once enough bytes have been acked.
-			if params.IsEarlyExit && m.TCPInfo != nil &&
-				m.TCPInfo.BytesAcked >= params.MaxBytes {
-				closer.StartClosing(conn)
-				ndt7metrics.ClientSenderErrors.WithLabelValues(
-					proto, string(spec.SubtestDownload), "measurer-closed-early").Inc()
-				return nil
+			if m.TCPInfo != nil &&
+				params.ShouldExitEarly(m.TCPInfo.BytesAcked, time.Since(start)) {
+				return finish(model.TerminationEarlyExit, "measurer-closed-early")
+			}
+			// End the test once the rate has converged.
+			if detector != nil && m.TCPInfo != nil &&
+				detector.Update(m.TCPInfo.BytesAcked, time.Since(start)) {
+				return finish(model.TerminationStableRate, "measurer-closed-stable")
 			}
 		default:
-			if err := conn.WritePreparedMessage(preparedMessage); err != nil {
+			if err := messages.Send(conn); err != nil {
 				logging.Logger.WithError(err).Warn(
-					"sender: conn.WritePreparedMessage failed")
+					"sender: messages.Send failed")
 				ndt7metrics.ClientSenderErrors.WithLabelValues(
 					proto, string(spec.SubtestDownload), "write-prepared-message").Inc()
 				return err
 			}
-			// The following block of code implements the scaling of message size
-			// as recommended in the spec's appendix. We're not accounting for the
-			// size of JSON messages because that is small compared to the bulk
-			// message size. The net effect is slightly slowing down the scaling,
-			// but this is currently fine. We need to gather data from large
-			// scale deployments of this algorithm anyway, so there's no point
-			// in engaging in fine grained calibration before knowing.
-			totalSent += int64(bulkMessageSize)
-			if int64(bulkMessageSize) >= spec.MaxScaledMessageSize {
-				continue // No further scaling is required
-			}
-			if int64(bulkMessageSize) > totalSent/spec.ScalingFraction {
-				continue // message size still too big compared to sent data
-			}
-			bulkMessageSize *= 2
-			preparedMessage, err = makePreparedMessage(bulkMessageSize)
-			if err != nil {
-				logging.Logger.WithError(err).Warn("sender: makePreparedMessage failed")
+			// Scale the message size as recommended in the spec's appendix.
+			if err := messages.Scale(); err != nil {
+				logging.Logger.WithError(err).Warn("sender: messages.Scale failed")
 				ndt7metrics.ClientSenderErrors.WithLabelValues(
 					proto, string(spec.SubtestDownload), "make-prepared-message").Inc()
 				return err
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/m-lab/ndt-server/logging"
+	"github.com/m-lab/ndt-server/ndt7/model"
+	"github.com/m-lab/ndt-server/ndt7/spec"
+)
+
+// ParseEarlyExitPolicy creates an early exit policy from the accepted byte
+// thresholds, in MB, and the accepted time-based thresholds, formatted as
+// "<seconds>:<MB>", e.g., "5:10" ends the subtest after 5 seconds once 10 MB
+// have been transferred. Without byte thresholds, the policy accepts
+// spec.DefaultEarlyExitValues, so that existing clients keep working.
+func ParseEarlyExitPolicy(mb []string, times []string) (*model.EarlyExitPolicy, error) {
+	policy := &model.EarlyExitPolicy{}
+	if len(mb) == 0 {
+		policy.MB = append(policy.MB, spec.DefaultEarlyExitValues...)
+	}
+	for _, value := range mb {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid early exit MB threshold %q", value)
+		}
+		policy.MB = append(policy.MB, n)
+	}
+	for _, value := range times {
+		fields := strings.Split(value, ":")
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid early exit time threshold %q", value)
+		}
+		seconds, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil || seconds <= 0 {
+			return nil, fmt.Errorf("invalid early exit time threshold %q", value)
+		}
+		mb, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil || mb < 0 {
+			return nil, fmt.Errorf("invalid early exit time threshold %q", value)
+		}
+		policy.Time = append(policy.Time, model.EarlyExitTime{Seconds: seconds, MinMB: mb})
+	}
+	return policy, nil
+}
+
+// earlyExitPolicy returns the early exit policy of the server. Unless
+// configured, only spec.DefaultEarlyExitValues are allowed.
+func (h *Handler) earlyExitPolicy() *model.EarlyExitPolicy {
+	if h.EarlyExit == nil {
+		return &model.EarlyExitPolicy{MB: spec.DefaultEarlyExitValues}
+	}
+	return h.EarlyExit
+}
+
+// EarlyExitPolicy advertises the early exit policy of the server to clients.
+func (h *Handler) EarlyExitPolicy(rw http.ResponseWriter, req *http.Request) {
+	b, err := json.Marshal(h.earlyExitPolicy())
+	if err != nil {
+		logging.Logger.WithError(err).Warn("EarlyExitPolicy: json.Marshal failed")
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Write(b)
+}
@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/m-lab/ndt-server/ndt7/model"
+	"github.com/m-lab/ndt-server/ndt7/spec"
+)
+
+func TestParseEarlyExitPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		mb      []string
+		times   []string
+		want    *model.EarlyExitPolicy
+		wantErr bool
+	}{
+		{
+			name:  "success",
+			mb:    []string{"250", "100"},
+			times: []string{"5:10", "8:0"},
+			want: &model.EarlyExitPolicy{
+				MB:   []int64{250, 100},
+				Time: []model.EarlyExitTime{{Seconds: 5, MinMB: 10}, {Seconds: 8, MinMB: 0}},
+			},
+		},
+		{
+			name:  "default-mb",
+			times: []string{"5:10"},
+			want: &model.EarlyExitPolicy{
+				MB:   spec.DefaultEarlyExitValues,
+				Time: []model.EarlyExitTime{{Seconds: 5, MinMB: 10}},
+			},
+		},
+		{
+			name:    "invalid-mb",
+			mb:      []string{"0"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid-time-format",
+			times:   []string{"5"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid-time-seconds",
+			times:   []string{"x:10"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid-time-mb",
+			times:   []string{"5:-1"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseEarlyExitPolicy(tt.mb, tt.times)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseEarlyExitPolicy() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseEarlyExitPolicy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandler_EarlyExitPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy *model.EarlyExitPolicy
+		want   *model.EarlyExitPolicy
+	}{
+		{
+			name: "default",
+			want: &model.EarlyExitPolicy{MB: spec.DefaultEarlyExitValues},
+		},
+		{
+			name:   "configured",
+			policy: &model.EarlyExitPolicy{MB: []int64{10}, Time: []model.EarlyExitTime{{Seconds: 3, MinMB: 1}}},
+			want:   &model.EarlyExitPolicy{MB: []int64{10}, Time: []model.EarlyExitTime{{Seconds: 3, MinMB: 1}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &Handler{EarlyExit: tt.policy}
+			rec := httptest.NewRecorder()
+			h.EarlyExitPolicy(rec, httptest.NewRequest(http.MethodGet, spec.EarlyExitPolicyURLPath, nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("EarlyExitPolicy() code = %d, want %d", rec.Code, http.StatusOK)
+			}
+			got := &model.EarlyExitPolicy{}
+			if err := json.Unmarshal(rec.Body.Bytes(), got); err != nil {
+				t.Fatalf("EarlyExitPolicy() returned invalid JSON: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("EarlyExitPolicy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,10 +3,12 @@ package handler
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"net/url"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"time"
@@ -16,20 +18,26 @@ import (
 
 	"github.com/m-lab/access/controller"
 	"github.com/m-lab/go/prometheusx"
-	"github.com/m-lab/go/rtx"
 	"github.com/m-lab/go/warnonerror"
 	"github.com/m-lab/ndt-server/data"
 	"github.com/m-lab/ndt-server/logging"
 	"github.com/m-lab/ndt-server/metadata"
 	"github.com/m-lab/ndt-server/metrics"
+	"github.com/m-lab/ndt-server/ndt7/admission"
 	"github.com/m-lab/ndt-server/ndt7/download"
-	"github.com/m-lab/ndt-server/ndt7/download/sender"
+	"github.com/m-lab/ndt-server/ndt7/measurer"
 	ndt7metrics "github.com/m-lab/ndt-server/ndt7/metrics"
 	"github.com/m-lab/ndt-server/ndt7/model"
+	"github.com/m-lab/ndt-server/ndt7/responsiveness"
 	"github.com/m-lab/ndt-server/ndt7/results"
 	"github.com/m-lab/ndt-server/ndt7/spec"
+	"github.com/m-lab/ndt-server/ndt7/stability"
+	"github.com/m-lab/ndt-server/ndt7/summary"
+	"github.com/m-lab/ndt-server/ndt7/transport"
 	"github.com/m-lab/ndt-server/ndt7/upload"
 	"github.com/m-lab/ndt-server/netx"
+	"github.com/m-lab/ndt-server/sink"
+	"github.com/m-lab/ndt-server/spool"
 	"github.com/m-lab/ndt-server/version"
 	"github.com/m-lab/tcp-info/eventsocket"
 	"github.com/m-lab/tcp-info/inetdiag"
@@ -47,8 +55,50 @@ type Handler struct {
 	ServerMetadata []metadata.NameValue
 	// CompressResults controls whether the result files saved by the server are compressed.
 	CompressResults bool
+	// Sink saves the results. When nil, results are saved to files in DataDir.
+	// In streaming mode, the streaming results file replaces the file sinks of
+	// single-stream subtests.
+	Sink sink.ResultSink
+	// Spool, when not nil, monitors DataDir. New subtests are refused while
+	// results cannot be written.
+	Spool *spool.Manager
+	// ResultRetention is how long the results archived in DataDir are returned
+	// by the Result handler. The handler is disabled when zero.
+	ResultRetention time.Duration
 	// Events is for reporting new connections to the event server.
 	Events eventsocket.Server
+	// MinRuntime and MaxRuntime bound the subtest runtime that clients may
+	// request with the "duration" parameter. When MaxRuntime is zero, clients
+	// may only request spec.DefaultRuntime.
+	MinRuntime time.Duration
+	MaxRuntime time.Duration
+	// MaxStreams is the maximum number of parallel streams allowed in a
+	// multi-stream subtest. Multi-stream subtests are disabled when zero.
+	MaxStreams int
+	// EarlyExit is the early exit policy advertised to clients. When nil,
+	// clients may only request the spec.DefaultEarlyExitValues thresholds.
+	EarlyExit *model.EarlyExitPolicy
+	// Stability, when not nil, ends download and upload subtests once the
+	// measured rate has converged.
+	Stability *stability.Criteria
+	// CongestionControls lists the congestion control algorithms that clients
+	// may request with the "cc" parameter. The first algorithm is used when the
+	// client does not request one. When empty, all subtests use
+	// spec.DefaultCongestionControl.
+	CongestionControls []string
+	// StreamResults controls whether the results of single-stream subtests are
+	// written incrementally, as JSON Lines, while the subtest runs.
+	StreamResults bool
+	// MaxDownloadRate caps the pacing rate of every download, in Mbit/s. Clients
+	// presenting a valid access token may request a lower cap with the max_rate
+	// parameter. Downloads are not capped when zero.
+	MaxDownloadRate float64
+	// Admission, when not nil, limits the number of subtests running at the
+	// same time. Responsiveness subtests count in the direction of their load,
+	// and multi-stream subtests take one slot per stream.
+	Admission *admission.Controller
+
+	sessions sessionRegistry
 }
 
 // warnAndClose emits message as a warning and the sends a Bad Request
@@ -59,6 +109,13 @@ func warnAndClose(writer http.ResponseWriter, message string) {
 	writer.WriteHeader(http.StatusBadRequest)
 }
 
+// refuse refuses a subtest that the server cannot run now.
+func refuse(writer http.ResponseWriter, message string) {
+	logging.Logger.Warn(message)
+	writer.Header().Set("Connection", "Close")
+	writer.WriteHeader(http.StatusServiceUnavailable)
+}
+
 // Download handles the download subtest.
 func (h *Handler) Download(rw http.ResponseWriter, req *http.Request) {
 	h.runMeasurement(spec.SubtestDownload, rw, req)
@@ -69,15 +126,91 @@ func (h *Handler) Upload(rw http.ResponseWriter, req *http.Request) {
 	h.runMeasurement(spec.SubtestUpload, rw, req)
 }
 
-// runMeasurement conditionally runs either download or upload based on kind.
-// The kind argument must be spec.SubtestDownload or spec.SubtestUpload.
+// Responsiveness handles the responsiveness subtest.
+func (h *Handler) Responsiveness(rw http.ResponseWriter, req *http.Request) {
+	h.runMeasurement(spec.SubtestResponsiveness, rw, req)
+}
+
+// runMeasurement conditionally runs either download, upload or responsiveness
+// based on kind. The kind argument must be spec.SubtestDownload,
+// spec.SubtestUpload or spec.SubtestResponsiveness.
 func (h *Handler) runMeasurement(kind spec.SubtestKind, rw http.ResponseWriter, req *http.Request) {
 	// Validate client request before opening the connection.
-	params, err := validateEarlyExit(req.URL.Query())
+	policy := h.earlyExitPolicy()
+	params, err := validateEarlyExit(req.URL.Query(), policy)
+	if err != nil {
+		warnAndClose(rw, err.Error())
+		return
+	}
+	params.CongestionControl, err = validateCongestionControl(req.URL.Query(), h.congestionControls())
+	if err != nil {
+		warnAndClose(rw, err.Error())
+		return
+	}
+	maxRate, err := validateMaxRate(req.URL.Query(), kind, controller.GetClaim(req.Context()) != nil)
 	if err != nil {
 		warnAndClose(rw, err.Error())
 		return
 	}
+	minRuntime, maxRuntime := h.runtimeBounds()
+	params.Runtime, err = validateDuration(req.URL.Query(), minRuntime, maxRuntime)
+	if err != nil {
+		warnAndClose(rw, err.Error())
+		return
+	}
+	if kind == spec.SubtestResponsiveness {
+		// The loaded phase must run for the whole runtime.
+		if params.IsEarlyExit {
+			warnAndClose(rw, "early exit is not supported by the responsiveness subtest")
+			return
+		}
+		params.Load, err = validateLoad(req.URL.Query())
+		if err != nil {
+			warnAndClose(rw, err.Error())
+			return
+		}
+	} else {
+		params.Stability = h.Stability
+	}
+	sp, err := validateSession(req.URL.Query(), h.MaxStreams)
+	if err != nil {
+		warnAndClose(rw, err.Error())
+		return
+	}
+	if sp != nil && kind == spec.SubtestResponsiveness {
+		warnAndClose(rw, "multi-stream responsiveness tests are not supported")
+		return
+	}
+	if h.Spool != nil && !h.Spool.Writable() {
+		ndt7metrics.ClientConnections.WithLabelValues(string(kind), "spool-error").Inc()
+		refuse(rw, "cannot write results: "+h.Spool.Err().Error())
+		return
+	}
+	// Join the multi-stream session, if any. Every stream that joins a session
+	// must finish it, even if the stream fails before producing a result.
+	var s *session
+	var sessionResult *data.NDT7Result
+	var proto string
+	if sp != nil {
+		s, err = h.sessions.join(sessionKey(req, sp.ID), kind, *sp)
+		if err != nil {
+			warnAndClose(rw, err.Error())
+			return
+		}
+		defer func() {
+			h.finishSession(s, kind, sessionResult, proto, req)
+		}()
+	}
+	// Wait for free slots before upgrading the connection, so that refused
+	// clients get a plain HTTP response telling them when to retry.
+	release, queueWait, err := h.admit(req.Context(), kind, params, s)
+	if err != nil {
+		ndt7metrics.ClientConnections.WithLabelValues(string(kind), "admission-refused").Inc()
+		rw.Header().Set("Retry-After", strconv.Itoa(h.Admission.RetryAfterSeconds()))
+		refuse(rw, "cannot admit subtest: "+err.Error())
+		return
+	}
+	defer release()
 
 	// Setup websocket connection.
 	conn := setupConn(rw, req)
@@ -86,12 +219,19 @@ func (h *Handler) runMeasurement(kind spec.SubtestKind, rw http.ResponseWriter,
 		ndt7metrics.ClientConnections.WithLabelValues(string(kind), "websocket-error").Inc()
 		return
 	}
-	// Make sure that the connection is closed after (at most) MaxRuntime.
+	proto = ndt7metrics.ConnLabel(conn)
+	if s != nil {
+		// Wait for the other streams, so that all streams start together. The
+		// wait is bounded by spec.SessionJoinTimeout.
+		<-s.ready
+	}
+	// Make sure that the connection is closed after (at most) the MaxRuntime
+	// corresponding to the requested runtime.
 	// Download and upload tests have their own timeouts, but we have observed
 	// that under particular network conditions the connection can remain open
 	// while the receiver goroutine is blocked on a read syscall, long after
 	// the client is gone. This is a workaround for that.
-	ctx, cancel := context.WithTimeout(req.Context(), spec.MaxRuntime)
+	ctx, cancel := context.WithTimeout(req.Context(), spec.MaxRuntimeFor(params.Runtime))
 	defer cancel()
 	go func() {
 		<-ctx.Done()
@@ -110,43 +250,137 @@ func (h *Handler) runMeasurement(kind spec.SubtestKind, rw http.ResponseWriter,
 	// Collect most client metadata from request parameters.
 	appendClientMetadata(data, req.URL.Query())
 	data.ServerMetadata = h.ServerMetadata
+	data.HTTPVersion = req.Proto
+	data.QueueWait = queueWait
+	data.CongestionControl = measurer.SetCongestionControl(conn, params.CongestionControl)
+	if pc := h.pacingCap(kind, maxRate); pc != nil && setPacingCap(conn, pc) {
+		data.PacingCap = pc
+	}
+	if req.URL.Query().Has(spec.DurationParameterName) {
+		data.RequestedDuration = params.Runtime
+	}
+	data.EarlyExit = &model.EarlyExitData{
+		Policy:   policy,
+		MaxBytes: params.MaxBytes,
+		After:    params.EarlyExitAfter,
+		MinBytes: params.EarlyExitMinBytes,
+	}
 	// Create ultimate result.
 	result, id := setupResult(conn)
 	result.StartTime = time.Now().UTC()
 	h.Events.FlowCreated(result.StartTime, data.UUID, id)
+	switch kind {
+	case spec.SubtestDownload:
+		result.Download = data
+	case spec.SubtestUpload:
+		result.Upload = data
+	case spec.SubtestResponsiveness:
+		result.Responsiveness = data
+	}
+	// In streaming mode, the result is written incrementally, so that it is
+	// not lost if the server dies during the subtest. The results of
+	// multi-stream subtests are aggregated, so they are written at the end.
+	var stream *results.File
+	if h.StreamResults && s == nil {
+		stream = h.startStream(data.UUID, kind, result)
+		if stream != nil {
+			params.Recorder = stream
+		}
+	}
 
 	// Guarantee results are written even if subtest functions panic.
 	defer func() {
 		result.EndTime = time.Now().UTC()
-		h.writeResult(data.UUID, kind, result)
+		if s != nil {
+			// The last stream of the session writes the aggregate result.
+			sessionResult = result
+		} else if stream != nil {
+			h.finishStream(stream, data.UUID, kind, result)
+		} else {
+			h.writeResult(data.UUID, kind, result)
+		}
 		h.Events.FlowDeleted(result.EndTime, data.UUID)
 	}()
 
 	// Run measurement.
 	var rate float64
+	rateLabel := string(kind)
 	if kind == spec.SubtestDownload {
-		result.Download = data
 		err = download.Do(ctx, conn, data, params)
-		rate = downRate(data.ServerMeasurements)
+		rate = summary.DownloadRate(data.ServerMeasurements)
 	} else if kind == spec.SubtestUpload {
-		result.Upload = data
-		err = upload.Do(ctx, conn, data)
-		rate = upRate(data.ServerMeasurements)
+		err = upload.Do(ctx, conn, data, params)
+		rate = summary.UploadRate(data.ServerMeasurements)
+	} else if kind == spec.SubtestResponsiveness {
+		err = responsiveness.Do(ctx, conn, data, params)
+		// The rate includes the idle phase, so keep it apart from the rates of
+		// downloads and uploads.
+		rateLabel = string(kind) + "-" + string(params.Load)
+		if params.Load == spec.SubtestUpload {
+			rate = summary.UploadRate(data.ServerMeasurements)
+		} else {
+			rate = summary.DownloadRate(data.ServerMeasurements)
+		}
 	}
 
-	proto := ndt7metrics.ConnLabel(conn)
 	ndt7metrics.ClientTestResults.WithLabelValues(
 		proto, string(kind), metrics.GetResultLabel(err, rate)).Inc()
-	if rate > 0 {
+	if rate > 0 && s == nil {
 		isMon := fmt.Sprintf("%t", controller.IsMonitoring(controller.GetClaim(req.Context())))
 		// Update the common (ndt5+ndt7) measurement rates histogram.
-		metrics.TestRate.WithLabelValues(proto, string(kind), isMon).Observe(rate)
+		metrics.TestRate.WithLabelValues(proto, rateLabel, isMon).Observe(rate)
+	}
+}
+
+// admit waits until the Admission controller, if any, lets the subtest run. It
+// returns the function releasing the slot of a single-stream subtest and how
+// long the subtest waited. The streams of a session are admitted together,
+// with one slot per stream, and released once the session is finished.
+func (h *Handler) admit(ctx context.Context, kind spec.SubtestKind, params *spec.Params, s *session) (func(), time.Duration, error) {
+	if h.Admission == nil {
+		return func() {}, 0, nil
+	}
+	direction := kind
+	if kind == spec.SubtestResponsiveness {
+		direction = params.Load
+	}
+	if s == nil {
+		return h.Admission.Admit(ctx, direction, 1)
+	}
+	// The first stream waits for the whole session, and the other streams
+	// wait for the first one.
+	s.admitOnce.Do(func() {
+		s.release, s.queueWait, s.admitErr = h.Admission.Admit(ctx, direction, s.params.Streams)
+	})
+	return func() {}, s.queueWait, s.admitErr
+}
+
+// finishSession records the result of a stream of a multi-stream subtest. The
+// last stream to finish writes the aggregate result of all streams.
+func (h *Handler) finishSession(s *session, kind spec.SubtestKind, result *data.NDT7Result, proto string, req *http.Request) {
+	results := h.sessions.finish(s, result, proto)
+	if len(results) == 0 {
+		return
+	}
+	agg, rate := aggregateSession(s, results)
+	uuid := ""
+	if len(agg.DownloadStreams) > 0 {
+		uuid = agg.DownloadStreams[0].UUID
+	} else if len(agg.UploadStreams) > 0 {
+		uuid = agg.UploadStreams[0].UUID
+	}
+	h.writeResult(uuid, kind, agg)
+	if rate > 0 {
+		isMon := fmt.Sprintf("%t", controller.IsMonitoring(controller.GetClaim(req.Context())))
+		// Update the common (ndt5+ndt7) measurement rates histogram once per session.
+		metrics.TestRate.WithLabelValues(s.proto, string(kind), isMon).Observe(rate)
 	}
 }
 
-// setupConn negotiates a websocket connection. The writer argument is the HTTP
-// response writer. The request argument is the HTTP request that we received.
-func setupConn(writer http.ResponseWriter, request *http.Request) *websocket.Conn {
+// setupConn negotiates a websocket connection, either with an HTTP/1.1 Upgrade
+// or with an HTTP/2 Extended CONNECT. The writer argument is the HTTP response
+// writer. The request argument is the HTTP request that we received.
+func setupConn(writer http.ResponseWriter, request *http.Request) transport.Conn {
 	logging.Logger.Debug("setupConn: upgrading to WebSockets")
 	if request.Header.Get("Sec-WebSocket-Protocol") != spec.SecWebSocketProtocol {
 		warnAndClose(
@@ -155,6 +389,15 @@ func setupConn(writer http.ResponseWriter, request *http.Request) *websocket.Con
 	}
 	headers := http.Header{}
 	headers.Add("Sec-WebSocket-Protocol", spec.SecWebSocketProtocol)
+	if transport.IsExtendedConnect(request) {
+		// The client bootstraps the WebSocket on an HTTP/2 stream (RFC 8441).
+		conn, err := transport.AcceptH2(writer, request, headers)
+		if err != nil {
+			logging.Logger.WithError(err).Warn("setupConn: transport.AcceptH2 failed")
+			return nil
+		}
+		return conn
+	}
 	upgrader := websocket.Upgrader{
 		CheckOrigin: func(r *http.Request) bool {
 			return true // Allow cross origin resource sharing
@@ -172,7 +415,7 @@ func setupConn(writer http.ResponseWriter, request *http.Request) *websocket.Con
 }
 
 // setupResult creates an NDT7Result from the given conn.
-func setupResult(conn *websocket.Conn) (*data.NDT7Result, inetdiag.SockID) {
+func setupResult(conn transport.Conn) (*data.NDT7Result, inetdiag.SockID) {
 	// NOTE: unless we plan to run the NDT server over different protocols than TCP,
 	// then we expect RemoteAddr and LocalAddr to always return net.TCPAddr types.
 	clientAddr := netx.ToTCPAddr(conn.RemoteAddr())
@@ -201,17 +444,104 @@ func setupResult(conn *websocket.Conn) (*data.NDT7Result, inetdiag.SockID) {
 	return result, id
 }
 
-func (h Handler) writeResult(uuid string, kind spec.SubtestKind, result *data.NDT7Result) {
-	fp, err := results.NewFile(uuid, h.DataDir, kind, h.CompressResults)
-	// Note: an ndt-server instance that cannot write results is not useful. This
-	// is a fatal error.
-	rtx.Must(err, "results.NewFile failed")
-	err = fp.WriteResult(result)
-	rtx.Must(err, "failed to write result")
-	warnonerror.Close(fp, string(kind)+": ignoring fp.Close error")
+// resultSink returns the sink for the results. When streaming, file sinks are
+// skipped, since the streaming results file already contains the result.
+func (h *Handler) resultSink(streaming bool) sink.ResultSink {
+	rs := h.Sink
+	if rs == nil {
+		rs = &results.FileSink{DataDir: h.DataDir, Compress: h.CompressResults}
+	}
+	if !streaming {
+		return rs
+	}
+	return withoutFileSinks(rs, h.DataDir)
+}
+
+// withoutFileSinks returns rs without its sinks saving files to datadir.
+func withoutFileSinks(rs sink.ResultSink, datadir string) sink.ResultSink {
+	switch s := rs.(type) {
+	case sink.FileBacked:
+		if filepath.Clean(s.Dir()) == filepath.Clean(datadir) {
+			return sink.Multi{}
+		}
+	case sink.Validated:
+		s.ResultSink = withoutFileSinks(s.ResultSink, datadir)
+		return s
+	case sink.Multi:
+		m := sink.Multi{}
+		for _, r := range s {
+			m = append(m, withoutFileSinks(r, datadir))
+		}
+		return m
+	}
+	return rs
+}
+
+func (h *Handler) writeResult(uuid string, kind spec.SubtestKind, result *data.NDT7Result) {
+	h.saveResult(h.resultSink(false), uuid, kind, result)
+}
+
+func (h *Handler) saveResult(rs sink.ResultSink, uuid string, kind spec.SubtestKind, result *data.NDT7Result) {
+	err := rs.Save(&sink.Result{
+		Protocol: "ndt7",
+		Kind:     string(kind),
+		UUID:     uuid,
+		Data:     result,
+	})
+	if err != nil {
+		h.writeFailed(err, "failed to save result "+uuid)
+	}
+}
+
+// writeFailed records that a result could not be written. The result is lost,
+// but the server keeps running the other subtests. Rejected results, e.g.,
+// invalid ones, say nothing about the spool.
+func (h *Handler) writeFailed(err error, message string) {
+	logging.Logger.WithError(err).Warn(message)
+	if h.Spool != nil && !errors.Is(err, sink.ErrRejected) {
+		h.Spool.Fail(err)
+	}
+}
+
+// startStream creates a streaming results file and writes the header record.
+// It returns nil when the file cannot be written, in which case the result is
+// saved at the end of the subtest, as when not streaming.
+func (h *Handler) startStream(uuid string, kind spec.SubtestKind, result *data.NDT7Result) *results.File {
+	fp, err := results.NewStreamFile(uuid, h.DataDir, kind, h.CompressResults)
+	if err != nil {
+		h.writeFailed(err, "results.NewStreamFile failed")
+		return nil
+	}
+	err = fp.WriteHeader(result)
+	if err != nil {
+		fp.Abort()
+		h.writeFailed(err, "failed to write result header")
+		return nil
+	}
+	return fp
+}
+
+// finishStream writes the summary record and closes a streaming results file.
+// The result is also saved to the sinks that are not files or, if the
+// streaming results file could not be written, to all sinks.
+func (h *Handler) finishStream(fp *results.File, uuid string, kind spec.SubtestKind, result *data.NDT7Result) {
+	err := fp.WriteSummary(result)
+	if err == nil {
+		err = fp.Close()
+	} else {
+		fp.Abort()
+	}
+	if err != nil {
+		h.writeFailed(err, "failed to write streaming result "+uuid)
+	}
+	h.saveResult(h.resultSink(err == nil), uuid, kind, result)
 }
 
-func getData(conn *websocket.Conn) (*model.ArchivalData, error) {
+// getData creates the archival data for conn.
+//
+// NOTE: HTTP/2 streams may share the same TCP connection, so every stream gets
+// the UUID of the connection with the index of the stream as a suffix.
+func getData(conn transport.Conn) (*model.ArchivalData, error) {
 	ci := netx.ToConnInfo(conn.UnderlyingConn())
 	uuid, err := ci.GetUUID()
 	if err != nil {
@@ -221,27 +551,11 @@ func getData(conn *websocket.Conn) (*model.ArchivalData, error) {
 	data := &model.ArchivalData{
 		UUID: uuid,
 	}
-	return data, nil
-}
-
-func upRate(m []model.Measurement) float64 {
-	var mbps float64
-	// NOTE: on non-Linux platforms, TCPInfo will be nil.
-	if len(m) > 0 && m[len(m)-1].TCPInfo != nil {
-		// Convert to Mbps.
-		mbps = 8 * float64(m[len(m)-1].TCPInfo.BytesReceived) / float64(m[len(m)-1].TCPInfo.ElapsedTime)
-	}
-	return mbps
-}
-
-func downRate(m []model.Measurement) float64 {
-	var mbps float64
-	// NOTE: on non-Linux platforms, TCPInfo will be nil.
-	if len(m) > 0 && m[len(m)-1].TCPInfo != nil {
-		// Convert to Mbps.
-		mbps = 8 * float64(m[len(m)-1].TCPInfo.BytesAcked) / float64(m[len(m)-1].TCPInfo.ElapsedTime)
+	if n := transport.StreamIndex(conn); n > 0 {
+		data.UUID = fmt.Sprintf("%s.%d", uuid, n)
+		data.SocketUUID = uuid
 	}
-	return mbps
+	return data, nil
 }
 
 // excludeKeyRe is a regexp for excluding request parameters from client metadata.
@@ -263,26 +577,103 @@ func appendClientMetadata(data *model.ArchivalData, values url.Values) {
 	}
 }
 
-// validateEarlyExit verifies and returns the "early_exit" parameters.
-func validateEarlyExit(values url.Values) (*sender.Params, error) {
-	for name, values := range values {
-		if name != spec.EarlyExitParameterName {
-			continue
+// validateEarlyExit verifies and returns the "early_exit" and "early_exit_time"
+// parameters. The requested thresholds must be allowed by policy.
+func validateEarlyExit(values url.Values, policy *model.EarlyExitPolicy) (*spec.Params, error) {
+	params := &spec.Params{}
+	if values.Has(spec.EarlyExitParameterName) {
+		value := values.Get(spec.EarlyExitParameterName)
+		mb, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || !slices.Contains(policy.MB, mb) {
+			return nil, fmt.Errorf("invalid %s parameter value %s", spec.EarlyExitParameterName, value)
 		}
-
-		value := values[0]
-		if !slices.Contains(spec.ValidEarlyExitValues, value) {
-			return nil, fmt.Errorf("invalid %s parameter value %s", name, value)
+		params.IsEarlyExit = true
+		params.MaxBytes = mb * 1000000 // Convert MB to bytes.
+	}
+	if values.Has(spec.EarlyExitTimeParameterName) {
+		value := values.Get(spec.EarlyExitTimeParameterName)
+		seconds, err := strconv.ParseInt(value, 10, 64)
+		i := slices.IndexFunc(policy.Time, func(t model.EarlyExitTime) bool {
+			return t.Seconds == seconds
+		})
+		if err != nil || i < 0 {
+			return nil, fmt.Errorf("invalid %s parameter value %s", spec.EarlyExitTimeParameterName, value)
 		}
+		params.IsEarlyExit = true
+		params.EarlyExitAfter = time.Duration(seconds) * time.Second
+		params.EarlyExitMinBytes = policy.Time[i].MinMB * 1000000 // Convert MB to bytes.
+	}
+	return params, nil
+}
+
+// validateLoad verifies and returns the "load" parameter of the responsiveness
+// subtest. Requests without the parameter load the link with a download.
+func validateLoad(values url.Values) (spec.SubtestKind, error) {
+	if !values.Has(spec.LoadParameterName) {
+		return spec.SubtestDownload, nil
+	}
+	value := spec.SubtestKind(values.Get(spec.LoadParameterName))
+	if value != spec.SubtestDownload && value != spec.SubtestUpload {
+		return "", fmt.Errorf("invalid %s parameter value %s", spec.LoadParameterName, value)
+	}
+	return value, nil
+}
+
+// congestionControls returns the congestion control algorithms that clients
+// may request. Unless configured, only spec.DefaultCongestionControl is allowed.
+func (h *Handler) congestionControls() []string {
+	if len(h.CongestionControls) == 0 {
+		return []string{spec.DefaultCongestionControl}
+	}
+	return h.CongestionControls
+}
+
+// validateCongestionControl verifies and returns the "cc" parameter. The
+// requested algorithm must be one of allowed. Requests without the parameter
+// use the first allowed algorithm.
+func validateCongestionControl(values url.Values, allowed []string) (string, error) {
+	if !values.Has(spec.CongestionControlParameterName) {
+		return allowed[0], nil
+	}
+	value := values.Get(spec.CongestionControlParameterName)
+	if !slices.Contains(allowed, value) {
+		return "", fmt.Errorf("invalid %s parameter value %s", spec.CongestionControlParameterName, value)
+	}
+	return value, nil
+}
 
-		// Convert string to int64.
-		bytes, _ := strconv.ParseInt(value, 10, 64)
-		return &sender.Params{
-			IsEarlyExit: true,
-			MaxBytes:    bytes * 1000000, // Conver MB to bytes.
-		}, nil
+// runtimeBounds returns the min and max subtest runtime that clients may
+// request. Unless configured, only spec.DefaultRuntime is allowed.
+func (h *Handler) runtimeBounds() (time.Duration, time.Duration) {
+	if h.MaxRuntime == 0 {
+		return spec.DefaultRuntime, spec.DefaultRuntime
+	}
+	return h.MinRuntime, h.MaxRuntime
+}
+
+// validateDuration verifies and returns the subtest runtime requested with the
+// "duration" parameter. The runtime must be between min and max, inclusive.
+// Requests without the parameter run for spec.DefaultRuntime, clamped to the
+// same bounds.
+func validateDuration(values url.Values, min, max time.Duration) (time.Duration, error) {
+	if !values.Has(spec.DurationParameterName) {
+		switch {
+		case spec.DefaultRuntime < min:
+			return min, nil
+		case spec.DefaultRuntime > max:
+			return max, nil
+		}
+		return spec.DefaultRuntime, nil
+	}
+	value := values.Get(spec.DurationParameterName)
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s parameter value %s", spec.DurationParameterName, value)
+	}
+	runtime := time.Duration(seconds) * time.Second
+	if runtime < min || runtime > max {
+		return 0, fmt.Errorf("%s parameter value %s is outside of [%v, %v]",
+			spec.DurationParameterName, value, min, max)
 	}
-	return &sender.Params{
-		IsEarlyExit: false,
-	}, nil
+	return runtime, nil
 }
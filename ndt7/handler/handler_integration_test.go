@@ -2,17 +2,41 @@
 package handler_test
 
 import (
+	"bufio"
 	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/m-lab/go/testingx"
+	"github.com/m-lab/ndt-server/data"
+	"github.com/m-lab/ndt-server/ndt7/admission"
+	"github.com/m-lab/ndt-server/ndt7/client"
+	"github.com/m-lab/ndt-server/ndt7/handler"
+	ndt7metrics "github.com/m-lab/ndt-server/ndt7/metrics"
+	"github.com/m-lab/ndt-server/ndt7/model"
 	"github.com/m-lab/ndt-server/ndt7/ndt7test"
+	"github.com/m-lab/ndt-server/ndt7/results"
 	"github.com/m-lab/ndt-server/ndt7/spec"
+	"github.com/m-lab/ndt-server/ndt7/transport"
+	"github.com/m-lab/ndt-server/netx"
+	"github.com/m-lab/ndt-server/spool"
+	"github.com/m-lab/tcp-info/eventsocket"
 	"github.com/m-lab/tcp-info/inetdiag"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/net/http2"
 )
 
 // fakeServer implements the eventsocket.Server interface for testing the ndt7 handler.
@@ -40,7 +64,7 @@ func TestHandler_Download(t *testing.T) {
 		// Run a pseudo test to generate connection events.
 		conn, err := simpleConnect(srv.URL)
 		testingx.Must(t, err, "failed to dial websocket ndt7 test")
-		err = downloadHelper(context.Background(), t, conn)
+		err = downloadHelper(context.Background(), conn)
 		if err != nil && !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
 			testingx.Must(t, err, "failed to download")
 		}
@@ -64,11 +88,355 @@ func TestHandler_Download(t *testing.T) {
 	})
 }
 
+func TestHandler_MultiStreamDownload(t *testing.T) {
+	ndt7h, srv := ndt7test.NewNDT7Server(t)
+	ndt7h.MaxStreams = 2
+
+	// Run two pseudo tests sharing the same session.
+	params := url.Values{"session": {"abc"}, "streams": {"2"}}
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			conn, err := simpleConnectWithQuery(srv.URL, params)
+			if err != nil {
+				errs <- fmt.Errorf("failed to dial websocket ndt7 test: %w", err)
+				return
+			}
+			err = downloadHelper(context.Background(), conn)
+			if err != nil && !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				errs <- fmt.Errorf("failed to download: %w", err)
+				return
+			}
+			errs <- nil
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		testingx.Must(t, <-errs, "stream failed")
+	}
+
+	// Wait for the server to write the aggregate result.
+	var files []string
+	for start := time.Now(); time.Since(start) < 15*time.Second; time.Sleep(100 * time.Millisecond) {
+		var err error
+		files, err = filepath.Glob(ndt7h.DataDir + "/ndt7/*/*/*/*")
+		testingx.Must(t, err, "failed to glob datadir: %s", ndt7h.DataDir)
+		if len(files) > 0 {
+			break
+		}
+	}
+	srv.Close()
+	if len(files) != 1 {
+		t.Fatalf("wrong number of result files; got %d, want 1", len(files))
+	}
+	b, err := os.ReadFile(files[0])
+	testingx.Must(t, err, "failed to read result file")
+	result := &data.NDT7Result{}
+	testingx.Must(t, json.Unmarshal(b, result), "failed to parse result file")
+	if result.Session == nil || result.Session.ID != "abc" || result.Session.Streams != 2 {
+		t.Errorf("wrong session data; got %+v", result.Session)
+	}
+	if len(result.DownloadStreams) != 2 {
+		t.Errorf("wrong number of streams; got %d, want 2", len(result.DownloadStreams))
+	}
+}
+
+func TestHandler_DownloadCongestionControl(t *testing.T) {
+	ndt7h, srv := ndt7test.NewNDT7Server(t)
+	ndt7h.CongestionControls = []string{"bbr", "reno"}
+	ndt7h.MinRuntime, ndt7h.MaxRuntime = time.Second, spec.DefaultRuntime
+
+	download(t, srv.URL, url.Values{"cc": {"reno"}})
+
+	result := waitForResult(t, ndt7h.DataDir)
+	srv.Close()
+	if result.Download == nil || result.Download.CongestionControl != "reno" {
+		t.Errorf("wrong congestion control; got %+v", result.Download)
+	}
+}
+
+func TestHandler_DownloadPacingCap(t *testing.T) {
+	ndt7h, srv := ndt7test.NewNDT7Server(t)
+	ndt7h.MaxDownloadRate = 10
+	ndt7h.MinRuntime, ndt7h.MaxRuntime = time.Second, spec.DefaultRuntime
+
+	download(t, srv.URL, url.Values{"duration": {"2"}})
+
+	result := waitForResult(t, ndt7h.DataDir)
+	srv.Close()
+	want := &model.PacingCap{Rate: 1250000, Source: model.PacingCapOperator}
+	if result.Download == nil || result.Download.PacingCap == nil || *result.Download.PacingCap != *want {
+		t.Fatalf("wrong pacing cap; got %+v, want %+v", result.Download, want)
+	}
+	m := result.Download.ServerMeasurements
+	if len(m) == 0 || m[len(m)-1].TCPInfo == nil {
+		t.Fatalf("missing TCPInfo in server measurements")
+	}
+	last := m[len(m)-1].TCPInfo
+	// Allow for the initial burst before pacing kicks in.
+	if mbps := 8 * float64(last.BytesAcked) / float64(last.ElapsedTime); mbps > 20 {
+		t.Errorf("download was not capped; got %.1f Mbit/s", mbps)
+	}
+}
+
+func TestHandler_DownloadSummary(t *testing.T) {
+	ndt7h, srv := ndt7test.NewNDT7Server(t)
+	ndt7h.MinRuntime, ndt7h.MaxRuntime = time.Second, spec.DefaultRuntime
+
+	sent := download(t, srv.URL, nil)
+	last := sent.ServerMeasurements[len(sent.ServerMeasurements)-1]
+	if last.Summary == nil || last.Summary.UUID == "" || last.Summary.MeanThroughputMbps <= 0 {
+		t.Fatalf("last message has no valid summary; got %+v", last)
+	}
+
+	result := waitForResult(t, ndt7h.DataDir)
+	srv.Close()
+	if result.Download == nil || result.Download.Summary == nil || *result.Download.Summary != *last.Summary {
+		t.Errorf("archived summary differs from the one sent; got %+v, want %+v", result.Download, last.Summary)
+	}
+}
+
+func TestHandler_DownloadStreamResults(t *testing.T) {
+	ndt7h, srv := ndt7test.NewNDT7Server(t)
+	ndt7h.StreamResults = true
+	ndt7h.MinRuntime, ndt7h.MaxRuntime = time.Second, spec.DefaultRuntime
+
+	download(t, srv.URL, nil)
+
+	var files []string
+	var err error
+	for start := time.Now(); time.Since(start) < 15*time.Second; time.Sleep(100 * time.Millisecond) {
+		files, err = filepath.Glob(ndt7h.DataDir + "/ndt7/*/*/*/*.jsonl")
+		testingx.Must(t, err, "failed to glob datadir: %s", ndt7h.DataDir)
+		if len(files) > 0 {
+			// Wait for the summary record.
+			if _, complete, _ := results.ReadStreamFile(files[0]); complete {
+				break
+			}
+		}
+	}
+	srv.Close()
+	if len(files) != 1 {
+		t.Fatalf("wrong number of result files; got %d, want 1", len(files))
+	}
+	result, complete, err := results.ReadStreamFile(files[0])
+	if err != nil || !complete {
+		t.Fatalf("ReadStreamFile() = %v, %v, want complete", complete, err)
+	}
+	if result.Download == nil || len(result.Download.ServerMeasurements) == 0 ||
+		result.Download.Summary == nil || result.EndTime.IsZero() {
+		t.Errorf("wrong result; got %+v", result)
+	}
+}
+
+func TestHandler_DownloadHTTP2(t *testing.T) {
+	// Extended CONNECT can only be enabled when the process starts.
+	if !strings.Contains(os.Getenv("GODEBUG"), "http2xconnect=1") {
+		t.Skip("WebSockets over HTTP/2 require GODEBUG=http2xconnect=1")
+	}
+	ndt7h := &handler.Handler{
+		DataDir:    t.TempDir(),
+		Events:     eventsocket.NullServer(),
+		MinRuntime: time.Second,
+		MaxRuntime: spec.DefaultRuntime,
+	}
+	mux := http.NewServeMux()
+	mux.Handle(spec.DownloadURLPath, http.HandlerFunc(ndt7h.Download))
+	srv := httptest.NewUnstartedServer(mux)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	testingx.Must(t, err, "failed to allocate a listening tcp socket")
+	srv.Listener = netx.NewListener(listener.(*net.TCPListener))
+	srv.Config.ConnContext = transport.ConnContext
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	// Bootstrap a WebSocket with an Extended CONNECT request.
+	pr, pw := io.Pipe()
+	req, err := http.NewRequest(http.MethodConnect, srv.URL+spec.DownloadURLPath+"?duration=1", pr)
+	testingx.Must(t, err, "failed to create request")
+	req.Header.Set(":protocol", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Protocol", spec.SecWebSocketProtocol)
+	// NOTE: net/http clients cannot send the :protocol pseudo-header.
+	client := &http.Client{Transport: &http2.Transport{
+		TLSClientConfig: srv.Client().Transport.(*http.Transport).TLSClientConfig,
+	}}
+	resp, err := client.Do(req)
+	testingx.Must(t, err, "failed to send request")
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.ProtoMajor != 2 {
+		t.Fatalf("wrong response; got %s %s", resp.Proto, resp.Status)
+	}
+
+	// WARNING: this is not a reference client. Read frames until the server
+	// starts closing, then reply with a masked close frame.
+	r := bufio.NewReader(resp.Body)
+	var text, binary int
+	for opcode := 0; opcode != websocket.CloseMessage; {
+		var hdr [2]byte
+		_, err := io.ReadFull(r, hdr[:])
+		testingx.Must(t, err, "failed to read frame header")
+		opcode = int(hdr[0] & 0x0f)
+		length := int64(hdr[1])
+		if length == 126 || length == 127 {
+			ext := make([]byte, map[int64]int{126: 2, 127: 8}[length])
+			_, err = io.ReadFull(r, ext)
+			testingx.Must(t, err, "failed to read frame length")
+			length = 0
+			for _, b := range ext {
+				length = length<<8 | int64(b)
+			}
+		}
+		_, err = io.CopyN(io.Discard, r, length)
+		testingx.Must(t, err, "failed to read frame payload")
+		switch opcode {
+		case websocket.TextMessage:
+			text++
+		case websocket.BinaryMessage:
+			binary++
+		}
+	}
+	_, err = pw.Write([]byte{0x88, 0x80, 0, 0, 0, 0})
+	testingx.Must(t, err, "failed to write close frame")
+	pw.Close()
+	if text == 0 || binary == 0 {
+		t.Errorf("wrong number of messages; got %d text and %d binary", text, binary)
+	}
+
+	// Wait for the server to write the result.
+	var files []string
+	for start := time.Now(); time.Since(start) < 15*time.Second; time.Sleep(100 * time.Millisecond) {
+		files, err = filepath.Glob(ndt7h.DataDir + "/ndt7/*/*/*/*")
+		testingx.Must(t, err, "failed to glob datadir: %s", ndt7h.DataDir)
+		if len(files) > 0 {
+			break
+		}
+	}
+	if len(files) != 1 {
+		t.Fatalf("wrong number of result files; got %d, want 1", len(files))
+	}
+	b, err := os.ReadFile(files[0])
+	testingx.Must(t, err, "failed to read result file")
+	result := &data.NDT7Result{}
+	testingx.Must(t, json.Unmarshal(b, result), "failed to parse result file")
+	if result.Download == nil || result.Download.HTTPVersion != "HTTP/2.0" {
+		t.Errorf("wrong HTTP version; got %+v", result.Download)
+	}
+	if result.Download != nil && (result.Download.SocketUUID == "" ||
+		result.Download.UUID != result.Download.SocketUUID+".1") {
+		t.Errorf("wrong stream UUID; got %q on socket %q", result.Download.UUID, result.Download.SocketUUID)
+	}
+}
+
+func TestHandler_UploadEarlyExit(t *testing.T) {
+	ndt7h, srv := ndt7test.NewNDT7Server(t)
+	defer srv.Close()
+	ndt7h.EarlyExit = &model.EarlyExitPolicy{MB: []int64{1}}
+
+	c, err := client.New(srv.URL)
+	testingx.Must(t, err, "failed to create client")
+	c.EarlyExit = 1
+	start := time.Now()
+	st, err := c.Upload(context.Background())
+	testingx.Must(t, err, "failed to start upload")
+	if _, err := st.Wait(); err != nil {
+		t.Errorf("upload did not end with a close message; got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= spec.DefaultRuntime {
+		t.Errorf("upload did not end early; took %v", elapsed)
+	}
+}
+
+func TestHandler_ResponsivenessEarlyExit(t *testing.T) {
+	ndt7h, srv := ndt7test.NewNDT7Server(t)
+	defer srv.Close()
+	ndt7h.EarlyExit = &model.EarlyExitPolicy{MB: []int64{1}}
+
+	c, err := client.New(srv.URL)
+	testingx.Must(t, err, "failed to create client")
+	c.EarlyExit = 1
+	headers := http.Header{}
+	headers.Add("Sec-WebSocket-Protocol", spec.SecWebSocketProtocol)
+	_, resp, err := websocket.DefaultDialer.Dial(c.URL(spec.ResponsivenessURLPath).String(), headers)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("responsiveness subtest with early exit was not refused; got %v", err)
+	}
+}
+
+func TestHandler_ResponsivenessSummary(t *testing.T) {
+	ndt7h, srv := ndt7test.NewNDT7Server(t)
+	ndt7h.MinRuntime, ndt7h.MaxRuntime = time.Second, spec.DefaultRuntime
+
+	URL, _ := url.Parse(srv.URL)
+	URL.Scheme = "ws"
+	URL.Path = spec.ResponsivenessURLPath
+	URL.RawQuery = url.Values{"duration": {"2"}}.Encode()
+	headers := http.Header{}
+	headers.Add("Sec-WebSocket-Protocol", spec.SecWebSocketProtocol)
+	conn, _, err := websocket.DefaultDialer.Dial(URL.String(), headers)
+	testingx.Must(t, err, "failed to dial websocket ndt7 test")
+	defer conn.Close()
+	conn.SetReadLimit(spec.MaxMessageSize)
+	testingx.Must(t, conn.SetReadDeadline(time.Now().Add(spec.MaxRuntime)), "failed to set read deadline")
+	// The default ping handler answers the pings of the server.
+	var sent *model.ResponsivenessSummary
+	for {
+		kind, b, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+		var m model.Measurement
+		if kind == websocket.TextMessage && json.Unmarshal(b, &m) == nil &&
+			m.ResponsivenessInfo != nil && m.ResponsivenessInfo.Summary != nil {
+			sent = m.ResponsivenessInfo.Summary
+		}
+	}
+	if sent == nil {
+		t.Fatalf("no summary received")
+	}
+
+	result := waitForResult(t, ndt7h.DataDir)
+	srv.Close()
+	if result.Responsiveness == nil {
+		t.Fatalf("missing responsiveness data")
+	}
+	if got := result.Responsiveness.Responsiveness; got == nil || *got != *sent {
+		t.Errorf("archived summary differs from the one sent; got %+v, want %+v", got, sent)
+	}
+}
+
+// waitForResult waits for the server to write a single result file in dir and
+// returns the parsed result.
+func waitForResult(t *testing.T, dir string) *data.NDT7Result {
+	var files []string
+	for start := time.Now(); time.Since(start) < 15*time.Second; time.Sleep(100 * time.Millisecond) {
+		var err error
+		files, err = filepath.Glob(dir + "/ndt7/*/*/*/*")
+		testingx.Must(t, err, "failed to glob datadir: %s", dir)
+		if len(files) > 0 {
+			break
+		}
+	}
+	if len(files) != 1 {
+		t.Fatalf("wrong number of result files; got %d, want 1", len(files))
+	}
+	b, err := os.ReadFile(files[0])
+	testingx.Must(t, err, "failed to read result file")
+	result := &data.NDT7Result{}
+	testingx.Must(t, json.Unmarshal(b, result), "failed to parse result file")
+	return result
+}
+
 func simpleConnect(srv string) (*websocket.Conn, error) {
+	return simpleConnectWithQuery(srv, nil)
+}
+
+func simpleConnectWithQuery(srv string, params url.Values) (*websocket.Conn, error) {
 	// Prepare to run a simplified download with ndt7test server.
 	URL, _ := url.Parse(srv)
 	URL.Scheme = "ws"
 	URL.Path = spec.DownloadURLPath
+	URL.RawQuery = params.Encode()
 	headers := http.Header{}
 	headers.Add("Sec-WebSocket-Protocol", spec.SecWebSocketProtocol)
 	headers.Add("User-Agent", "fake-user-agent")
@@ -78,16 +446,319 @@ func simpleConnect(srv string) (*websocket.Conn, error) {
 	return conn, err
 }
 
-// WARNING: this is not a reference client.
-func downloadHelper(ctx context.Context, t *testing.T, conn *websocket.Conn) error {
+// download runs a download with the given parameters until the server ends
+// it, and returns its result. The download lasts one second, unless params
+// request a different duration.
+func download(t *testing.T, srv string, params url.Values) *client.Result {
+	c, err := client.New(srv)
+	testingx.Must(t, err, "failed to create client")
+	c.Params = params
+	if !params.Has(spec.DurationParameterName) {
+		c.Duration = time.Second
+	}
+	st, err := c.Download(context.Background())
+	testingx.Must(t, err, "failed to start download")
+	result, err := st.Wait()
+	testingx.Must(t, err, "failed to download")
+	return result
+}
+
+// downloadHelper reads one message and closes the connection before the end
+// of the download.
+func downloadHelper(ctx context.Context, conn *websocket.Conn) error {
 	defer conn.Close()
 	conn.SetReadLimit(spec.MaxMessageSize)
-	err := conn.SetReadDeadline(time.Now().Add(spec.MaxRuntime))
-	testingx.Must(t, err, "failed to set read deadline")
-	_, _, err = conn.ReadMessage()
+	if err := conn.SetReadDeadline(time.Now().Add(spec.MaxRuntime)); err != nil {
+		return err
+	}
+	_, _, err := conn.ReadMessage()
 	if err != nil {
 		return err
 	}
 	// We only read one message, so this is an early close.
 	return conn.Close()
 }
+
+func TestHandler_SpoolUnwritable(t *testing.T) {
+	ndt7h, srv := ndt7test.NewNDT7Server(t)
+	defer os.RemoveAll(ndt7h.DataDir)
+	ndt7h.Spool = &spool.Manager{Dir: ndt7h.DataDir}
+	ndt7h.Spool.Fail(errors.New("disk full"))
+
+	resp, err := http.Get(srv.URL + spec.DownloadURLPath)
+	testingx.Must(t, err, "failed to send request")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("wrong status; got %d, want %d", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+
+	// Once the spool is writable again, tests run normally.
+	testingx.Must(t, ndt7h.Spool.Check(), "spool check failed")
+	conn, err := simpleConnect(srv.URL)
+	testingx.Must(t, err, "failed to dial websocket ndt7 test")
+	conn.Close()
+}
+
+func TestHandler_Admission(t *testing.T) {
+	ndt7h, srv := ndt7test.NewNDT7Server(t)
+	defer srv.Close()
+	ndt7h.MinRuntime, ndt7h.MaxRuntime = time.Second, spec.DefaultRuntime
+	ndt7h.Admission = &admission.Controller{MaxDownloads: 1, QueueSize: 1, MaxWait: 10 * time.Second}
+
+	start := func() (*client.Subtest, error) {
+		c, err := client.New(srv.URL)
+		testingx.Must(t, err, "failed to create client")
+		c.Duration = time.Second
+		return c.Download(context.Background())
+	}
+	first, err := start()
+	testingx.Must(t, err, "failed to start download")
+	// The second download waits in the queue until the first one ends.
+	queued := make(chan error)
+	go func() {
+		st, err := start()
+		if err == nil {
+			_, err = st.Wait()
+		}
+		queued <- err
+	}()
+	waiting := admission.QueuedTests.WithLabelValues(string(spec.SubtestDownload))
+	for start := time.Now(); testutil.ToFloat64(waiting) == 0 && time.Since(start) < 5*time.Second; {
+		time.Sleep(10 * time.Millisecond)
+	}
+	// The queue is full, so the third download is refused.
+	resp, err := http.Get(srv.URL + spec.DownloadURLPath)
+	testingx.Must(t, err, "failed to send request")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") != "10" {
+		t.Errorf("wrong response; got %d with Retry-After %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+
+	_, err = first.Wait()
+	testingx.Must(t, err, "failed to download")
+	testingx.Must(t, <-queued, "failed to run the queued download")
+	var files []string
+	for start := time.Now(); len(files) < 2 && time.Since(start) < 15*time.Second; time.Sleep(100 * time.Millisecond) {
+		files, err = filepath.Glob(ndt7h.DataDir + "/ndt7/*/*/*/*")
+		testingx.Must(t, err, "failed to glob datadir")
+	}
+	var waits []time.Duration
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		testingx.Must(t, err, "failed to read result file")
+		result := &data.NDT7Result{}
+		testingx.Must(t, json.Unmarshal(b, result), "failed to parse result file")
+		waits = append(waits, result.Download.QueueWait)
+	}
+	if len(waits) != 2 || min(waits[0], waits[1]) != 0 || max(waits[0], waits[1]) < 500*time.Millisecond {
+		t.Errorf("wrong queue waits; got %v", waits)
+	}
+}
+
+func TestHandler_AdmissionSession(t *testing.T) {
+	ndt7h, srv := ndt7test.NewNDT7Server(t)
+	defer srv.Close()
+	ndt7h.MinRuntime, ndt7h.MaxRuntime = time.Second, spec.DefaultRuntime
+	ndt7h.MaxStreams = 3
+	ndt7h.Admission = &admission.Controller{MaxDownloads: 2, QueueSize: 1, MaxWait: 10 * time.Second}
+
+	// Sessions with more streams than the limit are refused right away.
+	resp, err := http.Get(srv.URL + spec.DownloadURLPath + "?session=big&streams=3")
+	testingx.Must(t, err, "failed to send request")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("wrong response; got %d with Retry-After %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+
+	// A session with two streams waits for both slots.
+	c, err := client.New(srv.URL)
+	testingx.Must(t, err, "failed to create client")
+	c.Duration = time.Second
+	first, err := c.Download(context.Background())
+	testingx.Must(t, err, "failed to start download")
+	params := url.Values{"session": {"abc"}, "streams": {"2"}}
+	errs := make(chan error)
+	for i := 0; i < 2; i++ {
+		go func() {
+			conn, err := simpleConnectWithQuery(srv.URL, params)
+			if err == nil {
+				err = downloadHelper(context.Background(), conn)
+			}
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				err = nil
+			}
+			errs <- err
+		}()
+	}
+	_, err = first.Wait()
+	testingx.Must(t, err, "failed to download")
+	for i := 0; i < 2; i++ {
+		testingx.Must(t, <-errs, "failed to run session stream")
+	}
+
+	var session *data.NDT7Result
+	for start := time.Now(); session == nil && time.Since(start) < 15*time.Second; time.Sleep(100 * time.Millisecond) {
+		files, err := filepath.Glob(ndt7h.DataDir + "/ndt7/*/*/*/*")
+		testingx.Must(t, err, "failed to glob datadir")
+		for _, f := range files {
+			b, err := os.ReadFile(f)
+			testingx.Must(t, err, "failed to read result file")
+			result := &data.NDT7Result{}
+			testingx.Must(t, json.Unmarshal(b, result), "failed to parse result file")
+			if result.Session != nil {
+				session = result
+			}
+		}
+	}
+	if session == nil || len(session.DownloadStreams) != 2 {
+		t.Fatalf("wrong session result; got %+v", session)
+	}
+	for _, ad := range session.DownloadStreams {
+		if ad.QueueWait < 500*time.Millisecond {
+			t.Errorf("wrong queue wait of stream %s; got %v", ad.UUID, ad.QueueWait)
+		}
+	}
+}
+
+func TestHandler_Result(t *testing.T) {
+	// Subtests do not require tokens, but archived results always do.
+	s := ndt7test.NewServer(t, false)
+	s.Handler.ResultRetention = time.Hour
+	s.Handler.MinRuntime, s.Handler.MaxRuntime = time.Second, spec.DefaultRuntime
+	srv := "http://" + strings.TrimPrefix(s.WSURL, "ws://")
+
+	sent := download(t, s.WSURL, nil)
+	archived := waitForResult(t, s.Handler.DataDir)
+	if archived.Download.UUID != sent.UUID {
+		t.Fatalf("wrong archived UUID; got %s, want %s", archived.Download.UUID, sent.UUID)
+	}
+
+	getWithToken := func(uuid, token string) *http.Response {
+		params := url.Values{"uuid": {uuid}}
+		if token != "" {
+			params.Set("access_token", token)
+		}
+		resp, err := http.Get(srv + spec.ResultURLPath + "?" + params.Encode())
+		testingx.Must(t, err, "failed to get result")
+		return resp
+	}
+	get := func(uuid string) *http.Response {
+		return getWithToken(uuid, s.Token(t))
+	}
+	for name, token := range map[string]string{
+		"missing":       "",
+		"expired":       s.ExpiredToken(t),
+		"wrong-machine": s.WrongMachineToken(t),
+	} {
+		resp := getWithToken(archived.Download.UUID, token)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%s token: wrong status; got %d, want %d", name, resp.StatusCode, http.StatusUnauthorized)
+		}
+	}
+
+	resp := get(archived.Download.UUID)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status; got %d", resp.StatusCode)
+	}
+	var found []struct {
+		Protocol string
+		Kind     string
+		UUID     string
+		Data     data.NDT7Result
+	}
+	testingx.Must(t, json.NewDecoder(resp.Body).Decode(&found), "failed to decode result")
+	if len(found) != 1 || found[0].Protocol != "ndt7" || found[0].Kind != "download" ||
+		found[0].Data.Download == nil || found[0].Data.Download.UUID != archived.Download.UUID {
+		t.Errorf("wrong result; got %+v", found)
+	}
+
+	for uuid, want := range map[string]int{
+		"unknown": http.StatusNotFound,
+		"../x":    http.StatusBadRequest,
+	} {
+		resp := get(uuid)
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("%s: wrong status; got %d, want %d", uuid, resp.StatusCode, want)
+		}
+	}
+}
+
+func TestHandler_AccessTokens(t *testing.T) {
+	s := ndt7test.NewServer(t, true)
+	s.Handler.MinRuntime, s.Handler.MaxRuntime = time.Second, spec.DefaultRuntime
+
+	tests := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{name: "missing", want: http.StatusUnauthorized},
+		{name: "expired", token: s.ExpiredToken(t), want: http.StatusUnauthorized},
+		{name: "wrong-machine", token: s.WrongMachineToken(t), want: http.StatusUnauthorized},
+		{name: "valid", token: s.Token(t)},
+	}
+	for _, srv := range []string{s.WSURL, s.WSSURL} {
+		for _, tt := range tests {
+			c, err := client.New(srv)
+			testingx.Must(t, err, "failed to create client")
+			c.Dialer.TLSClientConfig = &tls.Config{RootCAs: s.RootCAs}
+			c.AccessToken = tt.token
+			c.Duration = time.Second
+			for _, start := range []func(context.Context) (*client.Subtest, error){c.Download, c.Upload} {
+				st, err := start(context.Background())
+				if tt.want != 0 {
+					var herr *client.HandshakeError
+					if !errors.As(err, &herr) || herr.StatusCode != tt.want {
+						t.Errorf("%s %s: wrong error; got %v, want status %d", srv, tt.name, err, tt.want)
+					}
+					continue
+				}
+				testingx.Must(t, err, "%s %s: failed to start subtest", srv, tt.name)
+				if _, err := st.Wait(); err != nil {
+					t.Errorf("%s %s %s: subtest failed: %v", srv, tt.name, st.Kind, err)
+				}
+			}
+		}
+	}
+}
+
+// downloadResults returns the number of download results counted by the
+// server with the given protocol label.
+func downloadResults(proto string) float64 {
+	var n float64
+	for _, result := range []string{"okay-with-rate", "okay-without-rate", "error-with-rate", "error-without-rate"} {
+		n += testutil.ToFloat64(ndt7metrics.ClientTestResults.WithLabelValues(proto, "download", result))
+	}
+	return n
+}
+
+func TestHandler_ConnLabel(t *testing.T) {
+	s := ndt7test.NewServer(t, false)
+	s.Handler.MinRuntime, s.Handler.MaxRuntime = time.Second, spec.DefaultRuntime
+
+	for srv, proto := range map[string]string{
+		s.WSURL:  "ndt7+ws",
+		s.WSSURL: "ndt7+wss",
+	} {
+		before := downloadResults(proto)
+		c, err := client.New(srv)
+		testingx.Must(t, err, "failed to create client")
+		c.Dialer.TLSClientConfig = &tls.Config{RootCAs: s.RootCAs}
+		c.Duration = time.Second
+		st, err := c.Download(context.Background())
+		testingx.Must(t, err, "failed to start download")
+		_, err = st.Wait()
+		testingx.Must(t, err, "failed to download")
+		// The server counts the result after the client sees the end of the
+		// download.
+		for start := time.Now(); downloadResults(proto) == before && time.Since(start) < 5*time.Second; {
+			time.Sleep(100 * time.Millisecond)
+		}
+		if got := downloadResults(proto); got != before+1 {
+			t.Errorf("%s: wrong number of %s results; got %v, want %v", srv, proto, got, before+1)
+		}
+	}
+}
@@ -5,30 +5,44 @@ import (
 	"net/url"
 	"reflect"
 	"testing"
+	"time"
 
-	"github.com/m-lab/ndt-server/ndt7/download/sender"
+	"github.com/m-lab/ndt-server/ndt5"
+	"github.com/m-lab/ndt-server/ndt7/model"
+	"github.com/m-lab/ndt-server/ndt7/results"
 	"github.com/m-lab/ndt-server/ndt7/spec"
+	"github.com/m-lab/ndt-server/sink"
 )
 
 func Test_validateEarlyExit(t *testing.T) {
-	type args struct {
-		values url.Values
+	policy := &model.EarlyExitPolicy{
+		MB:   []int64{250, 100},
+		Time: []model.EarlyExitTime{{Seconds: 5, MinMB: 10}},
 	}
 	tests := []struct {
 		name    string
 		values  url.Values
-		want    *sender.Params
+		want    *spec.Params
 		wantErr bool
 	}{
 		{
 			name:   "valid-param",
-			values: url.Values{"early_exit": {spec.ValidEarlyExitValues[0]}},
-			want: &sender.Params{
+			values: url.Values{"early_exit": {"250"}},
+			want: &spec.Params{
 				IsEarlyExit: true,
 				MaxBytes:    250000000,
 			},
 			wantErr: false,
 		},
+		{
+			name:   "valid-param-other-tier",
+			values: url.Values{"early_exit": {"100"}},
+			want: &spec.Params{
+				IsEarlyExit: true,
+				MaxBytes:    100000000,
+			},
+			wantErr: false,
+		},
 		{
 			name:    "invalid-param",
 			values:  url.Values{"early_exit": {"123"}},
@@ -41,10 +55,37 @@ func Test_validateEarlyExit(t *testing.T) {
 			want:    nil,
 			wantErr: true,
 		},
+		{
+			name:   "valid-time-param",
+			values: url.Values{"early_exit_time": {"5"}},
+			want: &spec.Params{
+				IsEarlyExit:       true,
+				EarlyExitAfter:    5 * time.Second,
+				EarlyExitMinBytes: 10000000,
+			},
+			wantErr: false,
+		},
+		{
+			name:    "invalid-time-param",
+			values:  url.Values{"early_exit_time": {"7"}},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:   "both-params",
+			values: url.Values{"early_exit": {"100"}, "early_exit_time": {"5"}},
+			want: &spec.Params{
+				IsEarlyExit:       true,
+				MaxBytes:          100000000,
+				EarlyExitAfter:    5 * time.Second,
+				EarlyExitMinBytes: 10000000,
+			},
+			wantErr: false,
+		},
 		{
 			name:   "absent-param",
 			values: url.Values{"foo": {"bar"}},
-			want: &sender.Params{
+			want: &spec.Params{
 				IsEarlyExit: false,
 				MaxBytes:    0,
 			},
@@ -53,7 +94,7 @@ func Test_validateEarlyExit(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := validateEarlyExit(tt.values)
+			got, err := validateEarlyExit(tt.values, policy)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("validateEarlyExit() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -64,3 +105,194 @@ func Test_validateEarlyExit(t *testing.T) {
 		})
 	}
 }
+
+func Test_validateDuration(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  url.Values
+		min     time.Duration
+		max     time.Duration
+		want    time.Duration
+		wantErr bool
+	}{
+		{
+			name:   "absent-param",
+			values: url.Values{"foo": {"bar"}},
+			min:    spec.DefaultRuntime,
+			max:    spec.DefaultRuntime,
+			want:   spec.DefaultRuntime,
+		},
+		{
+			name:   "absent-param-below-min",
+			values: url.Values{},
+			min:    20 * time.Second,
+			max:    60 * time.Second,
+			want:   20 * time.Second,
+		},
+		{
+			name:   "absent-param-above-max",
+			values: url.Values{},
+			min:    2 * time.Second,
+			max:    5 * time.Second,
+			want:   5 * time.Second,
+		},
+		{
+			name:   "valid-param",
+			values: url.Values{"duration": {"5"}},
+			min:    5 * time.Second,
+			max:    60 * time.Second,
+			want:   5 * time.Second,
+		},
+		{
+			name:   "valid-upper-bound",
+			values: url.Values{"duration": {"60"}},
+			min:    5 * time.Second,
+			max:    60 * time.Second,
+			want:   60 * time.Second,
+		},
+		{
+			name:    "too-short",
+			values:  url.Values{"duration": {"4"}},
+			min:     5 * time.Second,
+			max:     60 * time.Second,
+			wantErr: true,
+		},
+		{
+			name:    "too-long",
+			values:  url.Values{"duration": {"61"}},
+			min:     5 * time.Second,
+			max:     60 * time.Second,
+			wantErr: true,
+		},
+		{
+			name:    "not-a-number",
+			values:  url.Values{"duration": {"ten"}},
+			min:     5 * time.Second,
+			max:     60 * time.Second,
+			wantErr: true,
+		},
+		{
+			name:    "missing-value",
+			values:  url.Values{"duration": {""}},
+			min:     5 * time.Second,
+			max:     60 * time.Second,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := validateDuration(tt.values, tt.min, tt.max)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateDuration() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("validateDuration() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_validateLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  url.Values
+		want    spec.SubtestKind
+		wantErr bool
+	}{
+		{
+			name:   "absent-param",
+			values: url.Values{"foo": {"bar"}},
+			want:   spec.SubtestDownload,
+		},
+		{
+			name:   "download",
+			values: url.Values{"load": {"download"}},
+			want:   spec.SubtestDownload,
+		},
+		{
+			name:   "upload",
+			values: url.Values{"load": {"upload"}},
+			want:   spec.SubtestUpload,
+		},
+		{
+			name:    "invalid",
+			values:  url.Values{"load": {"responsiveness"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := validateLoad(tt.values)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateLoad() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("validateLoad() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_validateCongestionControl(t *testing.T) {
+	allowed := []string{"bbr", "cubic"}
+	tests := []struct {
+		name    string
+		values  url.Values
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "absent-param-uses-first",
+			values: url.Values{"foo": {"bar"}},
+			want:   "bbr",
+		},
+		{
+			name:   "allowed",
+			values: url.Values{"cc": {"cubic"}},
+			want:   "cubic",
+		},
+		{
+			name:    "not-allowed",
+			values:  url.Values{"cc": {"reno"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := validateCongestionControl(tt.values, allowed)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateCongestionControl() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("validateCongestionControl() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+type fakeSink struct{}
+
+func (fakeSink) Save(*sink.Result) error { return nil }
+
+func Test_withoutFileSinks(t *testing.T) {
+	rs := sink.Multi{
+		&results.FileSink{DataDir: "a"},
+		fakeSink{},
+		sink.Multi{&ndt5.FileSink{DataDir: "a/ndt5"}},
+		sink.Validated{ResultSink: sink.Multi{&results.FileSink{DataDir: "a/"}, fakeSink{}}},
+		&results.FileSink{DataDir: "b"},
+	}
+	want := sink.Multi{
+		sink.Multi{},
+		fakeSink{},
+		sink.Multi{&ndt5.FileSink{DataDir: "a/ndt5"}},
+		sink.Validated{ResultSink: sink.Multi{sink.Multi{}, fakeSink{}}},
+		&results.FileSink{DataDir: "b"},
+	}
+	if got := withoutFileSinks(rs, "a"); !reflect.DeepEqual(got, want) {
+		t.Errorf("withoutFileSinks() = %#v, want %#v", got, want)
+	}
+}
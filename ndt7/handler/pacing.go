@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/m-lab/ndt-server/logging"
+	"github.com/m-lab/ndt-server/ndt7/model"
+	"github.com/m-lab/ndt-server/ndt7/spec"
+	"github.com/m-lab/ndt-server/ndt7/transport"
+	"github.com/m-lab/ndt-server/netx"
+)
+
+// validateMaxRate verifies and returns the "max_rate" parameter, i.e., the cap
+// requested by the client, in Mbit/s. The parameter is only accepted for
+// downloads and from clients presenting a valid access token, as indicated by
+// hasToken. It returns zero when the client did not request a cap.
+func validateMaxRate(values url.Values, kind spec.SubtestKind, hasToken bool) (float64, error) {
+	if !values.Has(spec.MaxRateParameterName) {
+		return 0, nil
+	}
+	value := values.Get(spec.MaxRateParameterName)
+	if kind != spec.SubtestDownload {
+		return 0, fmt.Errorf("%s parameter is only supported by the download subtest", spec.MaxRateParameterName)
+	}
+	if !hasToken {
+		return 0, fmt.Errorf("%s parameter requires an access token", spec.MaxRateParameterName)
+	}
+	mbps, err := strconv.ParseFloat(value, 64)
+	if err != nil || mbps <= 0 {
+		return 0, fmt.Errorf("invalid %s parameter value %s", spec.MaxRateParameterName, value)
+	}
+	return mbps, nil
+}
+
+// pacingCap returns the cap on the pacing rate of a subtest of the given kind,
+// where requested is the cap requested by the client in Mbit/s, or zero. The
+// lowest of the operator and of the requested caps applies. It returns nil
+// when the subtest is not capped.
+func (h *Handler) pacingCap(kind spec.SubtestKind, requested float64) *model.PacingCap {
+	if kind != spec.SubtestDownload {
+		return nil
+	}
+	mbps, source := h.MaxDownloadRate, model.PacingCapOperator
+	if requested > 0 && (mbps <= 0 || requested < mbps) {
+		mbps, source = requested, model.PacingCapClient
+	}
+	if mbps <= 0 {
+		return nil
+	}
+	return &model.PacingCap{
+		Rate:   int64(mbps * 1000000 / 8), // Convert Mbit/s to bytes/s.
+		Source: source,
+	}
+}
+
+// setPacingCap applies pc to the TCP connection of conn. It returns false if
+// the cap could not be applied.
+//
+// NOTE: with HTTP/2, the cap applies to all streams of the connection.
+func setPacingCap(conn transport.Conn, pc *model.PacingCap) bool {
+	ci := netx.ToConnInfo(conn.UnderlyingConn())
+	if err := ci.SetMaxPacingRate(pc.Rate); err != nil {
+		logging.Logger.WithError(err).Warn("setPacingCap: SetMaxPacingRate failed")
+		return false
+	}
+	return true
+}
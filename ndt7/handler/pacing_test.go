@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/m-lab/ndt-server/ndt7/model"
+	"github.com/m-lab/ndt-server/ndt7/spec"
+)
+
+func Test_validateMaxRate(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   url.Values
+		kind     spec.SubtestKind
+		hasToken bool
+		want     float64
+		wantErr  bool
+	}{
+		{
+			name:   "absent-param",
+			values: url.Values{"foo": {"bar"}},
+			kind:   spec.SubtestDownload,
+		},
+		{
+			name:     "success",
+			values:   url.Values{"max_rate": {"12.5"}},
+			kind:     spec.SubtestDownload,
+			hasToken: true,
+			want:     12.5,
+		},
+		{
+			name:    "missing-token",
+			values:  url.Values{"max_rate": {"10"}},
+			kind:    spec.SubtestDownload,
+			wantErr: true,
+		},
+		{
+			name:     "upload",
+			values:   url.Values{"max_rate": {"10"}},
+			kind:     spec.SubtestUpload,
+			hasToken: true,
+			wantErr:  true,
+		},
+		{
+			name:     "invalid",
+			values:   url.Values{"max_rate": {"-1"}},
+			kind:     spec.SubtestDownload,
+			hasToken: true,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := validateMaxRate(tt.values, tt.kind, tt.hasToken)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateMaxRate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("validateMaxRate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandler_pacingCap(t *testing.T) {
+	tests := []struct {
+		name      string
+		operator  float64
+		kind      spec.SubtestKind
+		requested float64
+		want      *model.PacingCap
+	}{
+		{
+			name: "uncapped",
+			kind: spec.SubtestDownload,
+		},
+		{
+			name:     "operator",
+			operator: 100,
+			kind:     spec.SubtestDownload,
+			want:     &model.PacingCap{Rate: 12500000, Source: model.PacingCapOperator},
+		},
+		{
+			name:      "token",
+			kind:      spec.SubtestDownload,
+			requested: 8,
+			want:      &model.PacingCap{Rate: 1000000, Source: model.PacingCapClient},
+		},
+		{
+			name:      "token-lower-than-operator",
+			operator:  100,
+			kind:      spec.SubtestDownload,
+			requested: 8,
+			want:      &model.PacingCap{Rate: 1000000, Source: model.PacingCapClient},
+		},
+		{
+			name:      "token-higher-than-operator",
+			operator:  8,
+			kind:      spec.SubtestDownload,
+			requested: 100,
+			want:      &model.PacingCap{Rate: 1000000, Source: model.PacingCapOperator},
+		},
+		{
+			name:     "upload",
+			operator: 100,
+			kind:     spec.SubtestUpload,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &Handler{MaxDownloadRate: tt.operator}
+			if got := h.pacingCap(tt.kind, tt.requested); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Handler.pacingCap() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/m-lab/ndt-server/logging"
+	"github.com/m-lab/ndt-server/ndt7/results"
+	"github.com/m-lab/ndt-server/ndt7/spec"
+	"github.com/m-lab/ndt-server/sink"
+)
+
+// Result returns the results archived in DataDir for the UUID in the "uuid"
+// parameter, as a JSON array of sink.Result, oldest first. Only the results
+// written within ResultRetention are returned.
+func (h *Handler) Result(rw http.ResponseWriter, req *http.Request) {
+	if h.ResultRetention <= 0 {
+		http.NotFound(rw, req)
+		return
+	}
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	uuid := req.URL.Query().Get(spec.ResultUUIDParameterName)
+	names, err := results.Find(h.DataDir, uuid, time.Now().Add(-h.ResultRetention))
+	if err == results.ErrInvalidUUID {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		logging.Logger.WithError(err).Warn("Result: results.Find failed")
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if len(names) == 0 {
+		http.NotFound(rw, req)
+		return
+	}
+	found := make([]*sink.Result, 0, len(names))
+	for _, name := range names {
+		r, err := results.ReadFile(name, uuid)
+		if err != nil {
+			logging.Logger.WithError(err).Warn("Result: results.ReadFile failed: " + name)
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		found = append(found, r)
+	}
+	b, err := json.Marshal(found)
+	if err != nil {
+		logging.Logger.WithError(err).Warn("Result: json.Marshal failed")
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Write(b)
+}
@@ -0,0 +1,210 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/m-lab/go/prometheusx"
+	"github.com/m-lab/ndt-server/data"
+	"github.com/m-lab/ndt-server/ndt7/model"
+	"github.com/m-lab/ndt-server/ndt7/spec"
+	"github.com/m-lab/ndt-server/ndt7/summary"
+	"github.com/m-lab/ndt-server/version"
+)
+
+var (
+	errSessionMismatch = errors.New("session parameters do not match other streams")
+	errSessionFull     = errors.New("session already started or has all its streams")
+)
+
+// sessionParams contains the client parameters of a multi-stream subtest.
+type sessionParams struct {
+	ID      string
+	Streams int
+}
+
+// validateSession verifies and returns the multi-stream session parameters.
+// It returns nil parameters for single-stream requests. Multi-stream requests
+// are rejected when maxStreams is zero.
+func validateSession(values url.Values, maxStreams int) (*sessionParams, error) {
+	if !values.Has(spec.SessionParameterName) && !values.Has(spec.StreamsParameterName) {
+		return nil, nil
+	}
+	if maxStreams == 0 {
+		return nil, errors.New("multi-stream tests are not enabled")
+	}
+	id := values.Get(spec.SessionParameterName)
+	if id == "" || len(id) > spec.MaxSessionIDLength {
+		return nil, fmt.Errorf("invalid %s parameter value %q", spec.SessionParameterName, id)
+	}
+	value := values.Get(spec.StreamsParameterName)
+	streams, err := strconv.Atoi(value)
+	if err != nil || streams < 1 || streams > maxStreams {
+		return nil, fmt.Errorf("invalid %s parameter value %q", spec.StreamsParameterName, value)
+	}
+	return &sessionParams{ID: id, Streams: streams}, nil
+}
+
+// session coordinates the streams of a multi-stream subtest.
+type session struct {
+	key    string
+	params sessionParams
+	kind   spec.SubtestKind
+
+	// ready is closed once every stream has joined, or after the join timeout.
+	ready chan struct{}
+	once  sync.Once
+	timer *time.Timer
+
+	// The streams of a session are admitted together. The first stream sets
+	// the following fields, which are read-only afterwards.
+	admitOnce sync.Once
+	release   func()
+	queueWait time.Duration
+	admitErr  error
+
+	// The following fields are protected by the sessionRegistry mutex.
+	started bool
+	joined  int
+	results []*data.NDT7Result
+	proto   string
+}
+
+// start releases all streams waiting on the ready channel.
+func (s *session) start() {
+	s.once.Do(func() {
+		close(s.ready)
+	})
+}
+
+// sessionRegistry tracks the active multi-stream sessions. The zero value is
+// ready to use.
+type sessionRegistry struct {
+	mu     sync.Mutex
+	active map[string]*session
+}
+
+// join adds a stream to the session identified by key, creating the session
+// if necessary. All streams of a session must agree on the subtest kind and
+// the number of streams.
+func (r *sessionRegistry) join(key string, kind spec.SubtestKind, params sessionParams) (*session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.active == nil {
+		r.active = make(map[string]*session)
+	}
+	s, ok := r.active[key]
+	if !ok {
+		s = &session{
+			key:    key,
+			params: params,
+			kind:   kind,
+			ready:  make(chan struct{}),
+		}
+		s.timer = time.AfterFunc(spec.SessionJoinTimeout, func() {
+			r.mu.Lock()
+			s.started = true
+			r.mu.Unlock()
+			s.start()
+		})
+		r.active[key] = s
+	}
+	if s.kind != kind || s.params != params {
+		return nil, errSessionMismatch
+	}
+	if s.started || s.joined == params.Streams {
+		return nil, errSessionFull
+	}
+	s.joined++
+	if s.joined == params.Streams {
+		s.started = true
+		s.timer.Stop()
+		s.start()
+	}
+	return s, nil
+}
+
+// finish records the result and protocol label of a stream. The result is nil
+// and the label is empty if the stream failed before producing them. Once every
+// stream that joined the session has finished, finish removes the session and
+// returns all non-nil results and releases the admission slots of the session.
+// Otherwise, finish returns nil.
+func (r *sessionRegistry) finish(s *session, result *data.NDT7Result, proto string) []*data.NDT7Result {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s.joined--
+	if result != nil {
+		s.results = append(s.results, result)
+	}
+	if proto != "" {
+		s.proto = proto
+	}
+	if s.joined > 0 {
+		return nil
+	}
+	if r.active[s.key] == s {
+		delete(r.active, s.key)
+	}
+	if s.release != nil {
+		s.release()
+	}
+	s.timer.Stop()
+	s.start()
+	return s.results
+}
+
+// sessionKey returns the registry key for the session id requested by the
+// client. Sessions are scoped to the client IP so that clients cannot join
+// each other's sessions.
+func sessionKey(req *http.Request, id string) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	return host + "/" + id
+}
+
+// aggregateSession combines the per-stream results of a multi-stream subtest
+// into a single result. The returned rate is the sum of the stream rates.
+func aggregateSession(s *session, results []*data.NDT7Result) (*data.NDT7Result, float64) {
+	first := results[0]
+	agg := &data.NDT7Result{
+		GitShortCommit: prometheusx.GitShortCommit,
+		Version:        version.Version,
+		ClientIP:       first.ClientIP,
+		ClientPort:     first.ClientPort,
+		ServerIP:       first.ServerIP,
+		ServerPort:     first.ServerPort,
+		StartTime:      first.StartTime,
+		EndTime:        first.EndTime,
+		Session: &model.SessionData{
+			ID:      s.params.ID,
+			Streams: s.params.Streams,
+		},
+	}
+	var rate float64
+	for _, r := range results {
+		if r.StartTime.Before(agg.StartTime) {
+			agg.StartTime = r.StartTime
+		}
+		if r.EndTime.After(agg.EndTime) {
+			agg.EndTime = r.EndTime
+		}
+		switch {
+		case r.Download != nil:
+			agg.DownloadStreams = append(agg.DownloadStreams, r.Download)
+			rate += summary.DownloadRate(r.Download.ServerMeasurements)
+		case r.Upload != nil:
+			agg.UploadStreams = append(agg.UploadStreams, r.Upload)
+			rate += summary.UploadRate(r.Upload.ServerMeasurements)
+		}
+	}
+	agg.Session.AggregateThroughputMbps = rate
+	return agg, rate
+}
@@ -0,0 +1,183 @@
+package handler
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/m-lab/ndt-server/data"
+	"github.com/m-lab/ndt-server/ndt7/model"
+	"github.com/m-lab/ndt-server/ndt7/spec"
+)
+
+func Test_validateSession(t *testing.T) {
+	tests := []struct {
+		name       string
+		values     url.Values
+		maxStreams int
+		want       *sessionParams
+		wantErr    bool
+	}{
+		{
+			name:       "absent-params",
+			values:     url.Values{"foo": {"bar"}},
+			maxStreams: 4,
+		},
+		{
+			name:       "valid-params",
+			values:     url.Values{"session": {"abc"}, "streams": {"4"}},
+			maxStreams: 4,
+			want:       &sessionParams{ID: "abc", Streams: 4},
+		},
+		{
+			name:       "disabled",
+			values:     url.Values{"session": {"abc"}, "streams": {"2"}},
+			maxStreams: 0,
+			wantErr:    true,
+		},
+		{
+			name:       "too-many-streams",
+			values:     url.Values{"session": {"abc"}, "streams": {"5"}},
+			maxStreams: 4,
+			wantErr:    true,
+		},
+		{
+			name:       "missing-streams",
+			values:     url.Values{"session": {"abc"}},
+			maxStreams: 4,
+			wantErr:    true,
+		},
+		{
+			name:       "missing-session",
+			values:     url.Values{"streams": {"2"}},
+			maxStreams: 4,
+			wantErr:    true,
+		},
+		{
+			name:       "session-too-long",
+			values:     url.Values{"session": {strings.Repeat("a", spec.MaxSessionIDLength+1)}, "streams": {"2"}},
+			maxStreams: 4,
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := validateSession(tt.values, tt.maxStreams)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateSession() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("validateSession() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func isReady(s *session) bool {
+	select {
+	case <-s.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+func Test_sessionRegistry(t *testing.T) {
+	r := &sessionRegistry{}
+	params := sessionParams{ID: "abc", Streams: 2}
+
+	s1, err := r.join("key", spec.SubtestDownload, params)
+	if err != nil {
+		t.Fatalf("join() unexpected error = %v", err)
+	}
+	if isReady(s1) {
+		t.Errorf("session started before all streams joined")
+	}
+	if _, err := r.join("key", spec.SubtestUpload, params); err != errSessionMismatch {
+		t.Errorf("join() with different kind error = %v, want %v", err, errSessionMismatch)
+	}
+	s2, err := r.join("key", spec.SubtestDownload, params)
+	if err != nil {
+		t.Fatalf("join() unexpected error = %v", err)
+	}
+	if s1 != s2 {
+		t.Errorf("join() returned different sessions for the same key")
+	}
+	if !isReady(s1) {
+		t.Errorf("session did not start after all streams joined")
+	}
+	if _, err := r.join("key", spec.SubtestDownload, params); err != errSessionFull {
+		t.Errorf("join() on full session error = %v, want %v", err, errSessionFull)
+	}
+
+	r1 := &data.NDT7Result{}
+	if got := r.finish(s1, r1, "ndt7+ws"); got != nil {
+		t.Errorf("finish() = %v, want nil before all streams finish", got)
+	}
+	got := r.finish(s1, nil, "")
+	if !reflect.DeepEqual(got, []*data.NDT7Result{r1}) {
+		t.Errorf("finish() = %v, want %v", got, []*data.NDT7Result{r1})
+	}
+	if s1.proto != "ndt7+ws" {
+		t.Errorf("finish() proto = %q, want %q", s1.proto, "ndt7+ws")
+	}
+	if len(r.active) != 0 {
+		t.Errorf("finish() did not remove the session")
+	}
+}
+
+func Test_sessionRegistryJoinTimeout(t *testing.T) {
+	r := &sessionRegistry{}
+	params := sessionParams{ID: "abc", Streams: 2}
+	s, err := r.join("key", spec.SubtestUpload, params)
+	if err != nil {
+		t.Fatalf("join() unexpected error = %v", err)
+	}
+	select {
+	case <-s.ready:
+	case <-time.After(2 * spec.SessionJoinTimeout):
+		t.Fatalf("session did not start after the join timeout")
+	}
+	if _, err := r.join("key", spec.SubtestUpload, params); err != errSessionFull {
+		t.Errorf("join() on started session error = %v, want %v", err, errSessionFull)
+	}
+	r.finish(s, nil, "")
+}
+
+func Test_aggregateSession(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	measurements := []model.Measurement{
+		{AppInfo: &model.AppInfo{NumBytes: 100, ElapsedTime: 10}},
+	}
+	s := &session{params: sessionParams{ID: "abc", Streams: 3}}
+	results := []*data.NDT7Result{
+		{
+			ClientIP:  "1.2.3.4",
+			StartTime: start.Add(time.Second),
+			EndTime:   start.Add(10 * time.Second),
+			Download:  &model.ArchivalData{UUID: "a", ServerMeasurements: measurements},
+		},
+		{
+			ClientIP:  "1.2.3.4",
+			StartTime: start,
+			EndTime:   start.Add(11 * time.Second),
+			Download:  &model.ArchivalData{UUID: "b", ServerMeasurements: measurements},
+		},
+	}
+	agg, rate := aggregateSession(s, results)
+	if rate != 160 {
+		t.Errorf("aggregateSession() rate = %v, want 160", rate)
+	}
+	if agg.Session.AggregateThroughputMbps != rate || agg.Session.ID != "abc" || agg.Session.Streams != 3 {
+		t.Errorf("aggregateSession() session = %+v", agg.Session)
+	}
+	if len(agg.DownloadStreams) != 2 || agg.Download != nil || agg.UploadStreams != nil {
+		t.Errorf("aggregateSession() streams = %v, %v", agg.DownloadStreams, agg.UploadStreams)
+	}
+	if !agg.StartTime.Equal(start) || !agg.EndTime.Equal(start.Add(11*time.Second)) {
+		t.Errorf("aggregateSession() times = %v - %v", agg.StartTime, agg.EndTime)
+	}
+}
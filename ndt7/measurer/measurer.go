@@ -6,7 +6,6 @@ import (
 	"context"
 	"time"
 
-	"github.com/gorilla/websocket"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 
@@ -14,6 +13,7 @@ import (
 	"github.com/m-lab/ndt-server/logging"
 	"github.com/m-lab/ndt-server/ndt7/model"
 	"github.com/m-lab/ndt-server/ndt7/spec"
+	"github.com/m-lab/ndt-server/ndt7/transport"
 	"github.com/m-lab/ndt-server/netx"
 )
 
@@ -25,49 +25,82 @@ var (
 		},
 		[]string{"status", "error"},
 	)
+	CongestionControl = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ndt7_measurer_congestion_control_total",
+			Help: "A counter of the congestion control algorithms requested and in effect.",
+		},
+		[]string{"requested", "effective"},
+	)
 )
 
 // Measurer performs measurements
 type Measurer struct {
-	conn   *websocket.Conn
+	conn   transport.Conn
 	uuid   string
 	ticker *memoryless.Ticker
 }
 
 // New creates a new measurer instance
-func New(conn *websocket.Conn, UUID string) *Measurer {
+func New(conn transport.Conn, UUID string) *Measurer {
 	return &Measurer{
 		conn: conn,
 		uuid: UUID,
 	}
 }
 
-func (m *Measurer) getSocketAndPossiblyEnableBBR() (netx.ConnInfo, error) {
-	ci := netx.ToConnInfo(m.conn.UnderlyingConn())
-	err := ci.EnableBBR()
-	success := "true"
-	errstr := ""
+// SetCongestionControl sets the named congestion control algorithm on the TCP
+// connection of conn and returns the algorithm in effect afterwards, which is
+// read back from the socket. Failing to set the algorithm is not fatal: the
+// connection keeps its current algorithm. The returned name is empty when the
+// algorithm cannot be read, e.g., on non-Linux systems.
+//
+// NOTE: with HTTP/2, the algorithm applies to all streams of the connection.
+func SetCongestionControl(conn transport.Conn, name string) string {
+	ci := netx.ToConnInfo(conn.UnderlyingConn())
+	err := ci.SetCongestionControl(name)
 	if err != nil {
-		success = "false"
-		errstr = err.Error()
 		uuid, _ := ci.GetUUID() // to log error with uuid.
-		logging.Logger.WithError(err).Warn("Cannot enable BBR: " + uuid)
+		logging.Logger.WithError(err).Warn("Cannot set congestion control " + name + ": " + uuid)
 		// FALLTHROUGH
 	}
-	BBREnabled.WithLabelValues(success, errstr).Inc()
-	return ci, nil
+	if name == "bbr" {
+		success := "true"
+		errstr := ""
+		if err != nil {
+			success = "false"
+			errstr = err.Error()
+		}
+		BBREnabled.WithLabelValues(success, errstr).Inc()
+	}
+	effective, err := ci.GetCongestionControl()
+	if err != nil {
+		logging.Logger.WithError(err).Warn("Cannot read congestion control")
+		effective = ""
+	}
+	CongestionControl.WithLabelValues(name, effective).Inc()
+	return effective
 }
 
 func measure(measurement *model.Measurement, ci netx.ConnInfo, elapsed time.Duration) {
 	// Implementation note: we always want to sample BBR before TCPInfo so we
 	// will know from TCPInfo if the connection has been closed.
 	t := int64(elapsed / time.Microsecond)
-	bbrinfo, tcpInfo, err := ci.ReadInfo()
+	ccinfo, tcpInfo, err := ci.ReadInfo()
 	if err == nil {
+		// BBRInfo is empty unless BBR is in use.
 		measurement.BBRInfo = &model.BBRInfo{
-			BBRInfo:     bbrinfo,
 			ElapsedTime: t,
 		}
+		if ccinfo.BBR != nil {
+			measurement.BBRInfo.BBRInfo = ccinfo.BBR.BBRInfo
+		}
+		if ccinfo.Algorithm != "" {
+			measurement.CCInfo = &model.CCInfo{
+				CCInfo:      ccinfo,
+				ElapsedTime: t,
+			}
+		}
 		measurement.TCPInfo = &model.TCPInfo{
 			LinuxTCPInfo: tcpInfo,
 			ElapsedTime:  t,
@@ -81,11 +114,7 @@ func (m *Measurer) loop(ctx context.Context, timeout time.Duration, dst chan<- m
 	defer close(dst)
 	measurerctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
-	ci, err := m.getSocketAndPossiblyEnableBBR()
-	if err != nil {
-		logging.Logger.WithError(err).Warn("getSocketAndPossiblyEnableBBR failed")
-		return
-	}
+	ci := netx.ToConnInfo(m.conn.UnderlyingConn())
 	start := time.Now()
 	connectionInfo := &model.ConnectionInfo{
 		Client:    m.conn.RemoteAddr().String(),
@@ -1,11 +1,14 @@
 package metrics
 
 import (
+	"crypto/tls"
 	"strings"
 
-	"github.com/gorilla/websocket"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/m-lab/ndt-server/ndt7/transport"
+	"github.com/m-lab/ndt-server/netx"
 )
 
 // Metrics for exporting to prometheus to aid in server monitoring.
@@ -41,9 +44,16 @@ var (
 )
 
 // ConnLabel infers an appropriate label for the websocket protocol.
-func ConnLabel(conn *websocket.Conn) string {
-	// NOTE: this isn't perfect, but it is simple and a) works for production deployments,
-	// and 2) will work for custom deployments with ports having the same suffix, e.g. 4433, 8080.
+func ConnLabel(conn transport.Conn) string {
+	switch conn.UnderlyingConn().(type) {
+	case *tls.Conn:
+		return "ndt7+wss"
+	case *netx.Conn:
+		return "ndt7+ws"
+	}
+	// NOTE: for other connections, fall back to the port. This isn't perfect, but it is
+	// simple and a) works for production deployments, and 2) will work for custom
+	// deployments with ports having the same suffix, e.g. 4433, 8080.
 	if strings.HasSuffix(conn.LocalAddr().String(), "443") {
 		return "ndt7+wss"
 	}
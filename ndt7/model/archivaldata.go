@@ -3,6 +3,7 @@ package model
 import (
 	"time"
 
+	"github.com/m-lab/ndt-server/bbr"
 	"github.com/m-lab/ndt-server/metadata"
 	"github.com/m-lab/tcp-info/inetdiag"
 	"github.com/m-lab/tcp-info/tcp"
@@ -17,6 +18,122 @@ type ArchivalData struct {
 	ClientMeasurements []Measurement
 	ClientMetadata     []metadata.NameValue `json:",omitempty"`
 	ServerMetadata     []metadata.NameValue `json:",omitempty"`
+	// HTTPVersion is the version of the HTTP protocol used to establish the
+	// WebSocket connection, e.g., "HTTP/1.1" or "HTTP/2.0" for WebSockets over
+	// HTTP/2 (RFC 8441).
+	HTTPVersion string `json:",omitempty"`
+	// SocketUUID is the UUID of the TCP connection carrying an HTTP/2 stream.
+	// Other subtests may run on streams of the same connection, so they share
+	// its socket-level measurements, i.e., TCPInfo, BBRInfo, CongestionControl
+	// and PacingCap. It is empty when the subtest had its own connection.
+	SocketUUID string `json:",omitempty"`
+	// CongestionControl is the TCP congestion control algorithm in effect for
+	// the subtest, as read back from the socket, e.g., "bbr" or "cubic". It is
+	// empty when the algorithm cannot be read.
+	CongestionControl string `json:",omitempty"`
+	// PacingCap is the cap on the pacing rate applied to a download. It is nil
+	// when the rate of the subtest was not capped.
+	PacingCap *PacingCap `json:",omitempty"`
+	// RequestedDuration is the subtest runtime requested by the client using the
+	// "duration" parameter. It is zero when the client did not request a runtime.
+	RequestedDuration time.Duration `json:",omitempty"`
+	// ActualDuration is the time the server spent running the subtest.
+	ActualDuration time.Duration
+	// QueueWait is the time the subtest waited for admission before running.
+	// It is zero when the subtest was admitted right away.
+	QueueWait time.Duration `json:",omitempty"`
+	// WSPingSamples contains every application-level RTT sample measured by
+	// the server using WebSocket ping and pong messages.
+	WSPingSamples []WSPingInfo `json:",omitempty"`
+	// Responsiveness summarizes the results of a responsiveness subtest.
+	Responsiveness *ResponsivenessSummary `json:",omitempty"`
+	// EarlyExit contains the early exit policy of the server and the early
+	// exit thresholds requested by the client.
+	EarlyExit *EarlyExitData `json:",omitempty"`
+	// TerminationReason is the reason why the server ended the subtest. It is
+	// empty when the subtest ended for other reasons, e.g., an error.
+	TerminationReason string `json:",omitempty"`
+	// Summary is the final result of a download or upload subtest, which was
+	// sent to the client before closing the connection.
+	Summary *Summary `json:",omitempty"`
+}
+
+// Recorder is passed the measurements saved in ArchivalData as they are
+// collected, e.g., to write them to disk incrementally. Implementations must be
+// safe for concurrent use, since server and client measurements are collected
+// by different goroutines.
+type Recorder interface {
+	RecordServerMeasurement(m Measurement)
+	RecordClientMeasurement(m Measurement)
+}
+
+// Reasons why the server ends a subtest.
+const (
+	// TerminationRuntime means that the subtest ran for its full runtime.
+	TerminationRuntime = "runtime"
+	// TerminationEarlyExit means that the subtest reached the early exit
+	// thresholds requested by the client.
+	TerminationEarlyExit = "early_exit"
+	// TerminationStableRate means that the measured rate has converged.
+	TerminationStableRate = "stable_rate"
+)
+
+// Sources of the cap on the pacing rate of a download.
+const (
+	// PacingCapOperator means that the cap is configured by the server operator.
+	PacingCapOperator = "operator"
+	// PacingCapClient means that the cap is requested by the client with the
+	// max_rate parameter. Access tokens do not carry a rate, so the cap is
+	// chosen by the client, not by whoever issued its token.
+	PacingCapClient = "client"
+)
+
+// PacingCap describes the cap on the pacing rate applied to a download.
+type PacingCap struct {
+	// Rate is the maximum pacing rate applied to the socket with
+	// SO_MAX_PACING_RATE, in bytes per second.
+	Rate int64
+	// Source is who set the cap, i.e., PacingCapOperator or PacingCapClient.
+	Source string
+}
+
+// EarlyExitPolicy describes the early exit thresholds that clients may request.
+// The policy is configured by the server operator and advertised to clients.
+type EarlyExitPolicy struct {
+	// MB lists the accepted values of the "early_exit" parameter. The subtest
+	// ends once the requested number of MB have been transferred.
+	MB []int64
+	// Time lists the accepted time-based thresholds. The "early_exit_time"
+	// parameter selects a threshold by its Seconds value.
+	Time []EarlyExitTime `json:",omitempty"`
+}
+
+// EarlyExitTime is a time-based early exit threshold. The subtest ends after
+// Seconds, once MinMB have been transferred.
+type EarlyExitTime struct {
+	Seconds int64
+	MinMB   int64
+}
+
+// EarlyExitData records the early exit policy used for a subtest and the
+// thresholds requested by the client. Unused thresholds are zero.
+type EarlyExitData struct {
+	Policy   *EarlyExitPolicy
+	MaxBytes int64         `json:",omitempty"`
+	After    time.Duration `json:",omitempty"`
+	MinBytes int64         `json:",omitempty"`
+}
+
+// SessionData describes a multi-stream subtest, where a client opens several
+// parallel connections sharing the same session identifier.
+type SessionData struct {
+	// ID is the session identifier provided by the client.
+	ID string
+	// Streams is the number of streams announced by the client.
+	Streams int
+	// AggregateThroughputMbps is the sum of the mean throughput of every
+	// stream, in Mbit/s.
+	AggregateThroughputMbps float64
 }
 
 // The Measurement struct contains measurement results. This structure is
@@ -26,7 +143,12 @@ type Measurement struct {
 	AppInfo        *AppInfo        `json:",omitempty"`
 	ConnectionInfo *ConnectionInfo `json:",omitempty"`
 	BBRInfo        *BBRInfo        `json:",omitempty"`
+	CCInfo         *CCInfo         `json:",omitempty"`
 	TCPInfo        *TCPInfo        `json:",omitempty"`
+	WSPingInfo     *WSPingInfo     `json:",omitempty"`
+
+	ResponsivenessInfo *ResponsivenessInfo `json:",omitempty"`
+	Summary            *Summary            `json:",omitempty"`
 }
 
 // AppInfo contains an application level measurement. This structure is
@@ -53,6 +175,76 @@ type BBRInfo struct {
 	ElapsedTime int64
 }
 
+// The CCInfo struct contains the congestion control information of the
+// connection, tagged by the kind of information exported by the congestion
+// control algorithm, e.g., BBR, Vegas or DCTCP. Unlike BBRInfo, it includes
+// the variables of BBR versions newer than BBRv1. This structure is an
+// extension to the ndt7 specification. Variables here have the same
+// measurement unit that is used by the Linux kernel.
+type CCInfo struct {
+	bbr.CCInfo
+	ElapsedTime int64
+}
+
+// The Summary struct contains the final result of a download or upload subtest,
+// as computed by the server. The server includes it in the last measurement
+// message, so that clients can show the same result that the server archives.
+// This structure is an extension to the ndt7 specification.
+type Summary struct {
+	// UUID is the UUID of the subtest.
+	UUID string
+	// MeanThroughputMbps is the mean throughput of the subtest, in Mbit/s.
+	MeanThroughputMbps float64
+	// MinRTT is the minimum RTT measured by TCP, in microseconds. It is zero
+	// when TCPInfo is unavailable.
+	MinRTT int64
+	// RetransmissionRate is the fraction of the bytes sent by the server that
+	// were retransmitted. It is zero for uploads, where the server only sends
+	// acknowledgements.
+	RetransmissionRate float64
+}
+
+// The WSPingInfo struct contains an application-level RTT sample measured using
+// WebSocket ping and pong messages. This structure is an extension to the ndt7
+// specification.
+type WSPingInfo struct {
+	// LastRTT is the RTT of the sample, measured in microseconds.
+	LastRTT int64
+	// ElapsedTime is when the sample was collected, measured in microseconds
+	// since the beginning of the test.
+	ElapsedTime int64
+}
+
+// ResponsivenessInfo is included in the measurement messages sent during a
+// responsiveness subtest. This structure is an extension to the ndt7
+// specification.
+type ResponsivenessInfo struct {
+	// Phase is "idle" while measuring the idle latency, "loaded" while
+	// measuring the latency under load, and "done" in the last message.
+	Phase string
+	// Summary is only included in the last message.
+	Summary *ResponsivenessSummary `json:",omitempty"`
+}
+
+// ResponsivenessSummary compares the latency of an idle link with the latency
+// of the same link while it is saturated by a download or an upload. All RTT
+// values are measured in microseconds.
+type ResponsivenessSummary struct {
+	// Load is the subtest that saturated the link, "download" or "upload".
+	Load string
+	// IdleRTT and LoadedRTT are the median application-level RTTs measured
+	// using WebSocket ping and pong messages.
+	IdleRTT   int64
+	LoadedRTT int64
+	// IdleTCPRTT and LoadedTCPRTT are the median TCP_INFO smoothed RTTs.
+	IdleTCPRTT   int64
+	LoadedTCPRTT int64
+	// LatencyIncrease is the difference between LoadedRTT and IdleRTT.
+	LatencyIncrease int64
+	// RPM is the number of round trips per minute under load.
+	RPM float64
+}
+
 // The TCPInfo struct contains information measured using TCP_INFO. This
 // structure is described in the ndt7 specification.
 type TCPInfo struct {
@@ -1,39 +1,222 @@
 package ndt7test
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"fmt"
+	"math/big"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/m-lab/access/controller"
+	"github.com/m-lab/access/token"
 	"github.com/m-lab/go/testingx"
 	"github.com/m-lab/ndt-server/ndt7/handler"
 	"github.com/m-lab/ndt-server/ndt7/spec"
+	"github.com/m-lab/ndt-server/ndt7/transport"
 	"github.com/m-lab/ndt-server/netx"
 	"github.com/m-lab/tcp-info/eventsocket"
 )
 
+// Machine is the machine name that the servers created by NewServer expect in
+// the audience of access tokens.
+const Machine = "mlab1-ndt7test.measurement-lab.org"
+
+// issuer is the issuer of access tokens accepted by the access controller,
+// i.e., the locate service.
+const issuer = "locate"
+
 // NewNDT7Server creates a local httptest server capable of running an ndt7
-// measurement in unittests.
+// measurement in unittests. Use NewServer to test TLS and access tokens,
+// which are always required to fetch archived results.
 func NewNDT7Server(t *testing.T) (*handler.Handler, *httptest.Server) {
-	dir := t.TempDir()
-	// TODO: add support for token verifiers.
-	// TODO: add support for TLS server.
-	ndt7Handler := &handler.Handler{DataDir: dir, Events: eventsocket.NullServer()}
-	ndt7Mux := http.NewServeMux()
-	ndt7Mux.Handle(spec.DownloadURLPath, http.HandlerFunc(ndt7Handler.Download))
-	ndt7Mux.Handle(spec.UploadURLPath, http.HandlerFunc(ndt7Handler.Upload))
-
-	// Create unstarted so we can setup a custom netx.Listener.
-	ts := httptest.NewUnstartedServer(ndt7Mux)
-	listener, err := net.Listen("tcp", ":0")
-	testingx.Must(t, err, "failed to allocate a listening tcp socket")
-	addr := (listener.(*net.TCPListener)).Addr().(*net.TCPAddr)
+	ndt7Handler := &handler.Handler{DataDir: t.TempDir(), Events: eventsocket.NullServer()}
+	_, verifier := newKeys(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	ts := newServer(t, newMux(ctx, ndt7Handler, verifier), ":0")
 	// Populate insecure port value with dynamic port.
-	ndt7Handler.InsecurePort = fmt.Sprintf(":%d", addr.Port)
-	ts.Listener = netx.NewListener(listener.(*net.TCPListener))
+	ndt7Handler.InsecurePort = fmt.Sprintf(":%d", port(ts))
 	// Now that the test server has our custom listener, start it.
 	ts.Start()
 	return ndt7Handler, ts
 }
+
+// Server is a local ndt7 server serving the same handler over cleartext and
+// TLS, and verifying access tokens like ndt-server.
+type Server struct {
+	Handler *handler.Handler
+	// WSURL and WSSURL are the URLs of the cleartext and TLS servers, e.g.,
+	// "ws://127.0.0.1:43210".
+	WSURL  string
+	WSSURL string
+	// RootCAs contains the throwaway CA that issued the certificate of the TLS
+	// server, for use in the tls.Config of clients.
+	RootCAs *x509.CertPool
+
+	signer *token.Signer
+}
+
+// NewServer creates a local ndt7 server with a cleartext and a TLS listener.
+// The subtests verify access tokens signed by the key pair of the server for
+// Machine. When tokenRequired is true, the subtests also reject requests
+// without tokens. Like ndt-server, archived results always require a token.
+// The servers are closed when the test ends.
+func NewServer(t *testing.T, tokenRequired bool) *Server {
+	signer, verifier := newKeys(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	// Enforce tokens on all subtests, like ndt-server. There are no tx limits.
+	ac, _ := controller.Setup(ctx, verifier, tokenRequired, Machine, controller.Paths{}, controller.Paths{
+		spec.DownloadURLPath:       true,
+		spec.UploadURLPath:         true,
+		spec.ResponsivenessURLPath: true,
+	})
+	s := &Server{
+		Handler: &handler.Handler{DataDir: t.TempDir(), Events: eventsocket.NullServer()},
+		signer:  signer,
+	}
+	h := ac.Then(newMux(ctx, s.Handler, verifier))
+
+	ws := newServer(t, h, "127.0.0.1:0")
+	ws.Start()
+	t.Cleanup(ws.Close)
+	s.Handler.InsecurePort = fmt.Sprintf(":%d", port(ws))
+	s.WSURL = "ws://" + strings.TrimPrefix(ws.URL, "http://")
+
+	wss := newServer(t, h, "127.0.0.1:0")
+	var cert tls.Certificate
+	cert, s.RootCAs = newCertificate(t)
+	wss.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	wss.StartTLS()
+	t.Cleanup(wss.Close)
+	s.Handler.SecurePort = fmt.Sprintf(":%d", port(wss))
+	s.WSSURL = "wss://" + strings.TrimPrefix(wss.URL, "https://")
+	return s
+}
+
+// Token returns a valid access token for Machine.
+func (s *Server) Token(t *testing.T) string {
+	return s.sign(t, Machine, time.Now().Add(time.Minute))
+}
+
+// ExpiredToken returns an access token for Machine that has expired.
+func (s *Server) ExpiredToken(t *testing.T) string {
+	return s.sign(t, Machine, time.Now().Add(-time.Minute))
+}
+
+// WrongMachineToken returns an access token that is valid for another machine.
+func (s *Server) WrongMachineToken(t *testing.T) string {
+	return s.sign(t, "mlab2-ndt7test.measurement-lab.org", time.Now().Add(time.Minute))
+}
+
+func (s *Server) sign(t *testing.T, machine string, expiry time.Time) string {
+	tok, err := s.signer.Sign(jwt.Claims{
+		Issuer:   issuer,
+		Subject:  "ndt7test",
+		Audience: jwt.Audience{machine},
+		Expiry:   jwt.NewNumericDate(expiry),
+	})
+	testingx.Must(t, err, "failed to sign token")
+	return tok
+}
+
+// newMux returns a mux serving the ndt7 endpoints of h. Like ndt-server, the
+// archived results require an access token verified by verifier.
+func newMux(ctx context.Context, h *handler.Handler, verifier *token.Verifier) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle(spec.DownloadURLPath, http.HandlerFunc(h.Download))
+	mux.Handle(spec.UploadURLPath, http.HandlerFunc(h.Upload))
+	mux.Handle(spec.ResponsivenessURLPath, http.HandlerFunc(h.Responsiveness))
+	mux.Handle(spec.EarlyExitPolicyURLPath, http.HandlerFunc(h.EarlyExitPolicy))
+	acResult, _ := controller.Setup(ctx, verifier, true, Machine, controller.Paths{}, controller.Paths{
+		spec.ResultURLPath: true,
+	})
+	mux.Handle(spec.ResultURLPath, acResult.Then(http.HandlerFunc(h.Result)))
+	return mux
+}
+
+// newServer creates an unstarted httptest server for h listening on addr with
+// a netx.Listener, which the ndt7 handler requires.
+func newServer(t *testing.T, h http.Handler, addr string) *httptest.Server {
+	ts := httptest.NewUnstartedServer(h)
+	listener, err := net.Listen("tcp", addr)
+	testingx.Must(t, err, "failed to allocate a listening tcp socket")
+	ts.Listener.Close()
+	ts.Listener = netx.NewListener(listener.(*net.TCPListener))
+	ts.Config.ConnContext = transport.ConnContext
+	return ts
+}
+
+// port returns the port of the listener of ts.
+func port(ts *httptest.Server) int {
+	return ts.Listener.Addr().(*net.TCPAddr).Port
+}
+
+// newKeys generates a key pair for signing and verifying access tokens.
+func newKeys(t *testing.T) (*token.Signer, *token.Verifier) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	testingx.Must(t, err, "failed to generate token key")
+	key := jose.JSONWebKey{Key: priv, KeyID: "ndt7test", Algorithm: string(jose.EdDSA), Use: "sig"}
+	privJSON, err := key.MarshalJSON()
+	testingx.Must(t, err, "failed to marshal private key")
+	pubJSON, err := key.Public().MarshalJSON()
+	testingx.Must(t, err, "failed to marshal public key")
+	signer, err := token.NewSigner(privJSON)
+	testingx.Must(t, err, "failed to create signer")
+	verifier, err := token.NewVerifier(pubJSON)
+	testingx.Must(t, err, "failed to create verifier")
+	return signer, verifier
+}
+
+// newCertificate generates a throwaway CA and a certificate for 127.0.0.1 and
+// localhost issued by it. It returns the certificate and a pool containing the
+// CA.
+func newCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	testingx.Must(t, err, "failed to generate CA key")
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ndt7test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	testingx.Must(t, err, "failed to create CA certificate")
+	ca, err = x509.ParseCertificate(caDER)
+	testingx.Must(t, err, "failed to parse CA certificate")
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	testingx.Must(t, err, "failed to generate key")
+	leaf := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, leaf, ca, &key.PublicKey, caKey)
+	testingx.Must(t, err, "failed to create certificate")
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
@@ -2,15 +2,16 @@ package ndt7test
 
 import (
 	"context"
-	"net/http"
-	"net/url"
+	"crypto/tls"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
-	"github.com/gorilla/websocket"
 	"github.com/m-lab/go/testingx"
+	"github.com/m-lab/ndt-server/ndt7/client"
+	"github.com/m-lab/ndt-server/ndt7/model"
 	"github.com/m-lab/ndt-server/ndt7/spec"
 )
 
@@ -18,22 +19,17 @@ func TestNewNDT7Server(t *testing.T) {
 	// Create the ndt7test server.
 	h, srv := NewNDT7Server(t)
 	defer os.RemoveAll(h.DataDir)
+	h.MinRuntime, h.MaxRuntime = time.Second, spec.DefaultRuntime
 
-	// Prepare to run a simplified download with ndt7test server.
-	URL, _ := url.Parse(srv.URL)
-	URL.Scheme = "ws"
-	URL.Path = spec.DownloadURLPath
-	headers := http.Header{}
-	headers.Add("Sec-WebSocket-Protocol", spec.SecWebSocketProtocol)
-	headers.Add("User-Agent", "fake-user-agent")
-	ctx := context.Background()
-	dialer := websocket.Dialer{HandshakeTimeout: 10 * time.Second}
-	conn, _, err := dialer.DialContext(ctx, URL.String(), headers)
+	// Run a short download with ndt7test server.
+	c, err := client.New(srv.URL)
+	testingx.Must(t, err, "failed to create client")
+	c.UserAgent = "fake-user-agent"
+	c.Duration = time.Second
+	st, err := c.Download(context.Background())
 	testingx.Must(t, err, "failed to dial websocket ndt7 test")
-	err = simpleDownload(ctx, t, conn)
-	if err != nil && !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
-		testingx.Must(t, err, "failed to download")
-	}
+	_, err = st.Wait()
+	testingx.Must(t, err, "failed to download")
 
 	// Allow the server time to save the file, the client may stop before the server does.
 	time.Sleep(1 * time.Second)
@@ -45,22 +41,30 @@ func TestNewNDT7Server(t *testing.T) {
 	}
 }
 
-func simpleDownload(ctx context.Context, t *testing.T, conn *websocket.Conn) error {
-	defer conn.Close()
-	wholectx, cancel := context.WithTimeout(ctx, spec.MaxRuntime)
-	defer cancel()
-	conn.SetReadLimit(spec.MaxMessageSize)
-	err := conn.SetReadDeadline(time.Now().Add(spec.MaxRuntime))
-	testingx.Must(t, err, "failed to set read deadline")
+func TestNewServer(t *testing.T) {
+	s := NewServer(t, false)
+	if !strings.HasPrefix(s.WSURL, "ws://127.0.0.1:") || !strings.HasPrefix(s.WSSURL, "wss://127.0.0.1:") {
+		t.Fatalf("wrong URLs: %s, %s", s.WSURL, s.WSSURL)
+	}
+	if s.Handler.InsecurePort == s.Handler.SecurePort {
+		t.Errorf("same port for WS and WSS: %s", s.Handler.SecurePort)
+	}
+
+	// The certificate of the TLS server is only trusted with RootCAs.
+	c, err := client.New(s.WSSURL)
+	testingx.Must(t, err, "failed to create client")
+	if _, err := c.Download(context.Background()); err == nil {
+		t.Error("Download() succeeded without the CA of the server")
+	}
+	c.Dialer.TLSClientConfig = &tls.Config{RootCAs: s.RootCAs}
+	c.EarlyExit = 1
+	s.Handler.EarlyExit = &model.EarlyExitPolicy{MB: []int64{1}}
+	st, err := c.Download(context.Background())
+	testingx.Must(t, err, "failed to start download")
+	_, err = st.Wait()
+	testingx.Must(t, err, "failed to download")
 
-	var total int64
-	// WARNING: this is not a reference client.
-	for wholectx.Err() == nil {
-		_, mdata, err := conn.ReadMessage()
-		if err != nil {
-			return err
-		}
-		total += int64(len(mdata))
+	if s.Token(t) == s.ExpiredToken(t) || s.Token(t) == s.WrongMachineToken(t) {
+		t.Error("tokens are not different")
 	}
-	return nil
 }
@@ -3,16 +3,36 @@ package ping
 
 import (
 	"encoding/json"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/m-lab/ndt-server/ndt7/model"
+	"github.com/m-lab/ndt-server/ndt7/transport"
 )
 
+// Pinger sends the ticks elapsed since the start of a test as ping messages
+// and parses the ticks echoed back by the client in pong messages. Since the
+// ticks are relative to a single time.Time reference, they are monotonic.
+//
+// A Pinger may be used concurrently by the sender and the receiver.
+type Pinger struct {
+	start  time.Time
+	latest atomic.Pointer[model.WSPingInfo]
+
+	mu      sync.Mutex
+	samples []model.WSPingInfo
+}
+
+// New creates a Pinger using start as the time reference for the test.
+func New(start time.Time) *Pinger {
+	return &Pinger{start: start}
+}
+
 // SendTicks sends the current ticks as a ping message.
-func SendTicks(conn *websocket.Conn, deadline time.Time) error {
-	// TODO(bassosimone): when we'll have a unique base time.Time reference for
-	// the whole test, we should use that, since UnixNano() is not monotonic.
-	ticks := int64(time.Now().UnixNano())
+func (p *Pinger) SendTicks(conn transport.Conn, deadline time.Time) error {
+	ticks := int64(time.Since(p.start))
 	data, err := json.Marshal(ticks)
 	if err == nil {
 		err = conn.WriteControl(websocket.PingMessage, data, deadline)
@@ -20,13 +40,35 @@ func SendTicks(conn *websocket.Conn, deadline time.Time) error {
 	return err
 }
 
-func ParseTicks(s string) (d int64, err error) {
-	// TODO(bassosimone): when we'll have a unique base time.Time reference for
-	// the whole test, we should use that, since UnixNano() is not monotonic.
+// ParseTicks parses the ticks in a pong message and returns the corresponding
+// RTT sample. The sample is also saved as the latest sample and appended to
+// the samples returned by Samples.
+func (p *Pinger) ParseTicks(s string) (model.WSPingInfo, error) {
 	var prev int64
-	err = json.Unmarshal([]byte(s), &prev)
-	if err == nil {
-		d = (int64(time.Now().UnixNano()) - prev)
+	err := json.Unmarshal([]byte(s), &prev)
+	if err != nil {
+		return model.WSPingInfo{}, err
 	}
-	return
+	elapsed := time.Since(p.start)
+	sample := model.WSPingInfo{
+		LastRTT:     int64((elapsed - time.Duration(prev)) / time.Microsecond),
+		ElapsedTime: int64(elapsed / time.Microsecond),
+	}
+	p.latest.Store(&sample)
+	p.mu.Lock()
+	p.samples = append(p.samples, sample)
+	p.mu.Unlock()
+	return sample, nil
+}
+
+// Latest returns the latest RTT sample, or nil if there are none yet.
+func (p *Pinger) Latest() *model.WSPingInfo {
+	return p.latest.Load()
+}
+
+// Samples returns a copy of the RTT samples parsed so far, in order.
+func (p *Pinger) Samples() []model.WSPingInfo {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]model.WSPingInfo(nil), p.samples...)
 }
@@ -0,0 +1,38 @@
+package ping
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestPinger_ParseTicks(t *testing.T) {
+	p := New(time.Now().Add(-time.Second))
+	if p.Latest() != nil {
+		t.Fatalf("Latest() = %v, want nil before any sample", p.Latest())
+	}
+	// Pretend the ping was sent 100ms ago.
+	b, err := json.Marshal(int64(time.Since(p.start) - 100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sample, err := p.ParseTicks(string(b))
+	if err != nil {
+		t.Fatalf("ParseTicks() unexpected error = %v", err)
+	}
+	if sample.LastRTT < 100000 || sample.LastRTT > 200000 {
+		t.Errorf("ParseTicks() LastRTT = %d, want ~100000", sample.LastRTT)
+	}
+	if sample.ElapsedTime < 1000000 {
+		t.Errorf("ParseTicks() ElapsedTime = %d, want >= 1000000", sample.ElapsedTime)
+	}
+	if got := p.Latest(); got == nil || *got != sample {
+		t.Errorf("Latest() = %v, want %v", got, sample)
+	}
+	if got := p.Samples(); len(got) != 1 || got[0] != sample {
+		t.Errorf("Samples() = %v, want [%v]", got, sample)
+	}
+	if _, err := p.ParseTicks("not-a-number"); err == nil {
+		t.Errorf("ParseTicks() expected error for invalid ticks")
+	}
+}
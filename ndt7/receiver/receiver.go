@@ -6,7 +6,9 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -15,6 +17,7 @@ import (
 	"github.com/m-lab/ndt-server/ndt7/model"
 	"github.com/m-lab/ndt-server/ndt7/ping"
 	"github.com/m-lab/ndt-server/ndt7/spec"
+	"github.com/m-lab/ndt-server/ndt7/transport"
 )
 
 type receiverKind int
@@ -25,16 +28,17 @@ const (
 )
 
 func start(
-	ctx context.Context, conn *websocket.Conn, kind receiverKind,
-	data *model.ArchivalData,
+	ctx context.Context, conn transport.Conn, kind receiverKind,
+	data *model.ArchivalData, rec model.Recorder, maxRuntime time.Duration,
+	pinger *ping.Pinger, received *atomic.Int64,
 ) {
 	logging.Logger.Debug("receiver: start")
 	proto := ndt7metrics.ConnLabel(conn)
 	defer logging.Logger.Debug("receiver: stop")
 	conn.SetReadLimit(spec.MaxMessageSize)
-	receiverctx, cancel := context.WithTimeout(ctx, spec.MaxRuntime)
+	receiverctx, cancel := context.WithTimeout(ctx, maxRuntime)
 	defer cancel()
-	err := conn.SetReadDeadline(time.Now().Add(spec.MaxRuntime)) // Liveness!
+	err := conn.SetReadDeadline(time.Now().Add(maxRuntime)) // Liveness!
 	if err != nil {
 		logging.Logger.WithError(err).Warn("receiver: conn.SetReadDeadline failed")
 		ndt7metrics.ClientReceiverErrors.WithLabelValues(
@@ -42,10 +46,10 @@ func start(
 		return
 	}
 	conn.SetPongHandler(func(s string) error {
-		rtt, err := ping.ParseTicks(s)
+		sample, err := pinger.ParseTicks(s)
 		if err == nil {
-			rtt /= int64(time.Millisecond)
-			logging.Logger.Debugf("receiver: ApplicationLevel RTT: %d ms", rtt)
+			data.WSPingSamples = append(data.WSPingSamples, sample)
+			logging.Logger.Debugf("receiver: ApplicationLevel RTT: %d ms", sample.LastRTT/1000)
 		} else {
 			ndt7metrics.ClientReceiverErrors.WithLabelValues(
 				proto, fmt.Sprint(kind), "ping-parse-ticks").Inc()
@@ -69,7 +73,15 @@ func start(
 					proto, fmt.Sprint(kind), "wrong-message-type").Inc()
 				return // Unexpected message type
 			default:
-				// NOTE: this is the bulk upload path. In this case, the mdata is not used.
+				// NOTE: this is the bulk upload path. In this case, the mdata is not
+				// used, but we drain it to count the application-level bytes.
+				n, err := io.Copy(io.Discard, r)
+				received.Add(n)
+				if err != nil {
+					ndt7metrics.ClientReceiverErrors.WithLabelValues(
+						proto, fmt.Sprint(kind), "read-binary-message").Inc()
+					return
+				}
 				continue // No further processing required
 			}
 		}
@@ -89,24 +101,29 @@ func start(
 			return
 		}
 		data.ClientMeasurements = append(data.ClientMeasurements, measurement)
+		if rec != nil {
+			rec.RecordClientMeasurement(measurement)
+		}
 	}
 	ndt7metrics.ClientReceiverErrors.WithLabelValues(
 		proto, fmt.Sprint(kind), "receiver-context-expired").Inc()
 }
 
 // StartDownloadReceiverAsync starts the receiver in a background goroutine and
-// saves messages received from the client in the given archival data. The
-// returned context may be used to detect when the receiver has completed.
+// saves messages received from the client in the given archival data. When rec
+// is not nil, the receiver also passes the messages to rec. The receiver also
+// saves the RTT samples parsed by pinger from pong messages. The returned
+// context may be used to detect when the receiver has completed.
 //
 // This receiver will not tolerate receiving binary messages. It will terminate
 // early if such a message is received.
 //
-// Liveness guarantee: the goroutine will always terminate after a MaxRuntime
+// Liveness guarantee: the goroutine will always terminate after the maxRuntime
 // timeout.
-func StartDownloadReceiverAsync(ctx context.Context, conn *websocket.Conn, data *model.ArchivalData) context.Context {
+func StartDownloadReceiverAsync(ctx context.Context, conn transport.Conn, data *model.ArchivalData, rec model.Recorder, maxRuntime time.Duration, pinger *ping.Pinger) context.Context {
 	ctx2, cancel2 := context.WithCancel(ctx)
 	go func() {
-		start(ctx2, conn, downloadReceiver, data)
+		start(ctx2, conn, downloadReceiver, data, rec, maxRuntime, pinger, nil)
 		cancel2()
 	}()
 	return ctx2
@@ -114,11 +131,12 @@ func StartDownloadReceiverAsync(ctx context.Context, conn *websocket.Conn, data
 
 // StartUploadReceiverAsync is like StartDownloadReceiverAsync except that it
 // tolerates incoming binary messages, sent by "upload" measurement clients to
-// create network load, and therefore must be allowed.
-func StartUploadReceiverAsync(ctx context.Context, conn *websocket.Conn, data *model.ArchivalData) context.Context {
+// create network load, and therefore must be allowed. The size of every binary
+// message is added to received, which must not be nil.
+func StartUploadReceiverAsync(ctx context.Context, conn transport.Conn, data *model.ArchivalData, rec model.Recorder, maxRuntime time.Duration, pinger *ping.Pinger, received *atomic.Int64) context.Context {
 	ctx2, cancel2 := context.WithCancel(ctx)
 	go func() {
-		start(ctx2, conn, uploadReceiver, data)
+		start(ctx2, conn, uploadReceiver, data, rec, maxRuntime, pinger, received)
 		cancel2()
 	}()
 	return ctx2
@@ -0,0 +1,246 @@
+// Package responsiveness implements the ndt7 responsiveness subtest, which
+// compares the latency of an idle link with the latency of the same link while
+// it is saturated by a download or by an upload.
+package responsiveness
+
+import (
+	"context"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/m-lab/ndt-server/logging"
+	"github.com/m-lab/ndt-server/ndt7/bulk"
+	"github.com/m-lab/ndt-server/ndt7/closer"
+	"github.com/m-lab/ndt-server/ndt7/measurer"
+	ndt7metrics "github.com/m-lab/ndt-server/ndt7/metrics"
+	"github.com/m-lab/ndt-server/ndt7/model"
+	"github.com/m-lab/ndt-server/ndt7/ping"
+	"github.com/m-lab/ndt-server/ndt7/receiver"
+	"github.com/m-lab/ndt-server/ndt7/spec"
+	"github.com/m-lab/ndt-server/ndt7/transport"
+)
+
+const (
+	phaseIdle   = "idle"
+	phaseLoaded = "loaded"
+	phaseDone   = "done"
+)
+
+// Do implements the responsiveness subtest. The ctx argument is the parent
+// context for the subtest. The conn argument is the open WebSocket connection.
+// The data argument is the archival data where results are saved. The params
+// argument contains the client parameters for this subtest, where params.Load
+// selects whether the server (spec.SubtestDownload) or the client
+// (spec.SubtestUpload) saturates the link during the loaded phase. All
+// arguments are owned by the caller of this function.
+func Do(ctx context.Context, conn transport.Conn, data *model.ArchivalData, params *spec.Params) error {
+	start := time.Now()
+	pinger := ping.New(start)
+	maxRuntime := spec.MaxRuntimeFor(params.Runtime)
+
+	// Receive and save client-provided measurements in data. During uploads,
+	// the receiver also counts the bytes sent by the client.
+	var received atomic.Int64
+	var recv context.Context
+	if params.Load == spec.SubtestUpload {
+		recv = receiver.StartUploadReceiverAsync(ctx, conn, data, params.Recorder, maxRuntime, pinger, &received)
+	} else {
+		recv = receiver.StartDownloadReceiverAsync(ctx, conn, data, params.Recorder, maxRuntime, pinger)
+	}
+
+	err := run(ctx, conn, data, params, pinger, start, &received)
+
+	// Block on the receiver completing to guarantee that access to data is synchronous.
+	<-recv.Done()
+	if data.Responsiveness == nil {
+		// The subtest failed before sending the summary to the client.
+		data.Responsiveness = summarize(string(params.Load), params.Runtime/spec.ResponsivenessIdleFraction,
+			data.WSPingSamples, data.ServerMeasurements)
+	}
+	return err
+}
+
+// run sends measurement messages and pings to the client conn during both
+// phases and, if the server loads the link, binary messages during the loaded
+// phase. Each measurement message will also be saved to data.
+//
+// Liveness guarantee: run will not be stuck sending for more than the
+// MaxRuntime of the subtest. This is enforced by setting the write deadline.
+func run(
+	ctx context.Context, conn transport.Conn, data *model.ArchivalData,
+	params *spec.Params, pinger *ping.Pinger, start time.Time, received *atomic.Int64,
+) error {
+	logging.Logger.Debug("responsiveness: start")
+	proto := ndt7metrics.ConnLabel(conn)
+	direction := string(spec.SubtestResponsiveness)
+	idle := params.Runtime / spec.ResponsivenessIdleFraction
+
+	mr := measurer.New(conn, data.UUID)
+	src := mr.Start(ctx, params.Runtime)
+	defer logging.Logger.Debug("responsiveness: stop")
+	defer mr.Stop(src)
+
+	messages, err := bulk.NewSender()
+	if err != nil {
+		logging.Logger.WithError(err).Warn("responsiveness: bulk.NewSender failed")
+		ndt7metrics.ClientSenderErrors.WithLabelValues(
+			proto, direction, "make-prepared-message").Inc()
+		return err
+	}
+	deadline := time.Now().Add(spec.MaxRuntimeFor(params.Runtime))
+	err = conn.SetWriteDeadline(deadline) // Liveness!
+	if err != nil {
+		logging.Logger.WithError(err).Warn("responsiveness: conn.SetWriteDeadline failed")
+		ndt7metrics.ClientSenderErrors.WithLabelValues(
+			proto, direction, "set-write-deadline").Inc()
+		return err
+	}
+
+	data.StartTime = start.UTC()
+	defer func() {
+		data.EndTime = time.Now().UTC()
+		data.ActualDuration = time.Since(start)
+	}()
+	phase := func() string {
+		if time.Since(start) < idle {
+			return phaseIdle
+		}
+		return phaseLoaded
+	}
+	// measure handles a message from the measurer and returns true once the
+	// measurer has terminated.
+	measure := func(m model.Measurement, ok bool) (bool, error) {
+		if !ok {
+			// Send the summary to the client before closing. The receiver is still
+			// running, so use a snapshot of the samples parsed by pinger, and
+			// archive the same summary that the client gets.
+			summary := summarize(string(params.Load), idle, pinger.Samples(), data.ServerMeasurements)
+			data.Responsiveness = summary
+			final := model.Measurement{
+				ResponsivenessInfo: &model.ResponsivenessInfo{Phase: phaseDone, Summary: summary},
+			}
+			if err := conn.WriteJSON(final); err != nil {
+				logging.Logger.WithError(err).Warn("responsiveness: conn.WriteJSON failed")
+				ndt7metrics.ClientSenderErrors.WithLabelValues(
+					proto, direction, "write-json").Inc()
+				return true, err
+			}
+			data.TerminationReason = model.TerminationRuntime
+			closer.StartClosing(conn)
+			ndt7metrics.ClientSenderErrors.WithLabelValues(
+				proto, direction, "measurer-closed").Inc()
+			return true, nil
+		}
+		numBytes := messages.Sent()
+		if params.Load == spec.SubtestUpload {
+			numBytes = received.Load()
+		}
+		m.AppInfo = &model.AppInfo{
+			NumBytes:    numBytes,
+			ElapsedTime: int64(time.Since(start) / time.Microsecond),
+		}
+		m.WSPingInfo = pinger.Latest()
+		m.ResponsivenessInfo = &model.ResponsivenessInfo{Phase: phase()}
+		if err := conn.WriteJSON(m); err != nil {
+			logging.Logger.WithError(err).Warn("responsiveness: conn.WriteJSON failed")
+			ndt7metrics.ClientSenderErrors.WithLabelValues(
+				proto, direction, "write-json").Inc()
+			return true, err
+		}
+		// Only save measurements sent to the client.
+		data.ServerMeasurements = append(data.ServerMeasurements, m)
+		if params.Recorder != nil {
+			params.Recorder.RecordServerMeasurement(m)
+		}
+		if err := pinger.SendTicks(conn, deadline); err != nil {
+			logging.Logger.WithError(err).Warn("responsiveness: ping.SendTicks failed")
+			ndt7metrics.ClientSenderErrors.WithLabelValues(
+				proto, direction, "ping-send-ticks").Inc()
+			return true, err
+		}
+		return false, nil
+	}
+
+	for {
+		if params.Load == spec.SubtestUpload || phase() == phaseIdle {
+			// Nothing to send but measurements, so block on the measurer.
+			m, ok := <-src
+			if done, err := measure(m, ok); done {
+				return err
+			}
+			continue
+		}
+		select {
+		case m, ok := <-src:
+			if done, err := measure(m, ok); done {
+				return err
+			}
+		default:
+			if err := messages.Send(conn); err != nil {
+				logging.Logger.WithError(err).Warn(
+					"responsiveness: messages.Send failed")
+				ndt7metrics.ClientSenderErrors.WithLabelValues(
+					proto, direction, "write-prepared-message").Inc()
+				return err
+			}
+			// Scale the message size as the download sender does.
+			if err := messages.Scale(); err != nil {
+				logging.Logger.WithError(err).Warn("responsiveness: messages.Scale failed")
+				ndt7metrics.ClientSenderErrors.WithLabelValues(
+					proto, direction, "make-prepared-message").Inc()
+				return err
+			}
+		}
+	}
+}
+
+// median returns the median of values, or zero if values is empty.
+func median(values []int64) int64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	return values[len(values)/2]
+}
+
+// summarize computes the responsiveness summary from the ping samples and the
+// server measurements. Samples are assigned to the idle phase when they were
+// started within idle of the beginning of the subtest.
+func summarize(load string, idle time.Duration, samples []model.WSPingInfo, measurements []model.Measurement) *model.ResponsivenessSummary {
+	idleMicros := int64(idle / time.Microsecond)
+	var idleRTTs, loadedRTTs, idleTCPRTTs, loadedTCPRTTs []int64
+	for _, s := range samples {
+		// Classify the sample by the time at which the ping was sent.
+		if s.ElapsedTime-s.LastRTT < idleMicros {
+			idleRTTs = append(idleRTTs, s.LastRTT)
+		} else {
+			loadedRTTs = append(loadedRTTs, s.LastRTT)
+		}
+	}
+	for _, m := range measurements {
+		// NOTE: on non-Linux platforms, TCPInfo will be nil.
+		if m.TCPInfo == nil {
+			continue
+		}
+		if m.TCPInfo.ElapsedTime < idleMicros {
+			idleTCPRTTs = append(idleTCPRTTs, int64(m.TCPInfo.RTT))
+		} else {
+			loadedTCPRTTs = append(loadedTCPRTTs, int64(m.TCPInfo.RTT))
+		}
+	}
+	summary := &model.ResponsivenessSummary{
+		Load:         load,
+		IdleRTT:      median(idleRTTs),
+		LoadedRTT:    median(loadedRTTs),
+		IdleTCPRTT:   median(idleTCPRTTs),
+		LoadedTCPRTT: median(loadedTCPRTTs),
+	}
+	if summary.IdleRTT > 0 && summary.LoadedRTT > 0 {
+		summary.LatencyIncrease = summary.LoadedRTT - summary.IdleRTT
+	}
+	if summary.LoadedRTT > 0 {
+		summary.RPM = float64(time.Minute/time.Microsecond) / float64(summary.LoadedRTT)
+	}
+	return summary
+}
@@ -0,0 +1,66 @@
+package responsiveness
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/m-lab/ndt-server/ndt7/model"
+	"github.com/m-lab/tcp-info/tcp"
+)
+
+func Test_summarize(t *testing.T) {
+	tcpMeasurement := func(elapsed int64, rtt uint32) model.Measurement {
+		return model.Measurement{
+			TCPInfo: &model.TCPInfo{
+				LinuxTCPInfo: tcp.LinuxTCPInfo{RTT: rtt},
+				ElapsedTime:  elapsed,
+			},
+		}
+	}
+	tests := []struct {
+		name         string
+		samples      []model.WSPingInfo
+		measurements []model.Measurement
+		want         *model.ResponsivenessSummary
+	}{
+		{
+			name: "empty",
+			want: &model.ResponsivenessSummary{Load: "download"},
+		},
+		{
+			name: "idle-and-loaded",
+			samples: []model.WSPingInfo{
+				{LastRTT: 10000, ElapsedTime: 500000},
+				{LastRTT: 12000, ElapsedTime: 900000},
+				{LastRTT: 11000, ElapsedTime: 1500000},
+				// Sent during the idle phase, but received after it.
+				{LastRTT: 20000, ElapsedTime: 1010000},
+				{LastRTT: 50000, ElapsedTime: 3000000},
+				{LastRTT: 60000, ElapsedTime: 4000000},
+				{LastRTT: 40000, ElapsedTime: 5000000},
+			},
+			measurements: []model.Measurement{
+				tcpMeasurement(500000, 9000),
+				tcpMeasurement(3000000, 45000),
+			},
+			want: &model.ResponsivenessSummary{
+				Load:            "download",
+				IdleRTT:         12000,
+				LoadedRTT:       50000,
+				IdleTCPRTT:      9000,
+				LoadedTCPRTT:    45000,
+				LatencyIncrease: 38000,
+				RPM:             1200,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := summarize("download", time.Second, tt.samples, tt.measurements)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("summarize() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -6,10 +6,13 @@ import (
 	"io"
 	"os"
 	"path"
+	"sync"
 	"time"
 
 	"github.com/m-lab/ndt-server/logging"
 	"github.com/m-lab/ndt-server/ndt7/spec"
+	"github.com/m-lab/ndt-server/sink"
+	"github.com/m-lab/ndt-server/spool"
 )
 
 // File is the file where we save measurements.
@@ -20,29 +23,35 @@ type File struct {
 	// UUID is the UUID of this subtest.
 	UUID string
 
-	// fp is the underlying writer file.
-	fp *os.File
+	// fp is the underlying writer file, which gets its name once closed.
+	fp *spool.File
 
 	// gzip is an optional writer for compressed results.
 	gzip *gzip.Writer
+
+	// mu serializes the records of streaming results files.
+	mu sync.Mutex
+
+	// err is the first error that occurred while writing records.
+	err error
 }
 
 // newFile opens a measurements file in the current working
 // directory on success and returns an error on failure.
-func newFile(datadir, what, uuid string, compress bool) (*File, error) {
+func newFile(datadir, what, uuid, ext string, compress bool) (*File, error) {
 	timestamp := time.Now().UTC()
 	dir := path.Join(datadir, "ndt7", timestamp.Format("2006/01/02"))
 	err := os.MkdirAll(dir, 0755)
 	if err != nil {
 		return nil, err
 	}
-	name := dir + "/ndt7-" + what + "-" + timestamp.Format("20060102T150405.000000000Z") + "." + uuid + ".json"
+	name := dir + "/ndt7-" + what + "-" + timestamp.Format("20060102T150405.000000000Z") + "." + uuid + ext
 	if compress {
 		name += ".gz"
 	}
 	// My assumption here is that we have nanosecond precision and hence it's
-	// unlikely to have conflicts. If I'm wrong, O_EXCL will let us know.
-	fp, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	// unlikely to have conflicts. If I'm wrong, Close will let us know.
+	fp, err := spool.Create(name)
 	if err != nil {
 		return nil, err
 	}
@@ -54,7 +63,7 @@ func newFile(datadir, what, uuid string, compress bool) (*File, error) {
 	}
 	writer, err := gzip.NewWriterLevel(fp, gzip.BestSpeed)
 	if err != nil {
-		fp.Close()
+		fp.Abort()
 		return nil, err
 	}
 	return &File{
@@ -70,7 +79,7 @@ func newFile(datadir, what, uuid string, compress bool) (*File, error) {
 // data into and the what argument should indicate whether this is a
 // spec.SubtestDownload or a spec.SubtestUpload ndt7 measurement.
 func NewFile(uuid string, datadir string, what spec.SubtestKind, compress bool) (*File, error) {
-	fp, err := newFile(datadir, string(what), uuid, compress)
+	fp, err := newFile(datadir, string(what), uuid, ".json", compress)
 	if err != nil {
 		logging.Logger.WithError(err).Warn("newFile failed")
 		return nil, err
@@ -78,18 +87,23 @@ func NewFile(uuid string, datadir string, what spec.SubtestKind, compress bool)
 	return fp, nil
 }
 
-// Close closes the measurement file.
+// Close closes the measurement file, which only then appears in datadir.
 func (fp *File) Close() error {
 	if fp.gzip != nil {
 		err := fp.gzip.Close()
 		if err != nil {
-			fp.fp.Close()
+			fp.fp.Abort()
 			return err
 		}
 	}
 	return fp.fp.Close()
 }
 
+// Abort closes and removes the measurement file, e.g., after a write error.
+func (fp *File) Abort() {
+	fp.fp.Abort()
+}
+
 // WriteResult serializes |result| as JSON.
 func (fp *File) WriteResult(result interface{}) error {
 	data, err := json.Marshal(result)
@@ -99,3 +113,27 @@ func (fp *File) WriteResult(result interface{}) error {
 	_, err = fp.Writer.Write(data)
 	return err
 }
+
+// FileSink saves ndt7 results to files in DataDir, optionally compressed.
+type FileSink struct {
+	DataDir  string
+	Compress bool
+}
+
+// Dir implements sink.FileBacked.
+func (fs *FileSink) Dir() string {
+	return fs.DataDir
+}
+
+// Save implements sink.ResultSink.
+func (fs *FileSink) Save(r *sink.Result) error {
+	fp, err := NewFile(r.UUID, fs.DataDir, spec.SubtestKind(r.Kind), fs.Compress)
+	if err != nil {
+		return err
+	}
+	if err := fp.WriteResult(r.Data); err != nil {
+		fp.Abort()
+		return err
+	}
+	return fp.Close()
+}
@@ -0,0 +1,115 @@
+package results
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/m-lab/ndt-server/sink"
+)
+
+// ErrInvalidUUID is returned when a UUID cannot be part of a result file name.
+var ErrInvalidUUID = errors.New("invalid UUID")
+
+// MaxRetention is the longest period searched by Find. Every day of the period
+// costs a few globs per request.
+const MaxRetention = 7 * 24 * time.Hour
+
+// validUUID matches the UUIDs that we look for. Notably, it excludes path
+// separators and glob metacharacters.
+var validUUID = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// Find returns the names of the files in datadir containing the results for
+// uuid that were written after since, oldest first, looking back at most
+// MaxRetention. It finds both the ndt7 files written by NewFile and
+// NewStreamFile and the ndt5 files written by ndt5.FileSink in datadir/ndt5.
+func Find(datadir, uuid string, since time.Time) ([]string, error) {
+	if !validUUID.MatchString(uuid) {
+		return nil, ErrInvalidUUID
+	}
+	if earliest := time.Now().Add(-MaxRetention); since.Before(earliest) {
+		since = earliest
+	}
+	type match struct {
+		name    string
+		modTime time.Time
+	}
+	var matches []match
+	// Directories are named after the UTC date (ndt7) or the local date (ndt5)
+	// of the test, so look at one more day on both ends.
+	end := time.Now().AddDate(0, 0, 1)
+	for day := since.AddDate(0, 0, -1); !day.After(end); day = day.AddDate(0, 0, 1) {
+		date := day.Format("2006/01/02")
+		for _, pattern := range []string{
+			filepath.Join(datadir, "ndt7", date, "ndt7-*."+uuid+".json*"),
+			filepath.Join(datadir, "ndt5", date, uuid+".json"),
+		} {
+			names, err := filepath.Glob(pattern)
+			if err != nil {
+				return nil, err
+			}
+			for _, name := range names {
+				info, err := os.Stat(name)
+				if err != nil || info.ModTime().Before(since) {
+					continue
+				}
+				matches = append(matches, match{name, info.ModTime()})
+			}
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].modTime.Before(matches[j].modTime)
+	})
+	var names []string
+	for _, m := range matches {
+		names = append(names, m.name)
+	}
+	return names, nil
+}
+
+// ReadFile reads a file returned by Find. The result is decompressed and, for
+// streaming results files, rebuilt as if written by NewFile.
+func ReadFile(name, uuid string) (*sink.Result, error) {
+	r := &sink.Result{Protocol: "ndt5", UUID: uuid}
+	base := filepath.Base(name)
+	if strings.HasPrefix(base, "ndt7-") {
+		// ndt7-<kind>-<timestamp>.<uuid>.json...
+		r.Protocol = "ndt7"
+		r.Kind, _, _ = strings.Cut(strings.TrimPrefix(base, "ndt7-"), "-")
+	}
+	if strings.Contains(base, ".jsonl") {
+		result, _, err := ReadStreamFile(name)
+		if err != nil {
+			return nil, err
+		}
+		r.Data = result
+		return r, nil
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var rd io.Reader = f
+	if strings.HasSuffix(name, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		rd = gz
+	}
+	var data json.RawMessage
+	if err := json.NewDecoder(rd).Decode(&data); err != nil {
+		return nil, err
+	}
+	r.Data = data
+	return r, nil
+}
@@ -0,0 +1,81 @@
+package results
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/m-lab/ndt-server/data"
+	"github.com/m-lab/ndt-server/ndt7/model"
+	"github.com/m-lab/ndt-server/ndt7/spec"
+)
+
+func writeFile(t *testing.T, datadir, uuid string, kind spec.SubtestKind, compress bool) {
+	fp, err := NewFile(uuid, datadir, kind, compress)
+	if err != nil {
+		t.Fatalf("NewFile() error = %v", err)
+	}
+	result := &data.NDT7Result{Download: &model.ArchivalData{UUID: uuid}}
+	if err := fp.WriteResult(result); err != nil {
+		t.Fatalf("WriteResult() error = %v", err)
+	}
+	if err := fp.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+}
+
+func TestFind(t *testing.T) {
+	datadir := t.TempDir()
+	writeFile(t, datadir, "abc_123", spec.SubtestDownload, true)
+	writeFile(t, datadir, "abc_123", spec.SubtestUpload, false)
+	writeFile(t, datadir, "other", spec.SubtestDownload, true)
+	ndt5dir := filepath.Join(datadir, "ndt5", time.Now().Format("2006/01/02"))
+	if err := os.MkdirAll(ndt5dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(ndt5dir, "abc_123.json"), []byte(`{"GitShortCommit":"x"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := Find(datadir, "abc_123", time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("Find() error = %v", err)
+	}
+	if len(names) != 3 {
+		t.Fatalf("Find() = %v, want 3 files", names)
+	}
+	kinds := map[string]bool{}
+	for _, name := range names {
+		r, err := ReadFile(name, "abc_123")
+		if err != nil {
+			t.Fatalf("ReadFile(%s) error = %v", name, err)
+		}
+		if _, err := json.Marshal(r); err != nil {
+			t.Errorf("ReadFile(%s) returned invalid JSON: %v", name, err)
+		}
+		kinds[r.Protocol+"/"+r.Kind] = true
+	}
+	if !kinds["ndt7/download"] || !kinds["ndt7/upload"] || !kinds["ndt5/"] {
+		t.Errorf("ReadFile() kinds = %v", kinds)
+	}
+
+	// Results older than the retention window are not returned.
+	names, err = Find(datadir, "abc_123", time.Now().Add(time.Minute))
+	if err != nil || len(names) != 0 {
+		t.Errorf("Find() = %v, %v, want no files", names, err)
+	}
+	// Results older than MaxRetention are never returned.
+	old := time.Now().Add(-MaxRetention - time.Hour)
+	if err := os.Chtimes(filepath.Join(ndt5dir, "abc_123.json"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	names, err = Find(datadir, "abc_123", time.Now().AddDate(-1, 0, 0))
+	if err != nil || len(names) != 2 {
+		t.Errorf("Find() = %v, %v, want 2 files", names, err)
+	}
+	if _, err := Find(datadir, "../abc", time.Now()); err != ErrInvalidUUID {
+		t.Errorf("Find() error = %v, want %v", err, ErrInvalidUUID)
+	}
+}
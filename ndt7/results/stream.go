@@ -0,0 +1,248 @@
+package results
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/m-lab/ndt-server/data"
+	"github.com/m-lab/ndt-server/logging"
+	"github.com/m-lab/ndt-server/ndt7/model"
+	"github.com/m-lab/ndt-server/ndt7/spec"
+	"github.com/m-lab/ndt-server/spool"
+)
+
+// Record is a line of a streaming results file. Exactly one field is set.
+//
+// A streaming results file starts with a Header record, written when the
+// subtest starts, continues with a record for every server and client
+// measurement, as they are collected, and ends with a Summary record, written
+// when the subtest ends.
+type Record struct {
+	// Header is the result at the start of the subtest.
+	Header *data.NDT7Result `json:",omitempty"`
+	// ServerMeasurement is a measurement of the server.
+	ServerMeasurement *model.Measurement `json:",omitempty"`
+	// ClientMeasurement is a measurement received from the client.
+	ClientMeasurement *model.Measurement `json:",omitempty"`
+	// Summary is the result at the end of the subtest, without the server and
+	// client measurements, which are saved in the previous records.
+	Summary *data.NDT7Result `json:",omitempty"`
+}
+
+// NewStreamFile is like NewFile, except that the file is written incrementally
+// as JSON Lines, with one Record per line. Use WriteHeader when the subtest
+// starts, use the file as the model.Recorder of the subtest, and end with
+// WriteSummary. Like every results file, it has a temporary name until Close,
+// so that files of subtests still running are never collected. If the server
+// dies before Close, Recover gives the file its final name.
+func NewStreamFile(uuid string, datadir string, what spec.SubtestKind, compress bool) (*File, error) {
+	fp, err := newFile(datadir, string(what), uuid, ".jsonl", compress)
+	if err != nil {
+		logging.Logger.WithError(err).Warn("newFile failed")
+		return nil, err
+	}
+	return fp, nil
+}
+
+// writeRecord appends r to the file. The record is flushed, so that it is
+// preserved in the temporary file if the process dies before the end of the
+// subtest.
+func (fp *File) writeRecord(r *Record) error {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	if fp.err != nil {
+		return fp.err
+	}
+	b, err := json.Marshal(r)
+	if err == nil {
+		_, err = fp.Writer.Write(append(b, '\n'))
+	}
+	if err == nil && fp.gzip != nil {
+		err = fp.gzip.Flush()
+	}
+	if err != nil {
+		// Once a record is lost, the following records are useless.
+		fp.err = err
+	}
+	return err
+}
+
+// WriteHeader writes the header record of a streaming results file.
+func (fp *File) WriteHeader(result *data.NDT7Result) error {
+	return fp.writeRecord(&Record{Header: result})
+}
+
+// RecordServerMeasurement appends a server measurement to a streaming results
+// file. Errors are returned by WriteSummary.
+func (fp *File) RecordServerMeasurement(m model.Measurement) {
+	if err := fp.writeRecord(&Record{ServerMeasurement: &m}); err != nil {
+		logging.Logger.WithError(err).Warn("RecordServerMeasurement failed")
+	}
+}
+
+// RecordClientMeasurement appends a client measurement to a streaming results
+// file. Errors are returned by WriteSummary.
+func (fp *File) RecordClientMeasurement(m model.Measurement) {
+	if err := fp.writeRecord(&Record{ClientMeasurement: &m}); err != nil {
+		logging.Logger.WithError(err).Warn("RecordClientMeasurement failed")
+	}
+}
+
+// WriteSummary writes the summary record of a streaming results file. The
+// summary is result without the measurements, which are already saved. It
+// returns the first error that occurred while writing the file.
+func (fp *File) WriteSummary(result *data.NDT7Result) error {
+	summary := *result
+	summary.Download = withoutMeasurements(result.Download)
+	summary.Upload = withoutMeasurements(result.Upload)
+	summary.Responsiveness = withoutMeasurements(result.Responsiveness)
+	return fp.writeRecord(&Record{Summary: &summary})
+}
+
+func withoutMeasurements(ad *model.ArchivalData) *model.ArchivalData {
+	if ad == nil {
+		return nil
+	}
+	c := *ad
+	c.ServerMeasurements = nil
+	c.ClientMeasurements = nil
+	return &c
+}
+
+// subtestData returns the archival data of the subtest saved in result.
+func subtestData(result *data.NDT7Result) *model.ArchivalData {
+	switch {
+	case result.Download != nil:
+		return result.Download
+	case result.Upload != nil:
+		return result.Upload
+	default:
+		return result.Responsiveness
+	}
+}
+
+// ErrNoHeader is returned when reading a stream without a header record.
+var ErrNoHeader = errors.New("missing header record")
+
+// ReadStream rebuilds the result saved in a streaming results file from r. It
+// also returns whether the file is complete, i.e., it ends with the summary
+// record. A truncated file, e.g., because the server died during the subtest,
+// results in the header and in the measurements saved so far.
+func ReadStream(r io.Reader) (*data.NDT7Result, bool, error) {
+	br := bufio.NewReader(r)
+	var result *data.NDT7Result
+	var ad *model.ArchivalData
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// End of file before the summary record. The last line, if any, is a
+			// truncated record that we ignore.
+			if result == nil {
+				return nil, false, ErrNoHeader
+			}
+			return result, false, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		var rec Record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return nil, false, err
+		}
+		switch {
+		case rec.Header != nil:
+			result = rec.Header
+			ad = subtestData(result)
+		case result == nil:
+			return nil, false, ErrNoHeader
+		case rec.ServerMeasurement != nil && ad != nil:
+			ad.ServerMeasurements = append(ad.ServerMeasurements, *rec.ServerMeasurement)
+		case rec.ClientMeasurement != nil && ad != nil:
+			ad.ClientMeasurements = append(ad.ClientMeasurements, *rec.ClientMeasurement)
+		case rec.Summary != nil:
+			if sd := subtestData(rec.Summary); sd != nil && ad != nil {
+				sd.ServerMeasurements = ad.ServerMeasurements
+				sd.ClientMeasurements = ad.ClientMeasurements
+			}
+			return rec.Summary, true, nil
+		}
+	}
+}
+
+// ReadStreamFile is like ReadStream, but reads the named file, which is
+// decompressed if its name ends with ".gz". The name may also be the temporary
+// name of a file that was never closed, see IsStreamTemp.
+func ReadStreamFile(name string) (*data.NDT7Result, bool, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	final := name
+	if n, ok := spool.FinalName(name); ok {
+		final = n
+	}
+	if strings.HasSuffix(final, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, false, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	return ReadStream(r)
+}
+
+// IsStreamTemp returns whether name is the temporary name of a streaming
+// results file, i.e., of a subtest that is still running or that was
+// interrupted, e.g., because the server died.
+func IsStreamTemp(name string) bool {
+	final, ok := spool.FinalName(name)
+	base := filepath.Base(final)
+	return ok && strings.HasPrefix(base, "ndt7-") && strings.Contains(base, ".jsonl")
+}
+
+// Recover gives their final names to the streaming results files in datadir
+// that were never closed, e.g., because the server died during the subtest,
+// so that Find and ndt-results see them, as incomplete files. It returns the
+// final names of the recovered files. Since running subtests also have
+// temporary files, Recover must only be called before the server starts.
+func Recover(datadir string) ([]string, error) {
+	var names []string
+	err := filepath.WalkDir(filepath.Join(datadir, "ndt7"), func(path string, d fs.DirEntry, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		if name, ok := RecoverFile(path); ok {
+			names = append(names, name)
+		}
+		return nil
+	})
+	return names, err
+}
+
+// RecoverFile is like Recover for the single file tmp, and returns false if tmp
+// is not a streaming results file or cannot be recovered. It is meant to be the
+// spool.Manager Recover function, which is only called for stale files.
+func RecoverFile(tmp string) (string, bool) {
+	if !IsStreamTemp(tmp) {
+		return "", false
+	}
+	name, err := spool.Recover(tmp)
+	if err != nil {
+		logging.Logger.WithError(err).Warn("Cannot recover " + tmp)
+		return "", false
+	}
+	return name, true
+}
@@ -0,0 +1,162 @@
+package results
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/m-lab/go/rtx"
+	"github.com/m-lab/ndt-server/data"
+	"github.com/m-lab/ndt-server/ndt7/model"
+	"github.com/m-lab/ndt-server/ndt7/spec"
+)
+
+// writeStream writes a complete streaming results file and returns its name
+// and the expected result.
+func writeStream(t *testing.T, dir string, compress bool) (string, *data.NDT7Result) {
+	fp, err := NewStreamFile("test-uuid", dir, spec.SubtestDownload, compress)
+	rtx.Must(err, "NewStreamFile failed")
+	ad := &model.ArchivalData{UUID: "test-uuid"}
+	result := &data.NDT7Result{ServerIP: "127.0.0.1", Download: ad}
+	if err := fp.WriteHeader(result); err != nil {
+		t.Fatalf("WriteHeader() unexpected error = %v", err)
+	}
+	for i := int64(1); i <= 3; i++ {
+		m := model.Measurement{AppInfo: &model.AppInfo{NumBytes: i, ElapsedTime: i}}
+		ad.ServerMeasurements = append(ad.ServerMeasurements, m)
+		fp.RecordServerMeasurement(m)
+		ad.ClientMeasurements = append(ad.ClientMeasurements, m)
+		fp.RecordClientMeasurement(m)
+	}
+	ad.TerminationReason = model.TerminationRuntime
+	result.EndTime = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := fp.WriteSummary(result); err != nil {
+		t.Fatalf("WriteSummary() unexpected error = %v", err)
+	}
+	rtx.Must(fp.Close(), "Close failed")
+	files, err := filepath.Glob(dir + "/ndt7/*/*/*/*.jsonl*")
+	rtx.Must(err, "Glob failed")
+	if len(files) != 1 {
+		t.Fatalf("wrong number of files; got %d, want 1", len(files))
+	}
+	return files[0], result
+}
+
+func TestReadStreamFile(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		name, want := writeStream(t, t.TempDir(), compress)
+		got, complete, err := ReadStreamFile(name)
+		if err != nil || !complete {
+			t.Fatalf("ReadStreamFile() = %v, %v, want complete", complete, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("ReadStreamFile() = %+v, want %+v", got, want)
+		}
+	}
+}
+
+func TestReadStreamFile_Truncated(t *testing.T) {
+	name, want := writeStream(t, t.TempDir(), false)
+	b, err := os.ReadFile(name)
+	rtx.Must(err, "ReadFile failed")
+	// Drop the summary and cut the last client measurement in half.
+	lines := 0
+	cut := 0
+	for i, c := range b {
+		if c == '\n' {
+			lines++
+			if lines == 6 {
+				cut = i + 10
+				break
+			}
+		}
+	}
+	rtx.Must(os.WriteFile(name, b[:cut], 0644), "WriteFile failed")
+
+	got, complete, err := ReadStreamFile(name)
+	if err != nil || complete {
+		t.Fatalf("ReadStreamFile() = %v, %v, want incomplete", complete, err)
+	}
+	if got.ServerIP != want.ServerIP || !got.EndTime.IsZero() {
+		t.Errorf("ReadStreamFile() wrong header; got %+v", got)
+	}
+	if len(got.Download.ServerMeasurements) != 3 || len(got.Download.ClientMeasurements) != 2 {
+		t.Errorf("ReadStreamFile() wrong measurements; got %d server, %d client",
+			len(got.Download.ServerMeasurements), len(got.Download.ClientMeasurements))
+	}
+
+	rtx.Must(os.WriteFile(name, b[:10], 0644), "WriteFile failed")
+	if _, _, err := ReadStreamFile(name); err != ErrNoHeader {
+		t.Errorf("ReadStreamFile() error = %v, want %v", err, ErrNoHeader)
+	}
+}
+
+func TestNewStreamFile_TemporaryName(t *testing.T) {
+	dir := t.TempDir()
+	fp, err := NewStreamFile("test-uuid", dir, spec.SubtestDownload, false)
+	rtx.Must(err, "NewStreamFile failed")
+	if err := fp.WriteHeader(&data.NDT7Result{}); err != nil {
+		t.Fatalf("WriteHeader() unexpected error = %v", err)
+	}
+	// While the subtest runs, the file must not be visible under its final
+	// name, so that incomplete files are not collected.
+	visible, err := filepath.Glob(dir + "/ndt7/*/*/*/*.jsonl")
+	rtx.Must(err, "Glob failed")
+	temporary, err := filepath.Glob(dir + "/ndt7/*/*/*/.*.tmp")
+	rtx.Must(err, "Glob failed")
+	if len(visible) != 0 || len(temporary) != 1 {
+		t.Fatalf("wrong files while streaming; got %v and %v", visible, temporary)
+	}
+	fp.Abort()
+	temporary, err = filepath.Glob(dir + "/ndt7/*/*/*/.*.tmp")
+	rtx.Must(err, "Glob failed")
+	if len(temporary) != 0 {
+		t.Errorf("Abort() left %v", temporary)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		dir := t.TempDir()
+		fp, err := NewStreamFile("test-uuid", dir, spec.SubtestDownload, compress)
+		rtx.Must(err, "NewStreamFile failed")
+		result := &data.NDT7Result{ServerIP: "127.0.0.1", Download: &model.ArchivalData{UUID: "test-uuid"}}
+		if err := fp.WriteHeader(result); err != nil {
+			t.Fatalf("WriteHeader() unexpected error = %v", err)
+		}
+		fp.RecordServerMeasurement(model.Measurement{AppInfo: &model.AppInfo{NumBytes: 1}})
+		// Abandon the file without Close, as if the server died.
+		rtx.Must(fp.fp.File.Close(), "Close of the temporary file failed")
+		temporary, err := filepath.Glob(dir + "/ndt7/*/*/*/.*.tmp")
+		rtx.Must(err, "Glob failed")
+		if len(temporary) != 1 || !IsStreamTemp(temporary[0]) {
+			t.Fatalf("wrong temporary files; got %v", temporary)
+		}
+
+		recovered, err := Recover(dir)
+		if err != nil || len(recovered) != 1 {
+			t.Fatalf("Recover() = %v, %v, want one file", recovered, err)
+		}
+		names, err := Find(dir, "test-uuid", time.Time{})
+		if err != nil || len(names) != 1 || names[0] != recovered[0] {
+			t.Fatalf("Find() = %v, %v, want %v", names, err, recovered)
+		}
+		got, complete, err := ReadStreamFile(names[0])
+		if err != nil || complete {
+			t.Fatalf("ReadStreamFile() = %v, %v, want incomplete", complete, err)
+		}
+		if got.ServerIP != result.ServerIP || got.Download == nil || len(got.Download.ServerMeasurements) != 1 {
+			t.Errorf("ReadStreamFile() = %+v, want the header and one measurement", got)
+		}
+	}
+	// Only streaming results files are recovered.
+	if _, ok := RecoverFile(filepath.Join(t.TempDir(), ".ndt7-download-x.json.123.tmp")); ok {
+		t.Errorf("RecoverFile() recovered a file that was not written incrementally")
+	}
+	// A missing datadir has nothing to recover.
+	if recovered, err := Recover(filepath.Join(t.TempDir(), "missing")); err != nil || len(recovered) != 0 {
+		t.Errorf("Recover() = %v, %v, want nothing", recovered, err)
+	}
+}
@@ -1,7 +1,12 @@
 // Package spec contains constants defined in the ndt7 specification.
 package spec
 
-import "time"
+import (
+	"time"
+
+	"github.com/m-lab/ndt-server/ndt7/model"
+	"github.com/m-lab/ndt-server/ndt7/stability"
+)
 
 // DownloadURLPath selects the download subtest.
 const DownloadURLPath = "/ndt/v7/download"
@@ -9,6 +14,9 @@ const DownloadURLPath = "/ndt/v7/download"
 // UploadURLPath selects the upload subtest.
 const UploadURLPath = "/ndt/v7/upload"
 
+// ResponsivenessURLPath selects the responsiveness subtest.
+const ResponsivenessURLPath = "/ndt/v7/responsiveness"
+
 // SecWebSocketProtocol is the WebSocket subprotocol used by ndt7.
 const SecWebSocketProtocol = "net.measurementlab.ndt.v7"
 
@@ -22,15 +30,36 @@ const MaxMessageSize = 1 << 24
 // a good compromise between Go and JavaScript as seen in cloud based tests.
 const MaxScaledMessageSize = 1 << 20
 
-// ValidEarlyExitValues contains the set of accepted MB transfer amounts after which
-// ndt7 download tests can be prematurely terminated.
+// DefaultEarlyExitValues contains the set of accepted MB transfer amounts after
+// which ndt7 download and upload tests can be prematurely terminated, unless the
+// server is configured with a different early exit policy.
 // Client requests with values outside of this set will result in a 400 error.
+var DefaultEarlyExitValues = []int64{250}
+
+// ValidEarlyExitValues contains DefaultEarlyExitValues formatted as strings.
+//
+// Deprecated: use DefaultEarlyExitValues.
 var ValidEarlyExitValues = []string{"250"}
 
 // EarlyExitParameterName is the name of the parameter that clients can use to terminate
-// ndt7 download tests once the test has transferred as many MB as the parameter's value.
+// ndt7 download and upload tests once the test has transferred as many MB as the
+// parameter's value.
 const EarlyExitParameterName = "early_exit"
 
+// EarlyExitTimeParameterName is the name of the parameter that clients can use
+// to terminate ndt7 download and upload tests after the parameter's value in
+// seconds, once the test has transferred the minimum amount of data set by the
+// server policy.
+const EarlyExitTimeParameterName = "early_exit_time"
+
+// EarlyExitPolicyURLPath returns the early exit policy of the server.
+const EarlyExitPolicyURLPath = "/ndt/v7/early_exit_policy"
+
+// DurationParameterName is the name of the parameter that clients can use to
+// request a subtest runtime, in seconds. The server only accepts values within
+// its configured bounds. Requests without this parameter use DefaultRuntime.
+const DurationParameterName = "duration"
+
 // DefaultWebsocketBufferSize is the read and write buffer sizes used when
 // creating a websocket connection. This size is independent of the websocket
 // message sizes defined above (which may be larger) and used to optimize read
@@ -53,6 +82,47 @@ const AveragePoissonSamplingInterval = 250 * time.Millisecond
 // to be this value instead.
 const MinPoissonSamplingInterval = 25 * time.Millisecond
 
+// MaxRateParameterName is the name of the parameter that clients can use to
+// request a cap on the rate of a download, in Mbit/s. The cap is chosen by the
+// client, and can only lower the cap configured by the server operator. The
+// server only accepts it from clients presenting a valid access token.
+const MaxRateParameterName = "max_rate"
+
+// LoadParameterName is the name of the parameter that clients can use to select
+// whether the responsiveness subtest loads the link with a download or with an
+// upload. The default is a download.
+const LoadParameterName = "load"
+
+// CongestionControlParameterName is the name of the parameter that clients can
+// use to request a congestion control algorithm, e.g., "cubic". The algorithm
+// must be allowed by the server.
+const CongestionControlParameterName = "cc"
+
+// DefaultCongestionControl is the congestion control algorithm used when the
+// client does not request one and the server does not configure a default.
+const DefaultCongestionControl = "bbr"
+
+// ResponsivenessIdleFraction sets the length of the idle phase of the
+// responsiveness subtest. The first 1/ResponsivenessIdleFraction of the subtest
+// runtime measures the idle latency, and the rest measures the loaded latency.
+const ResponsivenessIdleFraction = 5
+
+// SessionParameterName is the name of the parameter that clients use to
+// identify the parallel connections belonging to a multi-stream subtest.
+const SessionParameterName = "session"
+
+// StreamsParameterName is the name of the parameter that clients use to
+// announce how many parallel connections make up a multi-stream subtest.
+const StreamsParameterName = "streams"
+
+// MaxSessionIDLength is the maximum length of a multi-stream session identifier.
+const MaxSessionIDLength = 64
+
+// SessionJoinTimeout is the maximum time the server waits for all the streams
+// of a multi-stream subtest to connect. After this timeout, the streams that
+// have connected start the subtest and late streams are rejected.
+const SessionJoinTimeout = 3 * time.Second
+
 // MaxPoissonSamplingInterval is the max acceptable time that we want
 // the lambda distribution to return. Bigger values will be clamped
 // to be this value instead.
@@ -64,6 +134,12 @@ const DefaultRuntime = 10 * time.Second
 // MaxRuntime is the maximum runtime of a subtest
 const MaxRuntime = 15 * time.Second
 
+// MaxRuntimeFor returns the maximum runtime of a subtest that is expected to
+// run for the given runtime. MaxRuntimeFor(DefaultRuntime) == MaxRuntime.
+func MaxRuntimeFor(runtime time.Duration) time.Duration {
+	return runtime + MaxRuntime - DefaultRuntime
+}
+
 // SubtestKind indicates the subtest kind
 type SubtestKind string
 
@@ -73,10 +149,50 @@ const (
 
 	// SubtestUpload is a upload subtest
 	SubtestUpload = SubtestKind("upload")
+
+	// SubtestResponsiveness is a responsiveness subtest
+	SubtestResponsiveness = SubtestKind("responsiveness")
 )
 
 // Params defines the client parameters for ndt7 requests.
 type Params struct {
 	IsEarlyExit bool
 	MaxBytes    int64
+	// EarlyExitAfter and EarlyExitMinBytes define a time-based early exit:
+	// the subtest ends after EarlyExitAfter, once EarlyExitMinBytes have been
+	// transferred.
+	EarlyExitAfter    time.Duration
+	EarlyExitMinBytes int64
+	// Runtime is the expected runtime of the subtest.
+	Runtime time.Duration
+	// Load is the subtest that loads the link during a responsiveness subtest.
+	Load SubtestKind
+	// CongestionControl is the congestion control algorithm of the subtest.
+	CongestionControl string
+	// Stability, when not nil, ends the subtest once the measured rate has
+	// converged according to the criteria.
+	Stability *stability.Criteria
+	// Recorder, when not nil, is passed every measurement saved in the
+	// archival data of the subtest.
+	Recorder model.Recorder
+}
+
+// ShouldExitEarly returns whether a subtest using p should end after
+// transferring bytes in elapsed time.
+func (p *Params) ShouldExitEarly(bytes int64, elapsed time.Duration) bool {
+	if !p.IsEarlyExit {
+		return false
+	}
+	if p.MaxBytes > 0 && bytes >= p.MaxBytes {
+		return true
+	}
+	return p.EarlyExitAfter > 0 && elapsed >= p.EarlyExitAfter && bytes >= p.EarlyExitMinBytes
 }
+
+// ResultURLPath returns the results archived by the server for the UUID in
+// the ResultUUIDParameterName parameter.
+const ResultURLPath = "/ndt/v7/result"
+
+// ResultUUIDParameterName is the name of the parameter that selects the UUID
+// of the results returned by ResultURLPath.
+const ResultUUIDParameterName = "uuid"
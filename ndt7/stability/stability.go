@@ -0,0 +1,62 @@
+// Package stability detects when the rate measured by an ndt7 subtest has
+// converged, so that the subtest may end before its full runtime.
+package stability
+
+import (
+	"slices"
+	"time"
+)
+
+// Criteria defines when a rate is considered stable: the rate measured over
+// the last Window must stay within Tolerance for Count consecutive
+// measurements.
+type Criteria struct {
+	// Window is the interval over which each rate is measured.
+	Window time.Duration
+	// Tolerance is the maximum spread of the rates, as a fraction of the
+	// largest rate, e.g., 0.05 for 5%.
+	Tolerance float64
+	// Count is the number of consecutive rates that must be within Tolerance.
+	Count int
+}
+
+type sample struct {
+	bytes   int64
+	elapsed time.Duration
+}
+
+// Detector checks a stream of measurements against the Criteria.
+type Detector struct {
+	criteria Criteria
+	samples  []sample
+	rates    []float64
+}
+
+// New creates a new Detector for the given criteria.
+func New(criteria Criteria) *Detector {
+	return &Detector{criteria: criteria}
+}
+
+// Update adds a measurement of the bytes transferred after elapsed time, and
+// returns whether the rate is stable.
+func (d *Detector) Update(bytes int64, elapsed time.Duration) bool {
+	d.samples = append(d.samples, sample{bytes: bytes, elapsed: elapsed})
+	// Keep the most recent sample that is at least Window old as the first.
+	for len(d.samples) > 1 && elapsed-d.samples[1].elapsed >= d.criteria.Window {
+		d.samples = d.samples[1:]
+	}
+	first := d.samples[0]
+	if elapsed-first.elapsed < d.criteria.Window || elapsed <= first.elapsed {
+		return false
+	}
+	rate := float64(bytes-first.bytes) / (elapsed - first.elapsed).Seconds()
+	d.rates = append(d.rates, rate)
+	if len(d.rates) > d.criteria.Count {
+		d.rates = d.rates[1:]
+	}
+	if len(d.rates) < d.criteria.Count {
+		return false
+	}
+	lo, hi := slices.Min(d.rates), slices.Max(d.rates)
+	return hi > 0 && hi-lo <= d.criteria.Tolerance*hi
+}
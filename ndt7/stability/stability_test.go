@@ -0,0 +1,62 @@
+package stability
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDetector_Update(t *testing.T) {
+	criteria := Criteria{Window: time.Second, Tolerance: 0.1, Count: 3}
+	tests := []struct {
+		name string
+		// rates lists the rate in bytes per second during each 250ms step.
+		rates []int64
+		// want is the index of the first step where the rate is stable, or -1.
+		want int
+	}{
+		{
+			name:  "constant-rate",
+			rates: []int64{1000, 1000, 1000, 1000, 1000, 1000, 1000, 1000},
+			want:  5,
+		},
+		{
+			name:  "ramp-up-then-constant",
+			rates: []int64{100, 200, 400, 800, 1000, 1000, 1000, 1000, 1000, 1000, 1000},
+			want:  8,
+		},
+		{
+			name:  "growing-rate",
+			rates: []int64{100, 200, 300, 400, 500, 600, 700, 800, 900, 1000},
+			want:  -1,
+		},
+		{
+			// Oscillations shorter than the window average out.
+			name:  "oscillating-rate",
+			rates: []int64{1000, 100, 1000, 100, 1000, 100, 1000, 100},
+			want:  5,
+		},
+		{
+			name:  "zero-rate",
+			rates: []int64{0, 0, 0, 0, 0, 0, 0, 0},
+			want:  -1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := New(criteria)
+			var bytes int64
+			d.Update(0, 0)
+			got := -1
+			for i, rate := range tt.rates {
+				bytes += rate / 4
+				if d.Update(bytes, time.Duration(i+1)*250*time.Millisecond) {
+					got = i
+					break
+				}
+			}
+			if got != tt.want {
+				t.Errorf("Update() stable at step %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+// Package summary computes the final result of the ndt7 download and upload
+// subtests from the measurements of the server.
+package summary
+
+import (
+	"github.com/m-lab/ndt-server/ndt7/model"
+	"github.com/m-lab/ndt-server/ndt7/spec"
+	"github.com/m-lab/ndt-server/ndt7/transport"
+)
+
+// UploadRate returns the mean upload throughput in Mbps, as measured by the
+// bytes received according to the last measurement.
+func UploadRate(m []model.Measurement) float64 {
+	var mbps float64
+	// NOTE: on non-Linux platforms, TCPInfo will be nil.
+	if len(m) > 0 && m[len(m)-1].TCPInfo != nil {
+		// Convert to Mbps.
+		mbps = 8 * float64(m[len(m)-1].TCPInfo.BytesReceived) / float64(m[len(m)-1].TCPInfo.ElapsedTime)
+	} else {
+		mbps = appRate(m)
+	}
+	return mbps
+}
+
+// DownloadRate returns the mean download throughput in Mbps, as measured by
+// the bytes acked according to the last measurement.
+func DownloadRate(m []model.Measurement) float64 {
+	var mbps float64
+	// NOTE: on non-Linux platforms, TCPInfo will be nil.
+	if len(m) > 0 && m[len(m)-1].TCPInfo != nil {
+		// Convert to Mbps.
+		mbps = 8 * float64(m[len(m)-1].TCPInfo.BytesAcked) / float64(m[len(m)-1].TCPInfo.ElapsedTime)
+	} else {
+		mbps = appRate(m)
+	}
+	return mbps
+}
+
+// appRate returns the application-level goodput of the last measurement in
+// Mbps. It is used as a fallback when TCPInfo is unavailable.
+func appRate(m []model.Measurement) float64 {
+	var mbps float64
+	if len(m) > 0 && m[len(m)-1].AppInfo != nil && m[len(m)-1].AppInfo.ElapsedTime > 0 {
+		// Convert to Mbps.
+		mbps = 8 * float64(m[len(m)-1].AppInfo.NumBytes) / float64(m[len(m)-1].AppInfo.ElapsedTime)
+	}
+	return mbps
+}
+
+// New returns the summary of a subtest of the given kind, which must be
+// spec.SubtestDownload or spec.SubtestUpload, from the measurements in data.
+func New(kind spec.SubtestKind, data *model.ArchivalData) *model.Summary {
+	m := data.ServerMeasurements
+	s := &model.Summary{UUID: data.UUID}
+	if kind == spec.SubtestUpload {
+		s.MeanThroughputMbps = UploadRate(m)
+	} else {
+		s.MeanThroughputMbps = DownloadRate(m)
+	}
+	// NOTE: on non-Linux platforms, TCPInfo will be nil.
+	if len(m) > 0 && m[len(m)-1].TCPInfo != nil {
+		ti := m[len(m)-1].TCPInfo
+		s.MinRTT = int64(ti.MinRTT)
+		// During uploads, the server only sends acknowledgements.
+		if kind != spec.SubtestUpload && ti.BytesSent > 0 {
+			s.RetransmissionRate = float64(ti.BytesRetrans) / float64(ti.BytesSent)
+		}
+	}
+	return s
+}
+
+// Send saves the summary of a subtest of the given kind in data, and sends it
+// to the client conn as the last measurement message of the subtest.
+func Send(conn transport.Conn, kind spec.SubtestKind, data *model.ArchivalData) error {
+	data.Summary = New(kind, data)
+	return conn.WriteJSON(model.Measurement{Summary: data.Summary})
+}
@@ -0,0 +1,109 @@
+package summary
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/m-lab/ndt-server/ndt7/model"
+	"github.com/m-lab/ndt-server/ndt7/spec"
+	"github.com/m-lab/tcp-info/tcp"
+)
+
+func TestDownloadRateAndUploadRate(t *testing.T) {
+	tests := []struct {
+		name     string
+		m        []model.Measurement
+		wantDown float64
+		wantUp   float64
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "tcpinfo",
+			m: []model.Measurement{
+				{
+					AppInfo: &model.AppInfo{NumBytes: 100, ElapsedTime: 10},
+					TCPInfo: &model.TCPInfo{
+						LinuxTCPInfo: tcp.LinuxTCPInfo{BytesAcked: 20, BytesReceived: 30},
+						ElapsedTime:  10,
+					},
+				},
+			},
+			wantDown: 16,
+			wantUp:   24,
+		},
+		{
+			name: "appinfo-fallback",
+			m: []model.Measurement{
+				{AppInfo: &model.AppInfo{NumBytes: 100, ElapsedTime: 10}},
+			},
+			wantDown: 80,
+			wantUp:   80,
+		},
+		{
+			name: "appinfo-zero-elapsed",
+			m: []model.Measurement{
+				{AppInfo: &model.AppInfo{NumBytes: 100, ElapsedTime: 0}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DownloadRate(tt.m); got != tt.wantDown {
+				t.Errorf("DownloadRate() = %v, want %v", got, tt.wantDown)
+			}
+			if got := UploadRate(tt.m); got != tt.wantUp {
+				t.Errorf("UploadRate() = %v, want %v", got, tt.wantUp)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	data := &model.ArchivalData{
+		UUID: "test-uuid",
+		ServerMeasurements: []model.Measurement{
+			{
+				TCPInfo: &model.TCPInfo{
+					LinuxTCPInfo: tcp.LinuxTCPInfo{
+						BytesAcked: 20, BytesReceived: 30, BytesSent: 40, BytesRetrans: 2, MinRTT: 1500,
+					},
+					ElapsedTime: 10,
+				},
+			},
+		},
+	}
+	tests := []struct {
+		name string
+		kind spec.SubtestKind
+		data *model.ArchivalData
+		want *model.Summary
+	}{
+		{
+			name: "download",
+			kind: spec.SubtestDownload,
+			data: data,
+			want: &model.Summary{UUID: "test-uuid", MeanThroughputMbps: 16, MinRTT: 1500, RetransmissionRate: 0.05},
+		},
+		{
+			name: "upload",
+			kind: spec.SubtestUpload,
+			data: data,
+			want: &model.Summary{UUID: "test-uuid", MeanThroughputMbps: 24, MinRTT: 1500},
+		},
+		{
+			name: "no-measurements",
+			kind: spec.SubtestDownload,
+			data: &model.ArchivalData{UUID: "test-uuid"},
+			want: &model.Summary{UUID: "test-uuid"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := New(tt.kind, tt.data); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("New() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,409 @@
+package transport
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Frame header bits and opcodes (RFC 6455, section 5.2).
+const (
+	finalBit = 0x80
+	rsvBits  = 0x70
+	maskBit  = 0x80
+
+	continuationFrame = 0
+	noFrame           = -1
+
+	maxControlFramePayloadSize = 125
+)
+
+var (
+	errUnmaskedFrame   = errors.New("transport: received unmasked frame")
+	errBadFrame        = errors.New("transport: received malformed frame")
+	errNoUnderlyingTCP = errors.New("transport: missing connection in request context")
+	errNotWebSocket    = errors.New("transport: not a WebSocket Extended CONNECT request")
+	errBadVersion      = errors.New("transport: unsupported Sec-WebSocket-Version")
+)
+
+// h2Conn implements Conn using the WebSocket framing (RFC 6455) on top of the
+// request and response bodies of an HTTP/2 stream. Since HTTP/2 already
+// provides framing and flow control, the WebSocket frames are simply written
+// to the response and read from the request body.
+type h2Conn struct {
+	rw      http.ResponseWriter
+	rc      *http.ResponseController
+	body    io.ReadCloser
+	br      *bufio.Reader
+	netConn net.Conn
+	stream  int64
+
+	// The following fields are owned by the reader.
+	reader        *messageReader
+	readErr       error
+	readLimit     int64
+	readLength    int64 // bytes read in the current message
+	readRemaining int64 // bytes left in the current frame
+	readFinal     bool  // whether the current frame is the last of its message
+	readMask      [4]byte
+	readMaskPos   int
+	pongHandler   func(string) error
+
+	// mu serializes writes.
+	mu            sync.Mutex
+	writeDeadline time.Time
+	closeSent     bool
+
+	closeOnce sync.Once
+}
+
+// AcceptH2 accepts the WebSocket bootstrapped by the HTTP/2 Extended CONNECT
+// request req, and returns the Conn. The responseHeader is included in the
+// response to the client. The request context must contain the TCP connection
+// saved by ConnContext. If accepting fails, AcceptH2 replies to the client
+// with an HTTP error response.
+func AcceptH2(rw http.ResponseWriter, req *http.Request, responseHeader http.Header) (Conn, error) {
+	if !IsExtendedConnect(req) {
+		http.Error(rw, errNotWebSocket.Error(), http.StatusBadRequest)
+		return nil, errNotWebSocket
+	}
+	if req.Header.Get("Sec-WebSocket-Version") != "13" {
+		http.Error(rw, errBadVersion.Error(), http.StatusBadRequest)
+		return nil, errBadVersion
+	}
+	state, ok := req.Context().Value(connKey{}).(*connState)
+	if !ok {
+		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return nil, errNoUnderlyingTCP
+	}
+	for k, v := range responseHeader {
+		rw.Header()[k] = v
+	}
+	c := newH2Conn(rw, req.Body, state.conn)
+	c.stream = state.streams.Add(1)
+	// Clear the deadlines set by the server, like websocket.Upgrader does.
+	c.rc.SetReadDeadline(time.Time{})
+	c.rc.SetWriteDeadline(time.Time{})
+	rw.WriteHeader(http.StatusOK)
+	if err := c.rc.Flush(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func newH2Conn(rw http.ResponseWriter, body io.ReadCloser, netConn net.Conn) *h2Conn {
+	return &h2Conn{
+		rw:        rw,
+		rc:        http.NewResponseController(rw),
+		body:      body,
+		br:        bufio.NewReader(body),
+		netConn:   netConn,
+		readFinal: true,
+	}
+}
+
+// NextReader returns the next data message received from the client. The
+// returned reader is valid until the next call to NextReader.
+func (c *h2Conn) NextReader() (int, io.Reader, error) {
+	c.reader = nil
+	for c.readErr == nil {
+		if c.readRemaining > 0 {
+			// Discard the unread part of the previous message.
+			n, err := c.br.Discard(int(min(c.readRemaining, 1<<20)))
+			c.readRemaining -= int64(n)
+			c.readErr = unexpectedEOF(err)
+			continue
+		}
+		opcode, err := c.advanceFrame()
+		if err != nil {
+			c.readErr = err
+			break
+		}
+		if opcode == websocket.TextMessage || opcode == websocket.BinaryMessage {
+			c.reader = &messageReader{c: c}
+			return opcode, c.reader, nil
+		}
+	}
+	return noFrame, nil, c.readErr
+}
+
+// advanceFrame reads the next frame header. Control frames are read and
+// handled entirely. For data frames, the payload is left to the caller.
+func (c *h2Conn) advanceFrame() (int, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.br, hdr[:]); err != nil {
+		return noFrame, unexpectedEOF(err)
+	}
+	final := hdr[0]&finalBit != 0
+	opcode := int(hdr[0] & 0x0f)
+	if hdr[0]&rsvBits != 0 {
+		return noFrame, c.fail(websocket.CloseProtocolError, errBadFrame)
+	}
+	if hdr[1]&maskBit == 0 {
+		return noFrame, c.fail(websocket.CloseProtocolError, errUnmaskedFrame)
+	}
+	length := int64(hdr[1] &^ maskBit)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return noFrame, unexpectedEOF(err)
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return noFrame, unexpectedEOF(err)
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+		if length < 0 {
+			return noFrame, c.fail(websocket.CloseProtocolError, errBadFrame)
+		}
+	}
+	if _, err := io.ReadFull(c.br, c.readMask[:]); err != nil {
+		return noFrame, unexpectedEOF(err)
+	}
+	c.readMaskPos = 0
+
+	switch opcode {
+	case websocket.CloseMessage, websocket.PingMessage, websocket.PongMessage:
+		if length > maxControlFramePayloadSize || !final {
+			return noFrame, c.fail(websocket.CloseProtocolError, errBadFrame)
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(c.br, payload); err != nil {
+			return noFrame, unexpectedEOF(err)
+		}
+		c.unmask(payload)
+		return opcode, c.handleControl(opcode, payload)
+	case continuationFrame:
+		if c.readFinal {
+			return noFrame, c.fail(websocket.CloseProtocolError, errBadFrame)
+		}
+	case websocket.TextMessage, websocket.BinaryMessage:
+		if !c.readFinal {
+			return noFrame, c.fail(websocket.CloseProtocolError, errBadFrame)
+		}
+		c.readLength = 0
+	default:
+		return noFrame, c.fail(websocket.CloseProtocolError, errBadFrame)
+	}
+	c.readFinal = final
+	c.readRemaining = length
+	c.readLength += length
+	if c.readLimit > 0 && c.readLength > c.readLimit {
+		return noFrame, c.fail(websocket.CloseMessageTooBig, websocket.ErrReadLimit)
+	}
+	return opcode, nil
+}
+
+// handleControl handles a control frame received from the client.
+func (c *h2Conn) handleControl(opcode int, payload []byte) error {
+	switch opcode {
+	case websocket.PingMessage:
+		err := c.WriteControl(websocket.PongMessage, payload, time.Now().Add(time.Second))
+		if err != nil && err != websocket.ErrCloseSent {
+			return err
+		}
+	case websocket.PongMessage:
+		if c.pongHandler != nil {
+			return c.pongHandler(string(payload))
+		}
+	case websocket.CloseMessage:
+		closeErr := &websocket.CloseError{Code: websocket.CloseNoStatusReceived}
+		reply := []byte{}
+		if len(payload) >= 2 {
+			closeErr.Code = int(binary.BigEndian.Uint16(payload))
+			closeErr.Text = string(payload[2:])
+			reply = websocket.FormatCloseMessage(closeErr.Code, "")
+		}
+		c.WriteControl(websocket.CloseMessage, reply, time.Now().Add(time.Second))
+		return closeErr
+	}
+	return nil
+}
+
+// fail sends a close message with the given code and returns err.
+func (c *h2Conn) fail(code int, err error) error {
+	msg := websocket.FormatCloseMessage(code, "")
+	c.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	return err
+}
+
+func (c *h2Conn) unmask(b []byte) {
+	for i := range b {
+		b[i] ^= c.readMask[c.readMaskPos&3]
+		c.readMaskPos++
+	}
+}
+
+// unexpectedEOF converts io.EOF to io.ErrUnexpectedEOF, since the stream
+// must not end before a close message.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// messageReader reads the payload of a data message, which may span several
+// frames, possibly interleaved with control frames.
+type messageReader struct {
+	c *h2Conn
+}
+
+func (r *messageReader) Read(b []byte) (int, error) {
+	c := r.c
+	if c.reader != r {
+		return 0, io.EOF
+	}
+	for c.readErr == nil {
+		if c.readRemaining > 0 {
+			if int64(len(b)) > c.readRemaining {
+				b = b[:c.readRemaining]
+			}
+			n, err := c.br.Read(b)
+			c.unmask(b[:n])
+			c.readRemaining -= int64(n)
+			c.readErr = unexpectedEOF(err)
+			return n, c.readErr
+		}
+		if c.readFinal {
+			c.reader = nil
+			return 0, io.EOF
+		}
+		_, err := c.advanceFrame()
+		c.readErr = err
+	}
+	return 0, c.readErr
+}
+
+// WriteMessage writes a message with the given type and payload.
+func (c *h2Conn) WriteMessage(messageType int, data []byte) error {
+	if messageType != websocket.TextMessage && messageType != websocket.BinaryMessage {
+		return errBadFrame
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.writeFrame(messageType, data)
+}
+
+// WriteJSON writes the JSON encoding of v as a text message.
+func (c *h2Conn) WriteJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.WriteMessage(websocket.TextMessage, data)
+}
+
+// WriteControl writes a control message with the given deadline.
+func (c *h2Conn) WriteControl(messageType int, data []byte, deadline time.Time) error {
+	if messageType != websocket.CloseMessage && messageType != websocket.PingMessage &&
+		messageType != websocket.PongMessage {
+		return errBadFrame
+	}
+	if len(data) > maxControlFramePayloadSize {
+		return errBadFrame
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !deadline.IsZero() && (c.writeDeadline.IsZero() || deadline.Before(c.writeDeadline)) {
+		c.rc.SetWriteDeadline(deadline)
+		defer c.rc.SetWriteDeadline(c.writeDeadline)
+	}
+	return c.writeFrame(messageType, data)
+}
+
+// writeFrame writes a single unmasked frame. The caller must hold c.mu.
+func (c *h2Conn) writeFrame(opcode int, data []byte) error {
+	if c.closeSent {
+		return websocket.ErrCloseSent
+	}
+	var hdr [10]byte
+	hdr[0] = finalBit | byte(opcode)
+	n := 2
+	switch {
+	case len(data) <= maxControlFramePayloadSize:
+		hdr[1] = byte(len(data))
+	case len(data) <= 0xffff:
+		hdr[1] = 126
+		binary.BigEndian.PutUint16(hdr[2:], uint16(len(data)))
+		n += 2
+	default:
+		hdr[1] = 127
+		binary.BigEndian.PutUint64(hdr[2:], uint64(len(data)))
+		n += 8
+	}
+	if opcode == websocket.CloseMessage {
+		c.closeSent = true
+	}
+	if _, err := c.rw.Write(hdr[:n]); err != nil {
+		return err
+	}
+	if _, err := c.rw.Write(data); err != nil {
+		return err
+	}
+	return c.rc.Flush()
+}
+
+// SetReadLimit sets the maximum size of a message read from the client.
+func (c *h2Conn) SetReadLimit(limit int64) {
+	c.readLimit = limit
+}
+
+// SetReadDeadline sets the read deadline of the stream.
+func (c *h2Conn) SetReadDeadline(t time.Time) error {
+	return c.rc.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline of the stream.
+func (c *h2Conn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeDeadline = t
+	return c.rc.SetWriteDeadline(t)
+}
+
+// SetPongHandler sets the handler for pong messages received from the client.
+func (c *h2Conn) SetPongHandler(h func(appData string) error) {
+	c.pongHandler = h
+}
+
+// LocalAddr returns the local address of the TCP connection.
+func (c *h2Conn) LocalAddr() net.Addr {
+	return c.netConn.LocalAddr()
+}
+
+// RemoteAddr returns the remote address of the TCP connection.
+func (c *h2Conn) RemoteAddr() net.Addr {
+	return c.netConn.RemoteAddr()
+}
+
+// UnderlyingConn returns the TCP connection, which may be shared with other
+// HTTP/2 streams.
+func (c *h2Conn) UnderlyingConn() net.Conn {
+	return c.netConn
+}
+
+// Close unblocks pending reads and writes and closes the request body. The
+// stream ends once the handler returns. Close does not close the TCP
+// connection, which may be shared with other streams.
+func (c *h2Conn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		now := time.Now()
+		c.rc.SetReadDeadline(now)
+		c.rc.SetWriteDeadline(now)
+		err = c.body.Close()
+	})
+	return err
+}
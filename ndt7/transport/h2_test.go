@@ -0,0 +1,311 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// fakeResponseWriter implements http.ResponseWriter and the optional methods
+// used through http.ResponseController.
+type fakeResponseWriter struct {
+	mu     sync.Mutex
+	header http.Header
+	buf    bytes.Buffer
+}
+
+func (w *fakeResponseWriter) Header() http.Header { return w.header }
+func (w *fakeResponseWriter) WriteHeader(int)     {}
+func (w *fakeResponseWriter) Flush()              {}
+func (w *fakeResponseWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Write(b)
+}
+func (w *fakeResponseWriter) SetReadDeadline(time.Time) error  { return nil }
+func (w *fakeResponseWriter) SetWriteDeadline(time.Time) error { return nil }
+
+// frames returns the frames written by the server.
+func (w *fakeResponseWriter) frames(t *testing.T) []frame {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var frames []frame
+	r := bufio.NewReader(bytes.NewReader(w.buf.Bytes()))
+	for {
+		f, err := readServerFrame(r)
+		if err == io.EOF {
+			return frames
+		}
+		if err != nil {
+			t.Fatalf("readServerFrame() unexpected error = %v", err)
+		}
+		frames = append(frames, f)
+	}
+}
+
+type frame struct {
+	opcode  int
+	payload []byte
+}
+
+// readServerFrame reads an unmasked frame.
+func readServerFrame(r *bufio.Reader) (frame, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return frame{}, err
+	}
+	length := uint64(hdr[1])
+	switch length {
+	case 126:
+		var ext [2]byte
+		io.ReadFull(r, ext[:])
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		io.ReadFull(r, ext[:])
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	payload := make([]byte, length)
+	_, err := io.ReadFull(r, payload)
+	return frame{opcode: int(hdr[0] & 0x0f), payload: payload}, err
+}
+
+// clientFrame returns a masked frame, as sent by a client.
+func clientFrame(opcode int, final bool, payload []byte) []byte {
+	mask := [4]byte{1, 2, 3, 4}
+	b := []byte{byte(opcode), maskBit}
+	if final {
+		b[0] |= finalBit
+	}
+	switch {
+	case len(payload) <= 125:
+		b[1] |= byte(len(payload))
+	default:
+		b[1] |= 126
+		b = binary.BigEndian.AppendUint16(b, uint16(len(payload)))
+	}
+	b = append(b, mask[:]...)
+	for i, c := range payload {
+		b = append(b, c^mask[i%4])
+	}
+	return b
+}
+
+func newTestConn(input ...[]byte) (*h2Conn, *fakeResponseWriter) {
+	rw := &fakeResponseWriter{header: http.Header{}}
+	body := io.NopCloser(bytes.NewReader(bytes.Join(input, nil)))
+	return newH2Conn(rw, body, nil), rw
+}
+
+func Test_h2Conn_NextReader(t *testing.T) {
+	c, rw := newTestConn(
+		clientFrame(websocket.TextMessage, false, []byte("hel")),
+		clientFrame(websocket.PingMessage, true, []byte("ping")),
+		clientFrame(continuationFrame, true, []byte("lo")),
+		clientFrame(websocket.PongMessage, true, []byte("pong")),
+		clientFrame(websocket.BinaryMessage, true, bytes.Repeat([]byte{7}, 1000)),
+		clientFrame(websocket.TextMessage, true, []byte("skipped")),
+		clientFrame(websocket.CloseMessage, true, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "bye")),
+	)
+	var pongs []string
+	c.SetPongHandler(func(s string) error {
+		pongs = append(pongs, s)
+		return nil
+	})
+
+	mtype, r, err := c.NextReader()
+	if err != nil || mtype != websocket.TextMessage {
+		t.Fatalf("NextReader() = %d, %v, want text message", mtype, err)
+	}
+	b, err := io.ReadAll(r)
+	if err != nil || string(b) != "hello" {
+		t.Errorf("ReadAll() = %q, %v, want %q", b, err, "hello")
+	}
+	mtype, r, err = c.NextReader()
+	if err != nil || mtype != websocket.BinaryMessage {
+		t.Fatalf("NextReader() = %d, %v, want binary message", mtype, err)
+	}
+	n, err := io.Copy(io.Discard, r)
+	if err != nil || n != 1000 {
+		t.Errorf("Copy() = %d, %v, want 1000", n, err)
+	}
+	// Leave the next message unread.
+	if _, _, err = c.NextReader(); err != nil {
+		t.Fatalf("NextReader() unexpected error = %v", err)
+	}
+	_, _, err = c.NextReader()
+	if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Errorf("NextReader() error = %v, want close error", err)
+	}
+	if len(pongs) != 1 || pongs[0] != "pong" {
+		t.Errorf("pong handler got %v, want [pong]", pongs)
+	}
+
+	// The server should have replied to the ping and the close messages.
+	frames := rw.frames(t)
+	if len(frames) != 2 {
+		t.Fatalf("server sent %d frames, want 2", len(frames))
+	}
+	if frames[0].opcode != websocket.PongMessage || string(frames[0].payload) != "ping" {
+		t.Errorf("server sent %v, want pong", frames[0])
+	}
+	if frames[1].opcode != websocket.CloseMessage ||
+		binary.BigEndian.Uint16(frames[1].payload) != websocket.CloseNormalClosure {
+		t.Errorf("server sent %v, want close", frames[1])
+	}
+	if err := c.WriteMessage(websocket.TextMessage, []byte("late")); err != websocket.ErrCloseSent {
+		t.Errorf("WriteMessage() after close error = %v, want %v", err, websocket.ErrCloseSent)
+	}
+}
+
+func Test_h2Conn_NextReaderErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []byte
+		limit int64
+		want  error
+	}{
+		{
+			name:  "unmasked-frame",
+			input: []byte{finalBit | websocket.TextMessage, 2, 'h', 'i'},
+			want:  errUnmaskedFrame,
+		},
+		{
+			name:  "read-limit",
+			input: clientFrame(websocket.BinaryMessage, true, make([]byte, 200)),
+			limit: 100,
+			want:  websocket.ErrReadLimit,
+		},
+		{
+			name:  "unexpected-continuation",
+			input: clientFrame(continuationFrame, true, []byte("hi")),
+			want:  errBadFrame,
+		},
+		{
+			name:  "truncated-frame",
+			input: clientFrame(websocket.TextMessage, true, []byte("hi"))[:3],
+			want:  io.ErrUnexpectedEOF,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newTestConn(tt.input)
+			c.SetReadLimit(tt.limit)
+			if _, _, err := c.NextReader(); err != tt.want {
+				t.Errorf("NextReader() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func Test_h2Conn_Write(t *testing.T) {
+	c, rw := newTestConn()
+	sizes := []int{10, 300, 70000}
+	for _, size := range sizes {
+		m, err := NewPreparedMessage(make([]byte, size))
+		if err != nil {
+			t.Fatalf("NewPreparedMessage() unexpected error = %v", err)
+		}
+		if err := WritePreparedMessage(c, m); err != nil {
+			t.Fatalf("WritePreparedMessage() unexpected error = %v", err)
+		}
+	}
+	if err := c.WriteJSON(map[string]int{"a": 1}); err != nil {
+		t.Fatalf("WriteJSON() unexpected error = %v", err)
+	}
+	if err := c.WriteControl(websocket.PingMessage, []byte("x"), time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("WriteControl() unexpected error = %v", err)
+	}
+	if err := c.WriteControl(websocket.PingMessage, make([]byte, 126), time.Time{}); err == nil {
+		t.Errorf("WriteControl() expected error for large control message")
+	}
+	frames := rw.frames(t)
+	if len(frames) != len(sizes)+2 {
+		t.Fatalf("server sent %d frames, want %d", len(frames), len(sizes)+2)
+	}
+	for i, size := range sizes {
+		if frames[i].opcode != websocket.BinaryMessage || len(frames[i].payload) != size {
+			t.Errorf("frame %d = type %d, size %d, want binary of size %d",
+				i, frames[i].opcode, len(frames[i].payload), size)
+		}
+	}
+	if f := frames[len(sizes)]; f.opcode != websocket.TextMessage || string(f.payload) != `{"a":1}` {
+		t.Errorf("WriteJSON() sent %v", f)
+	}
+	if f := frames[len(sizes)+1]; f.opcode != websocket.PingMessage || string(f.payload) != "x" {
+		t.Errorf("WriteControl() sent %v", f)
+	}
+}
+
+func TestAcceptH2(t *testing.T) {
+	newRequest := func(proto int) *http.Request {
+		req := httptest.NewRequest(http.MethodConnect, "/ndt/v7/download", strings.NewReader(""))
+		req.ProtoMajor = proto
+		req.Header.Set(":protocol", "websocket")
+		req.Header.Set("Sec-WebSocket-Version", "13")
+		return req
+	}
+	tests := []struct {
+		name     string
+		req      *http.Request
+		conn     net.Conn
+		wantCode int
+	}{
+		{
+			name:     "success",
+			req:      newRequest(2),
+			conn:     &net.TCPConn{},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "not-http2",
+			req:      newRequest(1),
+			conn:     &net.TCPConn{},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "missing-conn",
+			req:      newRequest(2),
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := tt.req
+			if tt.conn != nil {
+				req = req.WithContext(ConnContext(context.Background(), tt.conn))
+			}
+			rec := httptest.NewRecorder()
+			headers := http.Header{"Sec-Websocket-Protocol": {"test"}}
+			conn, err := AcceptH2(rec, req, headers)
+			if rec.Code != tt.wantCode {
+				t.Errorf("AcceptH2() code = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if (err == nil) != (tt.wantCode == http.StatusOK) {
+				t.Errorf("AcceptH2() error = %v", err)
+			}
+			if err == nil {
+				if conn.UnderlyingConn() != tt.conn {
+					t.Errorf("AcceptH2() UnderlyingConn = %v, want %v", conn.UnderlyingConn(), tt.conn)
+				}
+				if StreamIndex(conn) != 1 {
+					t.Errorf("AcceptH2() StreamIndex = %d, want 1", StreamIndex(conn))
+				}
+				if rec.Header().Get("Sec-Websocket-Protocol") != "test" {
+					t.Errorf("AcceptH2() response header = %v", rec.Header())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+// Package transport abstracts the message-oriented connection used by the
+// ndt7 subtests. The subtests may run over a WebSocket established with an
+// HTTP/1.1 Upgrade, i.e., a *websocket.Conn, or over a WebSocket bootstrapped
+// on an HTTP/2 stream using the Extended CONNECT method (RFC 8441).
+package transport
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Conn is the connection used by the ndt7 subtests. The method set follows
+// the semantics of *websocket.Conn, which implements this interface. Message
+// types are the websocket package constants, e.g., websocket.TextMessage.
+//
+// Like *websocket.Conn, a Conn supports one concurrent reader and one
+// concurrent writer. WriteControl and Close may be called concurrently with
+// all other methods.
+type Conn interface {
+	NextReader() (messageType int, r io.Reader, err error)
+	WriteMessage(messageType int, data []byte) error
+	WriteJSON(v interface{}) error
+	WriteControl(messageType int, data []byte, deadline time.Time) error
+	SetReadLimit(limit int64)
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+	SetPongHandler(h func(appData string) error)
+	LocalAddr() net.Addr
+	RemoteAddr() net.Addr
+	// UnderlyingConn returns the TCP connection carrying the messages. With
+	// HTTP/2, the TCP connection may be shared with other streams.
+	UnderlyingConn() net.Conn
+	Close() error
+}
+
+var _ Conn = &websocket.Conn{}
+
+type connKey struct{}
+
+// connState is the state of a TCP connection shared by its HTTP/2 streams.
+type connState struct {
+	conn net.Conn
+	// streams counts the WebSockets accepted on the connection.
+	streams atomic.Int64
+}
+
+// ConnContext saves c in ctx. It is meant to be used as the ConnContext of the
+// http.Server accepting ndt7 connections, so that HTTP/2 streams can access
+// their TCP connection, which is not otherwise available to handlers.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, &connState{conn: c})
+}
+
+// StreamIndex returns the index, starting at 1, of the WebSocket among those
+// bootstrapped on the same HTTP/2 connection, or zero for other connections.
+func StreamIndex(conn Conn) int64 {
+	if c, ok := conn.(*h2Conn); ok {
+		return c.stream
+	}
+	return 0
+}
+
+// IsExtendedConnect returns whether req is an HTTP/2 Extended CONNECT request
+// bootstrapping a WebSocket (RFC 8441).
+func IsExtendedConnect(req *http.Request) bool {
+	return req.ProtoMajor == 2 && req.Method == http.MethodConnect &&
+		req.Header.Get(":protocol") == "websocket"
+}
+
+// PreparedMessage is a binary message written repeatedly, e.g., by the
+// download sender. Over an HTTP/1.1 WebSocket, it is framed only once.
+type PreparedMessage struct {
+	data     []byte
+	prepared *websocket.PreparedMessage
+}
+
+// NewPreparedMessage returns a PreparedMessage with the given payload.
+func NewPreparedMessage(data []byte) (*PreparedMessage, error) {
+	prepared, err := websocket.NewPreparedMessage(websocket.BinaryMessage, data)
+	if err != nil {
+		return nil, err
+	}
+	return &PreparedMessage{data: data, prepared: prepared}, nil
+}
+
+// WritePreparedMessage writes m to conn. A *websocket.Conn writes the frame
+// prepared in advance, while other connections frame the payload on every
+// write.
+func WritePreparedMessage(conn Conn, m *PreparedMessage) error {
+	if ws, ok := conn.(*websocket.Conn); ok {
+		return ws.WritePreparedMessage(m.prepared)
+	}
+	return conn.WriteMessage(websocket.BinaryMessage, m.data)
+}
@@ -3,9 +3,9 @@ package sender
 
 import (
 	"context"
+	"sync/atomic"
 	"time"
 
-	"github.com/gorilla/websocket"
 	"github.com/m-lab/ndt-server/logging"
 	"github.com/m-lab/ndt-server/ndt7/closer"
 	"github.com/m-lab/ndt-server/ndt7/measurer"
@@ -13,26 +13,34 @@ import (
 	"github.com/m-lab/ndt-server/ndt7/model"
 	"github.com/m-lab/ndt-server/ndt7/ping"
 	"github.com/m-lab/ndt-server/ndt7/spec"
+	"github.com/m-lab/ndt-server/ndt7/stability"
+	"github.com/m-lab/ndt-server/ndt7/summary"
+	"github.com/m-lab/ndt-server/ndt7/transport"
 )
 
 // Start sends measurement messages (status messages) to the client conn. Each
-// measurement message will also be saved to data.
+// measurement message will also be saved to data. If the client requested an
+// early exit, the sender starts closing the conn once the thresholds in params
+// are reached. The received argument is the number of application-level bytes
+// read so far by the upload receiver. After each measurement message, the
+// sender sends a ping using pinger and includes the latest RTT sample in the
+// next measurement message.
 //
 // Liveness guarantee: the sender will not be stuck sending for more than the
 // MaxRuntime of the subtest. This is enforced by setting the write deadline to
 // Time.Now() + MaxRuntime.
-func Start(ctx context.Context, conn *websocket.Conn, data *model.ArchivalData) error {
+func Start(ctx context.Context, conn transport.Conn, data *model.ArchivalData, params *spec.Params, pinger *ping.Pinger, received *atomic.Int64) error {
 	logging.Logger.Debug("sender: start")
 	proto := ndt7metrics.ConnLabel(conn)
 
 	// Start collecting connection measurements. Measurements will be sent to
-	// src until DefaultRuntime, when the src channel is closed.
+	// src until params.Runtime, when the src channel is closed.
 	mr := measurer.New(conn, data.UUID)
-	src := mr.Start(ctx, spec.DefaultRuntime)
+	src := mr.Start(ctx, params.Runtime)
 	defer logging.Logger.Debug("sender: stop")
 	defer mr.Stop(src)
 
-	deadline := time.Now().Add(spec.MaxRuntime)
+	deadline := time.Now().Add(spec.MaxRuntimeFor(params.Runtime))
 	err := conn.SetWriteDeadline(deadline) // Liveness!
 	if err != nil {
 		logging.Logger.WithError(err).Warn("sender: conn.SetWriteDeadline failed")
@@ -42,18 +50,42 @@ func Start(ctx context.Context, conn *websocket.Conn, data *model.ArchivalData)
 	}
 
 	// Record measurement start time, and prepare recording of the endtime on return.
-	data.StartTime = time.Now().UTC()
+	start := time.Now()
+	data.StartTime = start.UTC()
 	defer func() {
 		data.EndTime = time.Now().UTC()
+		data.ActualDuration = time.Since(start)
 	}()
+	// finish records why the subtest ended, sends the summary of the subtest
+	// to the client and starts closing the connection.
+	finish := func(reason, label string) error {
+		data.TerminationReason = reason
+		if err := summary.Send(conn, spec.SubtestUpload, data); err != nil {
+			logging.Logger.WithError(err).Warn("sender: summary.Send failed")
+			ndt7metrics.ClientSenderErrors.WithLabelValues(
+				proto, string(spec.SubtestUpload), "write-summary").Inc()
+			return err
+		}
+		closer.StartClosing(conn)
+		ndt7metrics.ClientSenderErrors.WithLabelValues(
+			proto, string(spec.SubtestUpload), label).Inc()
+		return nil
+	}
+	var detector *stability.Detector
+	if params.Stability != nil {
+		detector = stability.New(*params.Stability)
+	}
 	for {
 		m, ok := <-src
 		if !ok { // This means that the previous step has terminated
-			closer.StartClosing(conn)
-			ndt7metrics.ClientSenderErrors.WithLabelValues(
-				proto, string(spec.SubtestUpload), "measurer-closed").Inc()
-			return nil
+			return finish(model.TerminationRuntime, "measurer-closed")
+		}
+		// Report the application-level bytes received so far.
+		m.AppInfo = &model.AppInfo{
+			NumBytes:    received.Load(),
+			ElapsedTime: int64(time.Since(start) / time.Microsecond),
 		}
+		m.WSPingInfo = pinger.Latest()
 		if err := conn.WriteJSON(m); err != nil {
 			logging.Logger.WithError(err).Warn("sender: conn.WriteJSON failed")
 			ndt7metrics.ClientSenderErrors.WithLabelValues(
@@ -62,11 +94,24 @@ func Start(ctx context.Context, conn *websocket.Conn, data *model.ArchivalData)
 		}
 		// Only save measurements sent to the client.
 		data.ServerMeasurements = append(data.ServerMeasurements, m)
-		if err := ping.SendTicks(conn, deadline); err != nil {
+		if params.Recorder != nil {
+			params.Recorder.RecordServerMeasurement(m)
+		}
+		if err := pinger.SendTicks(conn, deadline); err != nil {
 			logging.Logger.WithError(err).Warn("sender: ping.SendTicks failed")
 			ndt7metrics.ClientSenderErrors.WithLabelValues(
 				proto, string(spec.SubtestUpload), "ping-send-ticks").Inc()
 			return err
 		}
+		// End the test once enough bytes have been received.
+		if m.TCPInfo != nil &&
+			params.ShouldExitEarly(m.TCPInfo.BytesReceived, time.Since(start)) {
+			return finish(model.TerminationEarlyExit, "measurer-closed-early")
+		}
+		// End the test once the rate has converged.
+		if detector != nil && m.TCPInfo != nil &&
+			detector.Update(m.TCPInfo.BytesReceived, time.Since(start)) {
+			return finish(model.TerminationStableRate, "measurer-closed-stable")
+		}
 	}
 }
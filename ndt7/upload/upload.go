@@ -3,28 +3,39 @@ package upload
 
 import (
 	"context"
+	"sync/atomic"
+	"time"
 
-	"github.com/gorilla/websocket"
 	"github.com/m-lab/ndt-server/ndt7/model"
+	"github.com/m-lab/ndt-server/ndt7/ping"
 	"github.com/m-lab/ndt-server/ndt7/receiver"
+	"github.com/m-lab/ndt-server/ndt7/spec"
+	"github.com/m-lab/ndt-server/ndt7/transport"
 	"github.com/m-lab/ndt-server/ndt7/upload/sender"
 )
 
 // Do implements the upload subtest. The ctx argument is the parent context for
 // the subtest. The conn argument is the open WebSocket connection. The data
-// argument is the archival data where results are saved. All arguments are
-// owned by the caller of this function.
-func Do(ctx context.Context, conn *websocket.Conn, data *model.ArchivalData) error {
+// argument is the archival data where results are saved. The params argument
+// contains the client parameters for this subtest. All arguments are owned by
+// the caller of this function.
+func Do(ctx context.Context, conn transport.Conn, data *model.ArchivalData, params *spec.Params) error {
 	// Implementation note: use child contexts so the sender is strictly time
 	// bounded. After timeout, the sender closes the conn, which results in the
 	// receiver completing.
 
+	// The receiver counts the bytes received and the sender reports them.
+	var received atomic.Int64
+	// The sender sends pings and the receiver parses the pongs, using the
+	// same time reference for the whole test.
+	pinger := ping.New(time.Now())
+
 	// Receive and save client-provided measurements in data.
-	recv := receiver.StartUploadReceiverAsync(ctx, conn, data)
+	recv := receiver.StartUploadReceiverAsync(ctx, conn, data, params.Recorder, spec.MaxRuntimeFor(params.Runtime), pinger, &received)
 
 	// Perform upload and save server-measurements in data.
 	// TODO: move sender.Start logic to this file.
-	err := sender.Start(ctx, conn, data)
+	err := sender.Start(ctx, conn, data, params, pinger, &received)
 
 	// Block on the receiver completing to guarantee that access to data is synchronous.
 	<-recv.Done()
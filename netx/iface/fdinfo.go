@@ -8,7 +8,6 @@ import (
 
 	"github.com/m-lab/ndt-server/bbr"
 	"github.com/m-lab/ndt-server/tcpinfox"
-	"github.com/m-lab/tcp-info/inetdiag"
 	"github.com/m-lab/tcp-info/tcp"
 	"github.com/m-lab/uuid"
 )
@@ -21,7 +20,7 @@ type ConnFile interface {
 // NetInfo provides access to network connection metadata.
 type NetInfo interface {
 	GetUUID(fp *os.File) (string, error)
-	GetBBRInfo(fp *os.File) (inetdiag.BBRInfo, error)
+	GetCCInfo(fp *os.File, algorithm string) (bbr.CCInfo, error)
 	GetTCPInfo(fp *os.File) (*tcp.LinuxTCPInfo, error)
 }
 
@@ -54,9 +53,10 @@ func (f *RealConnInfo) GetUUID(fp *os.File) (string, error) {
 	return uuid.FromFile(fp)
 }
 
-// GetBBRInfo returns BBRInfo for the given file pointer.
-func (f *RealConnInfo) GetBBRInfo(fp *os.File) (inetdiag.BBRInfo, error) {
-	return bbr.GetBBRInfo(fp)
+// GetCCInfo returns the congestion control info, e.g., BBR variables, for the
+// given file pointer, whose congestion control algorithm is named algorithm.
+func (f *RealConnInfo) GetCCInfo(fp *os.File, algorithm string) (bbr.CCInfo, error) {
+	return bbr.GetCCInfoOf(fp, algorithm)
 }
 
 // GetTCPInfo returns TCPInfo for the given file pointer.
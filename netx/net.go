@@ -15,7 +15,6 @@ import (
 	"github.com/m-lab/go/rtx"
 	"github.com/m-lab/ndt-server/bbr"
 	"github.com/m-lab/ndt-server/netx/iface"
-	"github.com/m-lab/tcp-info/inetdiag"
 	"github.com/m-lab/tcp-info/tcp"
 )
 
@@ -53,6 +52,11 @@ type Conn struct {
 	fp      *os.File
 	netinfo iface.NetInfo
 	once    sync.Once
+
+	// cc caches the name of the congestion control algorithm, so that
+	// ReadInfo does not read it for every sample. It is empty until read.
+	ccMu sync.Mutex
+	cc   string
 }
 
 // Addr supports the net.Addr interface and allows mediated access to operations
@@ -82,7 +86,10 @@ type Addr struct {
 type ConnInfo interface {
 	GetUUID() (string, error)
 	EnableBBR() error
-	ReadInfo() (inetdiag.BBRInfo, tcp.LinuxTCPInfo, error)
+	SetCongestionControl(name string) error
+	GetCongestionControl() (string, error)
+	SetMaxPacingRate(bytesPerSecond int64) error
+	ReadInfo() (bbr.CCInfo, tcp.LinuxTCPInfo, error)
 }
 
 // Accept a connection, set 3min keepalive, and return a Conn that enables
@@ -119,22 +126,75 @@ func (mc *Conn) Close() error {
 // EnableBBR sets the BBR congestion control on the TCP connection, if supported
 // by the kernel. If unsupported, EnableBBR has no effect.
 func (mc *Conn) EnableBBR() error {
-	return bbr.Enable(mc.fp)
+	return mc.setCongestionControl(bbr.Enable(mc.fp))
+}
+
+// SetCongestionControl sets the named congestion control algorithm on the TCP
+// connection. The kernel must support the algorithm and allow its use.
+func (mc *Conn) SetCongestionControl(name string) error {
+	return mc.setCongestionControl(bbr.SetCongestionControl(mc.fp, name))
+}
+
+// setCongestionControl updates the cached congestion control algorithm after
+// trying to set one, which failed if err is not nil. On success, the cache
+// holds the algorithm read back from the socket, i.e., the one in effect.
+func (mc *Conn) setCongestionControl(err error) error {
+	mc.ccMu.Lock()
+	defer mc.ccMu.Unlock()
+	// On failure, the cache stays empty and is filled on the next read.
+	mc.cc = ""
+	if err == nil {
+		mc.readCongestionControl()
+	}
+	return err
+}
+
+// readCongestionControl reads the congestion control algorithm from the socket
+// and caches it. The caller must hold ccMu.
+func (mc *Conn) readCongestionControl() (string, error) {
+	cc, err := bbr.GetCongestionControl(mc.fp)
+	if err != nil {
+		return "", err
+	}
+	mc.cc = cc
+	return cc, nil
+}
+
+// GetCongestionControl returns the congestion control algorithm in use on the
+// TCP connection. It is read from the socket once and then cached.
+func (mc *Conn) GetCongestionControl() (string, error) {
+	mc.ccMu.Lock()
+	defer mc.ccMu.Unlock()
+	if mc.cc != "" {
+		return mc.cc, nil
+	}
+	return mc.readCongestionControl()
+}
+
+// SetMaxPacingRate caps the pacing rate of the TCP connection, and hence the
+// rate at which data is sent, at the given number of bytes per second.
+func (mc *Conn) SetMaxPacingRate(bytesPerSecond int64) error {
+	return bbr.SetMaxPacingRate(mc.fp, bytesPerSecond)
 }
 
-// ReadInfo reads metadata about the TCP connections. If BBR was not enabled on
-// the underlying connection, then ReadInfo will return an empty BBRInfo struct.
-// If TCP info metrics cannot be read, an error is returned.
-func (mc *Conn) ReadInfo() (inetdiag.BBRInfo, tcp.LinuxTCPInfo, error) {
-	bbrinfo, err := mc.netinfo.GetBBRInfo(mc.fp)
+// ReadInfo reads metadata about the TCP connections. If the congestion control
+// info cannot be read, e.g., because the congestion control algorithm does not
+// export any, then ReadInfo will return an empty CCInfo struct. If TCP info
+// metrics cannot be read, an error is returned.
+func (mc *Conn) ReadInfo() (bbr.CCInfo, tcp.LinuxTCPInfo, error) {
+	var ccinfo bbr.CCInfo
+	cc, err := mc.GetCongestionControl()
+	if err == nil {
+		ccinfo, err = mc.netinfo.GetCCInfo(mc.fp, cc)
+	}
 	if err != nil {
-		bbrinfo = inetdiag.BBRInfo{}
+		ccinfo = bbr.CCInfo{}
 	}
 	tcpInfo, err := mc.netinfo.GetTCPInfo(mc.fp)
 	if err != nil {
-		return inetdiag.BBRInfo{}, tcp.LinuxTCPInfo{}, err
+		return bbr.CCInfo{}, tcp.LinuxTCPInfo{}, err
 	}
-	return bbrinfo, *tcpInfo, nil
+	return ccinfo, *tcpInfo, nil
 }
 
 // GetUUID returns the connection's UUID.
@@ -11,7 +11,7 @@ import (
 	"testing"
 
 	"github.com/m-lab/go/rtx"
-	"github.com/m-lab/tcp-info/inetdiag"
+	"github.com/m-lab/ndt-server/bbr"
 	"github.com/m-lab/tcp-info/tcp"
 )
 
@@ -98,8 +98,8 @@ type errorNetInfo struct{}
 func (e *errorNetInfo) GetUUID(fp *os.File) (string, error) {
 	return "", fmt.Errorf("fake get uuid error")
 }
-func (e *errorNetInfo) GetBBRInfo(fp *os.File) (inetdiag.BBRInfo, error) {
-	return inetdiag.BBRInfo{}, nil
+func (e *errorNetInfo) GetCCInfo(fp *os.File, algorithm string) (bbr.CCInfo, error) {
+	return bbr.CCInfo{}, nil
 }
 func (e *errorNetInfo) GetTCPInfo(fp *os.File) (*tcp.LinuxTCPInfo, error) {
 	return nil, fmt.Errorf("fake get tcpinfo error")
@@ -132,6 +132,26 @@ func TestConn(t *testing.T) {
 
 	ci := ToConnInfo(conn)
 	ci.EnableBBR()
+	// The reno algorithm is always built into the kernel.
+	if err := ci.SetCongestionControl("reno"); err != nil {
+		t.Errorf("ConnInfo.SetCongestionControl error: %v", err)
+	}
+	if cc, err := ci.GetCongestionControl(); err != nil || cc != "reno" {
+		t.Errorf("ConnInfo.GetCongestionControl = %q, %v, want reno", cc, err)
+	}
+	if err := ci.SetCongestionControl("not-a-real-cc"); err == nil {
+		t.Errorf("ConnInfo.SetCongestionControl expected error for unknown algorithm")
+	}
+	// A failed change leaves the cached algorithm to be read again.
+	if cc, err := ci.GetCongestionControl(); err != nil || cc != "reno" {
+		t.Errorf("ConnInfo.GetCongestionControl = %q, %v, want reno", cc, err)
+	}
+	if err := ci.SetMaxPacingRate(1000000); err != nil {
+		t.Errorf("ConnInfo.SetMaxPacingRate error: %v", err)
+	}
+	if err := ci.SetMaxPacingRate(0); err == nil {
+		t.Errorf("ConnInfo.SetMaxPacingRate expected error for zero rate")
+	}
 	id, err := ci.GetUUID()
 	if err != nil || id == "" {
 		t.Errorf("ConnInfo.GetUUID error: %#v, %q", err, id)
@@ -0,0 +1,111 @@
+// Package sink defines where the ndt5 and ndt7 servers save their results.
+package sink
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrRejected is returned, possibly wrapped, when a result is refused, e.g.,
+// because it is invalid. Unlike other errors, it does not mean that the
+// results of other tests cannot be saved.
+var ErrRejected = errors.New("sink: result rejected")
+
+// Result is a result to save. Data is the archival record of the test, i.e., a
+// *data.NDT5Result or a *data.NDT7Result.
+type Result struct {
+	// Protocol is the protocol of the test, i.e., "ndt5" or "ndt7".
+	Protocol string
+	// Kind is the kind of ndt7 subtest, e.g., "download". It is empty for ndt5.
+	Kind string `json:",omitempty"`
+	// UUID is the UUID of the test.
+	UUID string
+	// Data is the archival record of the test.
+	Data interface{}
+}
+
+// ResultSink saves results. Implementations must be safe for concurrent use.
+type ResultSink interface {
+	Save(r *Result) error
+}
+
+// FileBacked is implemented by sinks that save every result to its own file in
+// a data directory. When a subtest streams its results to a file in the same
+// data directory, the ndt7 handler skips them.
+type FileBacked interface {
+	ResultSink
+	// Dir returns the directory where the results are saved.
+	Dir() string
+}
+
+// Gate tells whether the results of new tests can be saved, e.g., whether the
+// data directory has room for them. spool.Manager implements it.
+type Gate interface {
+	// Err returns why the results of new tests cannot be saved, or nil.
+	Err() error
+	// Fail records that a result could not be saved.
+	Fail(err error)
+}
+
+// Gated is a ResultSink guarded by a Gate. Servers refuse new tests while the
+// gate is closed, see Check.
+type Gated struct {
+	ResultSink
+	Gate Gate
+}
+
+// Save implements ResultSink. Failures are recorded in the gate, except for
+// rejected results.
+func (g Gated) Save(r *Result) error {
+	err := g.ResultSink.Save(r)
+	if err != nil && !errors.Is(err, ErrRejected) {
+		g.Gate.Fail(err)
+	}
+	return err
+}
+
+// Check returns why rs cannot save the results of new tests, or nil. Only a
+// Gated sink can refuse new tests.
+func Check(rs ResultSink) error {
+	if g, ok := rs.(Gated); ok {
+		return g.Gate.Err()
+	}
+	return nil
+}
+
+// Validator checks results before they are saved, e.g., against a schema.
+type Validator interface {
+	// Validate returns why r must not be saved, or nil.
+	Validate(r *Result) error
+}
+
+// Validated is a ResultSink that only saves the results accepted by its
+// Validator.
+type Validated struct {
+	ResultSink
+	Validator Validator
+}
+
+// Save implements ResultSink. Results that the validator refuses are not saved,
+// and the returned error wraps ErrRejected.
+func (v Validated) Save(r *Result) error {
+	if err := v.Validator.Validate(r); err != nil {
+		return fmt.Errorf("%w: %v", ErrRejected, err)
+	}
+	return v.ResultSink.Save(r)
+}
+
+// Multi saves results to every sink it contains.
+type Multi []ResultSink
+
+// Save saves r to every sink, even if some of them fail. It returns the errors
+// of all the failed sinks.
+func (m Multi) Save(r *Result) error {
+	var errs []error
+	for _, s := range m {
+		if err := s.Save(r); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
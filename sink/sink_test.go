@@ -0,0 +1,86 @@
+package sink
+
+import (
+	"errors"
+	"testing"
+)
+
+type fakeSink struct {
+	saved []*Result
+	err   error
+}
+
+func (f *fakeSink) Save(r *Result) error {
+	f.saved = append(f.saved, r)
+	return f.err
+}
+
+func TestMulti_Save(t *testing.T) {
+	errSave := errors.New("save failed")
+	failing := &fakeSink{err: errSave}
+	working := &fakeSink{}
+	m := Multi{failing, working}
+	r := &Result{Protocol: "ndt7", Kind: "download", UUID: "abc"}
+	err := m.Save(r)
+	if !errors.Is(err, errSave) {
+		t.Errorf("Multi.Save() error = %v, want %v", err, errSave)
+	}
+	if len(failing.saved) != 1 || len(working.saved) != 1 || working.saved[0] != r {
+		t.Errorf("Multi.Save() did not save to every sink")
+	}
+	if err := (Multi{working}).Save(r); err != nil {
+		t.Errorf("Multi.Save() unexpected error = %v", err)
+	}
+}
+
+type fakeGate struct {
+	err error
+}
+
+func (g *fakeGate) Err() error     { return g.err }
+func (g *fakeGate) Fail(err error) { g.err = err }
+
+func TestGated(t *testing.T) {
+	errSave := errors.New("save failed")
+	s := &fakeSink{}
+	g := &fakeGate{}
+	rs := Gated{ResultSink: s, Gate: g}
+	if err := Check(rs); err != nil {
+		t.Errorf("Check() error = %v", err)
+	}
+	s.err = errSave
+	if err := rs.Save(&Result{}); !errors.Is(err, errSave) {
+		t.Errorf("Gated.Save() error = %v, want %v", err, errSave)
+	}
+	if err := Check(rs); !errors.Is(err, errSave) {
+		t.Errorf("Check() error = %v, want %v", err, errSave)
+	}
+	if err := Check(s); err != nil {
+		t.Errorf("Check() of an ungated sink error = %v", err)
+	}
+}
+
+type fakeValidator struct {
+	err error
+}
+
+func (v fakeValidator) Validate(r *Result) error { return v.err }
+
+func TestValidated(t *testing.T) {
+	errInvalid := errors.New("invalid")
+	s := &fakeSink{}
+	g := &fakeGate{}
+	rs := Gated{ResultSink: Validated{ResultSink: s, Validator: fakeValidator{errInvalid}}, Gate: g}
+	err := rs.Save(&Result{})
+	if !errors.Is(err, ErrRejected) || len(s.saved) != 0 {
+		t.Errorf("Validated.Save() = %v, saved %d, want %v", err, len(s.saved), ErrRejected)
+	}
+	// Rejected results do not close the gate.
+	if err := Check(rs); err != nil {
+		t.Errorf("Check() error = %v", err)
+	}
+	rs.ResultSink = Validated{ResultSink: s, Validator: fakeValidator{}}
+	if err := rs.Save(&Result{}); err != nil || len(s.saved) != 1 {
+		t.Errorf("Validated.Save() = %v, saved %d", err, len(s.saved))
+	}
+}
@@ -0,0 +1,221 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/m-lab/ndt-server/logging"
+)
+
+var (
+	// WebhookResults counts the results handled by webhook sinks.
+	WebhookResults = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ndt_sink_webhook_results_total",
+			Help: "A counter of results handled by the webhook sink.",
+		},
+		[]string{"status"},
+	)
+)
+
+// Webhook sends results to an HTTP endpoint with POST requests. The body of
+// every request is the JSON encoding of a Result.
+//
+// Save does not send results, but adds them to a queue on disk, so that they
+// survive restarts. Run sends the queued results in order and retries failed
+// requests with exponential backoff, until the endpoint accepts them or
+// rejects them with a 4xx status code. When the queue is full, new results are
+// dropped.
+type Webhook struct {
+	// MinBackoff and MaxBackoff bound the delay between retries.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	url       string
+	dir       string
+	maxQueued int
+	client    *http.Client
+	wake      chan struct{}
+	seq       atomic.Int64
+
+	// mu serializes the check of the queue size with the addition of results.
+	mu sync.Mutex
+	// queued is the number of queued results, so that Save does not need to
+	// read the queue.
+	queued int
+}
+
+// queueSuffix is the suffix of the queued results. Partially written results
+// have a different suffix, so they are never sent.
+const queueSuffix = ".json"
+
+// NewWebhook creates a webhook sink sending results to url. The results are
+// queued in dir, which is created if needed. At most maxQueued results are
+// queued.
+func NewWebhook(url, dir string, maxQueued int) (*Webhook, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	w := &Webhook{
+		MinBackoff: time.Second,
+		MaxBackoff: time.Minute,
+		url:        url,
+		dir:        dir,
+		maxQueued:  maxQueued,
+		client:     &http.Client{Timeout: 30 * time.Second},
+		wake:       make(chan struct{}, 1),
+	}
+	// Count the results queued before a restart.
+	names, err := w.queue()
+	if err != nil {
+		return nil, err
+	}
+	w.queued = len(names)
+	return w, nil
+}
+
+// queue returns the names of the queued results, oldest first.
+func (w *Webhook) queue() ([]string, error) {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), queueSuffix) {
+			names = append(names, e.Name())
+		}
+	}
+	// Names start with a fixed-width timestamp, see Save.
+	sort.Strings(names)
+	return names, nil
+}
+
+// Save adds r to the queue of results to send.
+func (w *Webhook) Save(r *Result) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.queued >= w.maxQueued {
+		logging.Logger.Warn("webhook: queue is full, dropping result " + r.UUID)
+		WebhookResults.WithLabelValues("dropped-queue-full").Inc()
+		return nil
+	}
+	name := fmt.Sprintf("%020d-%06d%s", time.Now().UnixNano(), w.seq.Add(1)%1000000, queueSuffix)
+	// Write to a temporary file first, so that Run never sends partial results.
+	tmp := filepath.Join(w.dir, name+".tmp")
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(w.dir, name)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	w.queued++
+	WebhookResults.WithLabelValues("queued").Inc()
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// errRejected means that the endpoint will never accept a result.
+type errRejected struct {
+	status int
+}
+
+func (e *errRejected) Error() string {
+	return fmt.Sprintf("webhook: result rejected with status %d", e.status)
+}
+
+// send sends the queued result with the given name.
+func (w *Webhook) send(ctx context.Context, name string) error {
+	b, err := os.ReadFile(filepath.Join(w.dir, name))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout &&
+		resp.StatusCode != http.StatusTooManyRequests:
+		return &errRejected{status: resp.StatusCode}
+	default:
+		return fmt.Errorf("webhook: unexpected status %d", resp.StatusCode)
+	}
+}
+
+// Run sends the queued results until ctx is done.
+func (w *Webhook) Run(ctx context.Context) {
+	backoff := w.MinBackoff
+	for ctx.Err() == nil {
+		names, err := w.queue()
+		if err != nil {
+			logging.Logger.WithError(err).Warn("webhook: cannot read queue")
+		}
+		if len(names) == 0 {
+			select {
+			case <-ctx.Done():
+			case <-w.wake:
+			}
+			continue
+		}
+		err = w.send(ctx, names[0])
+		if _, rejected := err.(*errRejected); err == nil || rejected {
+			if rejected {
+				logging.Logger.WithError(err).Warn("webhook: dropping result " + names[0])
+				WebhookResults.WithLabelValues("rejected").Inc()
+			} else {
+				WebhookResults.WithLabelValues("sent").Inc()
+			}
+			if err := os.Remove(filepath.Join(w.dir, names[0])); err == nil {
+				w.mu.Lock()
+				w.queued--
+				w.mu.Unlock()
+			}
+			backoff = w.MinBackoff
+			continue
+		}
+		logging.Logger.WithError(err).Warn("webhook: send failed, will retry")
+		WebhookResults.WithLabelValues("retry").Inc()
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > w.MaxBackoff {
+			backoff = w.MaxBackoff
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeEndpoint replies to the webhook requests with the given status codes,
+// and with 200 once they are exhausted.
+type fakeEndpoint struct {
+	mu       sync.Mutex
+	statuses []int
+	received []Result
+	accepted chan string
+}
+
+func (f *fakeEndpoint) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var r Result
+	if err := json.NewDecoder(req.Body).Decode(&r); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	f.mu.Lock()
+	status := http.StatusOK
+	if len(f.statuses) > 0 {
+		status, f.statuses = f.statuses[0], f.statuses[1:]
+	}
+	f.received = append(f.received, r)
+	f.mu.Unlock()
+	w.WriteHeader(status)
+	if status == http.StatusOK {
+		f.accepted <- r.UUID
+	}
+}
+
+func newWebhook(t *testing.T, url, dir string, maxQueued int) *Webhook {
+	w, err := NewWebhook(url, dir, maxQueued)
+	if err != nil {
+		t.Fatalf("NewWebhook() error = %v", err)
+	}
+	w.MinBackoff = time.Millisecond
+	w.MaxBackoff = 10 * time.Millisecond
+	return w
+}
+
+func waitAccepted(t *testing.T, f *fakeEndpoint) string {
+	select {
+	case uuid := <-f.accepted:
+		return uuid
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the webhook")
+	}
+	return ""
+}
+
+func TestWebhook(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		want     []string
+		requests int
+	}{
+		{
+			name:     "success",
+			want:     []string{"a", "b"},
+			requests: 2,
+		},
+		{
+			name:     "retry-then-success",
+			statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests},
+			want:     []string{"a", "b"},
+			requests: 4,
+		},
+		{
+			name:     "rejected",
+			statuses: []int{http.StatusBadRequest},
+			want:     []string{"b"},
+			requests: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &fakeEndpoint{statuses: tt.statuses, accepted: make(chan string, 10)}
+			srv := httptest.NewServer(f)
+			defer srv.Close()
+			w := newWebhook(t, srv.URL, t.TempDir(), 10)
+			for _, uuid := range []string{"a", "b"} {
+				if err := w.Save(&Result{Protocol: "ndt7", UUID: uuid}); err != nil {
+					t.Fatalf("Save() error = %v", err)
+				}
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go w.Run(ctx)
+			for _, want := range tt.want {
+				if got := waitAccepted(t, f); got != want {
+					t.Errorf("accepted %q, want %q", got, want)
+				}
+			}
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			if len(f.received) != tt.requests {
+				t.Errorf("got %d requests, want %d", len(f.received), tt.requests)
+			}
+		})
+	}
+}
+
+func TestWebhook_QueueFull(t *testing.T) {
+	dir := t.TempDir()
+	w := newWebhook(t, "http://127.0.0.1:0/", dir, 2)
+	for _, uuid := range []string{"a", "b", "c"} {
+		if err := w.Save(&Result{Protocol: "ndt5", UUID: uuid}); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+	}
+	// The queue stays full after a restart.
+	w = newWebhook(t, "http://127.0.0.1:0/", dir, 2)
+	if err := w.Save(&Result{Protocol: "ndt5", UUID: "d"}); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	names, err := w.queue()
+	if err != nil {
+		t.Fatalf("queue() error = %v", err)
+	}
+	if len(names) != 2 {
+		t.Errorf("queue() returned %d results, want 2", len(names))
+	}
+}
+
+func TestWebhook_Persistence(t *testing.T) {
+	dir := t.TempDir()
+	// Results queued while the endpoint is down are kept on disk.
+	w := newWebhook(t, "http://127.0.0.1:0/", dir, 10)
+	if err := w.Save(&Result{Protocol: "ndt7", UUID: "a"}); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	// A new webhook using the same queue, e.g., after a restart, sends them.
+	f := &fakeEndpoint{accepted: make(chan string, 10)}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	w = newWebhook(t, srv.URL, dir, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx)
+	if got := waitAccepted(t, f); got != "a" {
+		t.Errorf("accepted %q, want %q", got, "a")
+	}
+	// Sent results leave the queue.
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		w.mu.Lock()
+		queued := w.queued
+		w.mu.Unlock()
+		if queued == 0 {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("queued = %d, want 0", queued)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package spool
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// tempSuffix is the suffix of the files being written.
+const tempSuffix = ".tmp"
+
+// File is a file that only appears under its name once it is closed. Until
+// then, it is written to a hidden temporary file in the same directory.
+type File struct {
+	*os.File
+	name string
+}
+
+// Create creates a file that will be named name once closed.
+func Create(name string) (*File, error) {
+	dir, base := filepath.Split(name)
+	fp, err := os.CreateTemp(dir, "."+base+".*"+tempSuffix)
+	if err != nil {
+		return nil, err
+	}
+	if err := fp.Chmod(0644); err != nil {
+		fp.Close()
+		os.Remove(fp.Name())
+		return nil, err
+	}
+	return &File{File: fp, name: name}, nil
+}
+
+// Name returns the final name of the file.
+func (f *File) Name() string {
+	return f.name
+}
+
+// Close closes the file and gives it its final name. Like O_EXCL, it fails if
+// a file with the final name already exists.
+func (f *File) Close() error {
+	tmp := f.File.Name()
+	err := f.File.Close()
+	if err == nil {
+		err = os.Link(tmp, f.name)
+	}
+	os.Remove(tmp)
+	return err
+}
+
+// Abort closes and removes the file, which never appears under its name.
+func (f *File) Abort() {
+	f.File.Close()
+	os.Remove(f.File.Name())
+}
+
+// FinalName returns the name that the temporary file tmp of a File gets once
+// closed, and whether tmp is the name of such a temporary file.
+func FinalName(tmp string) (string, bool) {
+	dir, base := filepath.Split(tmp)
+	if !strings.HasPrefix(base, ".") || !strings.HasSuffix(base, tempSuffix) {
+		return "", false
+	}
+	// Drop the random string added by Create.
+	i := strings.LastIndex(strings.TrimSuffix(base, tempSuffix), ".")
+	if i <= 1 {
+		return "", false
+	}
+	return dir + base[1:i], true
+}
+
+// Recover gives its final name to the temporary file tmp of a File that was
+// never closed, e.g., because the process died while writing it, and returns
+// the final name. Like Close, it fails if a file with the final name already
+// exists, in which case tmp is left in place.
+func Recover(tmp string) (string, error) {
+	name, ok := FinalName(tmp)
+	if !ok {
+		return "", &os.PathError{Op: "recover", Path: tmp, Err: os.ErrInvalid}
+	}
+	if err := os.Link(tmp, name); err != nil {
+		return "", err
+	}
+	return name, os.Remove(tmp)
+}
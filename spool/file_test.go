@@ -0,0 +1,94 @@
+package spool
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCreate(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "result.json")
+	fp, err := Create(name)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if fp.Name() != name {
+		t.Errorf("Name() = %q, want %q", fp.Name(), name)
+	}
+	if _, err := fp.Write([]byte("{}")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("file exists before Close; err = %v", err)
+	}
+	if err := fp.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	b, err := os.ReadFile(name)
+	if err != nil || string(b) != "{}" {
+		t.Errorf("ReadFile() = %q, %v", b, err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("temporary file left behind: %v", entries)
+	}
+
+	// A second file with the same name must not replace the first one.
+	fp, err = Create(name)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := fp.Close(); !os.IsExist(err) {
+		t.Errorf("Close() error = %v, want EEXIST", err)
+	}
+
+	// An aborted file never appears.
+	aborted := filepath.Join(dir, "aborted.json")
+	fp, err = Create(aborted)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	fp.Abort()
+	entries, _ = os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("aborted file left behind: %v", entries)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "result.jsonl")
+	fp, err := Create(name)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if _, err := fp.Write([]byte("{}\n")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	// Pretend that the process died before Close.
+	fp.File.Close()
+	tmp := fp.File.Name()
+	if got, ok := FinalName(tmp); !ok || got != name {
+		t.Errorf("FinalName() = %q, %v, want %q", got, ok, name)
+	}
+	for _, other := range []string{name, filepath.Join(dir, ".check-123.tmp"), filepath.Join(dir, ".x.tmp")} {
+		if _, ok := FinalName(other); ok {
+			t.Errorf("FinalName(%q) is a temporary file", other)
+		}
+	}
+	got, err := Recover(tmp)
+	if err != nil || got != name {
+		t.Fatalf("Recover() = %q, %v, want %q", got, err, name)
+	}
+	b, err := os.ReadFile(name)
+	if err != nil || string(b) != "{}\n" {
+		t.Errorf("ReadFile() = %q, %v", b, err)
+	}
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind; err = %v", err)
+	}
+	if _, err := Recover(name); err == nil {
+		t.Errorf("Recover() of a final name succeeded")
+	}
+}
@@ -0,0 +1,243 @@
+// Package spool manages the directory where the server saves its results.
+//
+// The Manager checks that the directory has enough free space and inodes for
+// new results, and optionally deletes the oldest results above a quota. Files
+// created with Create only appear under their final name once they are
+// complete, so that the processes uploading results never read partial files.
+package spool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/m-lab/ndt-server/logging"
+)
+
+var (
+	// ErrNoSpace is returned when the free space is below the minimum.
+	ErrNoSpace = errors.New("spool: not enough free space")
+	// ErrNoInodes is returned when the free inodes are below the minimum.
+	ErrNoInodes = errors.New("spool: not enough free inodes")
+
+	// errNoSupport is returned when the free space cannot be measured.
+	errNoSupport = errors.New("spool: free space not supported on this platform")
+)
+
+var (
+	// Writable is 1 when the spool accepts new results and 0 otherwise.
+	Writable = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "ndt_spool_writable",
+			Help: "Whether the spool directory accepts new results.",
+		},
+	)
+	// WriteErrors counts the results that could not be written.
+	WriteErrors = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "ndt_spool_write_errors_total",
+			Help: "A counter of results that could not be written to the spool.",
+		},
+	)
+	// DeletedFiles counts the files deleted by the retention policy.
+	DeletedFiles = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "ndt_spool_deleted_files_total",
+			Help: "A counter of files deleted by the spool retention policy.",
+		},
+	)
+)
+
+// staleTempAge is the age after which temporary files, e.g., left behind by a
+// crash, may be recovered or deleted by the retention policy.
+const staleTempAge = time.Hour
+
+// Manager monitors a spool directory.
+type Manager struct {
+	// Dir is the spool directory.
+	Dir string
+	// MinFreeBytes and MinFreeInodes are the free space and inodes below which
+	// the spool does not accept new results.
+	MinFreeBytes  uint64
+	MinFreeInodes uint64
+	// MaxBytes is the quota of the spool. Above it, the oldest files are
+	// deleted. There is no quota when zero.
+	MaxBytes int64
+	// Interval is the interval between checks in Run.
+	Interval time.Duration
+	// OnChange, when not nil, is called when the spool becomes writable or
+	// stops being writable.
+	OnChange func(writable bool)
+	// Recover, when not nil, is called by Enforce for the stale temporary
+	// files, e.g., left behind by a crash, which are otherwise deleted like
+	// any other file. It returns the final name of the file, or false if the
+	// file cannot be recovered, e.g., because it was not written incrementally.
+	Recover func(tmp string) (string, bool)
+
+	mu  sync.Mutex
+	err error
+}
+
+// Err returns the reason why the spool does not accept new results, or nil.
+func (m *Manager) Err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.err
+}
+
+// Writable returns whether the spool accepts new results.
+func (m *Manager) Writable() bool {
+	return m.Err() == nil
+}
+
+func (m *Manager) setErr(err error) {
+	m.mu.Lock()
+	changed := (m.err == nil) != (err == nil)
+	m.err = err
+	m.mu.Unlock()
+	if err != nil {
+		Writable.Set(0)
+	} else {
+		Writable.Set(1)
+	}
+	if !changed {
+		return
+	}
+	if err != nil {
+		logging.Logger.WithError(err).Warn("spool: not accepting new results")
+	} else {
+		logging.Logger.Info("spool: accepting new results")
+	}
+	if m.OnChange != nil {
+		m.OnChange(err == nil)
+	}
+}
+
+// Fail records that a result could not be written. The spool does not accept
+// new results until the next successful Check.
+func (m *Manager) Fail(err error) {
+	WriteErrors.Inc()
+	m.setErr(err)
+}
+
+// Check verifies that the spool has enough free space and inodes, and that
+// files can be created in it. It updates and returns the status of the spool.
+func (m *Manager) Check() error {
+	err := m.check()
+	m.setErr(err)
+	return err
+}
+
+func (m *Manager) check() error {
+	if err := os.MkdirAll(m.Dir, 0755); err != nil {
+		return err
+	}
+	bytes, inodes, err := freeSpace(m.Dir)
+	switch {
+	case err == errNoSupport:
+		// Rely on the write test below.
+	case err != nil:
+		return err
+	case bytes < m.MinFreeBytes:
+		return fmt.Errorf("%w: %d bytes free", ErrNoSpace, bytes)
+	case inodes < m.MinFreeInodes:
+		return fmt.Errorf("%w: %d inodes free", ErrNoInodes, inodes)
+	}
+	fp, err := os.CreateTemp(m.Dir, ".check-*"+tempSuffix)
+	if err != nil {
+		return err
+	}
+	fp.Close()
+	return os.Remove(fp.Name())
+}
+
+type spoolFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// Enforce deletes the oldest files until the spool is within MaxBytes. Stale
+// temporary files are first given to Recover, and deleted like any other file
+// if it cannot recover them.
+func (m *Manager) Enforce() error {
+	if m.MaxBytes <= 0 {
+		return nil
+	}
+	var files []spoolFile
+	var total int64
+	err := filepath.WalkDir(m.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			// The file was removed in the meantime.
+			return nil
+		}
+		total += info.Size()
+		if strings.HasSuffix(path, tempSuffix) {
+			if time.Since(info.ModTime()) < staleTempAge {
+				// Files being written.
+				return nil
+			}
+			if name, ok := m.recover(path); ok {
+				path = name
+			}
+		}
+		files = append(files, spoolFile{path, info.Size(), info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+	for _, f := range files {
+		if total <= m.MaxBytes {
+			break
+		}
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		total -= f.size
+		DeletedFiles.Inc()
+	}
+	return nil
+}
+
+// recover gives the stale temporary file tmp its final name, if possible.
+func (m *Manager) recover(tmp string) (string, bool) {
+	if m.Recover == nil {
+		return "", false
+	}
+	return m.Recover(tmp)
+}
+
+// Run enforces the quota and checks the spool every Interval until ctx is done.
+func (m *Manager) Run(ctx context.Context) {
+	t := time.NewTicker(m.Interval)
+	defer t.Stop()
+	for {
+		if err := m.Enforce(); err != nil {
+			logging.Logger.WithError(err).Warn("spool: cannot enforce quota")
+		}
+		m.Check()
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
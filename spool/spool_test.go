@@ -0,0 +1,140 @@
+package spool
+
+import (
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestManager_Check(t *testing.T) {
+	var changes []bool
+	m := &Manager{
+		Dir: t.TempDir(),
+		OnChange: func(writable bool) {
+			changes = append(changes, writable)
+		},
+	}
+	if err := m.Check(); err != nil || !m.Writable() {
+		t.Fatalf("Check() error = %v", err)
+	}
+	m.Fail(errors.New("write failed"))
+	if m.Writable() {
+		t.Errorf("Writable() = true after Fail")
+	}
+	if err := m.Check(); err != nil || !m.Writable() {
+		t.Errorf("Check() error = %v", err)
+	}
+	if len(changes) != 2 || changes[0] || !changes[1] {
+		t.Errorf("OnChange calls = %v, want [false true]", changes)
+	}
+
+	if runtime.GOOS != "linux" {
+		return
+	}
+	m.MinFreeBytes = math.MaxUint64
+	if err := m.Check(); !errors.Is(err, ErrNoSpace) {
+		t.Errorf("Check() error = %v, want %v", err, ErrNoSpace)
+	}
+	m.MinFreeBytes = 0
+	m.MinFreeInodes = math.MaxUint64
+	if err := m.Check(); !errors.Is(err, ErrNoInodes) {
+		// Some file systems, e.g., btrfs, do not report free inodes.
+		t.Logf("Check() error = %v, want %v", err, ErrNoInodes)
+	}
+}
+
+func TestManager_Enforce(t *testing.T) {
+	dir := t.TempDir()
+	m := &Manager{Dir: dir, MaxBytes: 25}
+	now := time.Now()
+	files := []struct {
+		name string
+		age  time.Duration
+	}{
+		{"2024/01/01/old.json", 3 * time.Hour},
+		{"2024/01/02/middle.json", 2 * time.Hour},
+		{"2024/01/03/new.json", time.Hour},
+		{"2024/01/03/.current.json.123.tmp", 0},
+	}
+	for _, f := range files {
+		name := filepath.Join(dir, f.name)
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, make([]byte, 10), 0644); err != nil {
+			t.Fatal(err)
+		}
+		mtime := now.Add(-f.age)
+		if err := os.Chtimes(name, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Enforce(); err != nil {
+		t.Fatalf("Enforce() error = %v", err)
+	}
+	// 40 bytes must go down to 25: the two oldest files are deleted, while the
+	// file being written is kept.
+	for i, f := range files {
+		_, err := os.Stat(filepath.Join(dir, f.name))
+		if exists := err == nil; exists != (i >= 2) {
+			t.Errorf("%s: exists = %t", f.name, exists)
+		}
+	}
+}
+
+func TestManager_EnforceStaleTemp(t *testing.T) {
+	dir := t.TempDir()
+	m := &Manager{Dir: dir, MaxBytes: 30}
+	m.Recover = func(tmp string) (string, bool) {
+		if !strings.Contains(tmp, ".jsonl") {
+			return "", false
+		}
+		name, err := Recover(tmp)
+		return name, err == nil
+	}
+	now := time.Now()
+	for _, f := range []struct {
+		name string
+		age  time.Duration
+	}{
+		{"2024/01/01/.partial.json.456.tmp", 4 * time.Hour},
+		{"2024/01/02/.crashed.jsonl.123.tmp", 3 * time.Hour},
+		{"2024/01/02/old.json", 2 * time.Hour},
+		{"2024/01/03/new.json", time.Hour},
+	} {
+		name := filepath.Join(dir, f.name)
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, make([]byte, 10), 0644); err != nil {
+			t.Fatal(err)
+		}
+		mtime := now.Add(-f.age)
+		if err := os.Chtimes(name, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Enforce(); err != nil {
+		t.Fatalf("Enforce() error = %v", err)
+	}
+	// 40 bytes must go down to 30: the oldest file, a stale temporary file
+	// that cannot be recovered, is deleted, while the next one is recovered
+	// and kept like the other results.
+	for name, want := range map[string]bool{
+		"2024/01/01/.partial.json.456.tmp":  false,
+		"2024/01/02/.crashed.jsonl.123.tmp": false,
+		"2024/01/02/crashed.jsonl":          true,
+		"2024/01/02/old.json":               true,
+		"2024/01/03/new.json":               true,
+	} {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if exists := err == nil; exists != want {
+			t.Errorf("%s: exists = %t, want %t", name, exists, want)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+package spool
+
+import "syscall"
+
+// freeSpace returns the bytes and inodes available to unprivileged users in
+// the file system containing dir.
+func freeSpace(dir string) (uint64, uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, 0, err
+	}
+	return st.Bavail * uint64(st.Bsize), st.Ffree, nil
+}